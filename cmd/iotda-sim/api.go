@@ -0,0 +1,339 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/golang/glog"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultRequestTimeout = 20 * time.Second
+
+// apiServer 提供管理平台的REST接口以及文件上传下载服务
+type apiServer struct {
+	platform *platform
+	fileDir  string
+}
+
+func newApiServer(platform *platform, fileDir string) http.Handler {
+	server := &apiServer{
+		platform: platform,
+		fileDir:  fileDir,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/devices", server.handleDevices)
+	mux.HandleFunc("/api/devices/", server.handleDevice)
+	mux.HandleFunc("/files/", server.handleFile)
+	return mux
+}
+
+// handleDevices GET 查询设备列表，POST 注册设备
+func (s *apiServer) handleDevices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var devices []deviceState
+		for _, id := range s.platform.deviceIds() {
+			if device, _, ok := s.platform.device(id); ok {
+				devices = append(devices, device)
+			}
+		}
+		writeJson(w, http.StatusOK, devices)
+	case http.MethodPost:
+		record := deviceRecord{}
+		if !readJson(w, r, &record) {
+			return
+		}
+		record, err := s.platform.register(record)
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJson(w, http.StatusCreated, record)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDevice 处理/api/devices/{device_id}/{action}
+func (s *apiServer) handleDevice(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/devices/"), "/", 2)
+	deviceId, err := url.PathUnescape(parts[0])
+	if err != nil || len(deviceId) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("invalid device id"))
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	device, messages, ok := s.platform.device(deviceId)
+	if !ok {
+		writeError(w, http.StatusNotFound, errDeviceNotFound)
+		return
+	}
+
+	switch r.Method + " " + action {
+	case "GET ":
+		writeJson(w, http.StatusOK, device)
+	case "DELETE ":
+		s.platform.unregister(deviceId)
+		w.WriteHeader(http.StatusNoContent)
+	case "GET shadow":
+		writeJson(w, http.StatusOK, s.platform.shadow(deviceId, r.URL.Query().Get("service_id")))
+	case "GET messages":
+		writeJson(w, http.StatusOK, messages)
+	case "POST messages":
+		s.sendMessage(w, r, deviceId)
+	case "POST commands":
+		request := map[string]interface{}{}
+		if readJson(w, r, &request) {
+			s.request(w, r, deviceId, "commands", request)
+		}
+	case "PUT properties":
+		request := map[string]interface{}{}
+		if readJson(w, r, &request) {
+			s.request(w, r, deviceId, "properties/set", request)
+		}
+	case "GET properties":
+		request := map[string]interface{}{
+			"service_id": r.URL.Query().Get("service_id"),
+		}
+		s.request(w, r, deviceId, "properties/get", request)
+	case "POST events":
+		entry := model.DataEntry{}
+		if readJson(w, r, &entry) {
+			s.sendEvent(w, deviceId, entry.ServiceId, entry.EventType, entry.Paras)
+		}
+	case "POST ota":
+		s.upgrade(w, r, deviceId)
+	case "POST ota/version-query":
+		s.sendEvent(w, deviceId, "$ota", "version_query", struct{}{})
+	case "GET sub-devices":
+		writeJson(w, http.StatusOK, s.platform.subDevices(deviceId))
+	case "POST sub-devices/add-notify":
+		s.notifySubDevicesAdd(w, r, deviceId)
+	case "POST sub-devices/delete-notify":
+		s.notifySubDevicesDelete(w, r, deviceId)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("unknown action "+r.Method+" "+action))
+	}
+}
+
+// sendMessage 下发消息，请求体为{"topic": "自定义topic，可选", "payload": 任意json}
+func (s *apiServer) sendMessage(w http.ResponseWriter, r *http.Request, deviceId string) {
+	request := struct {
+		Topic   string          `json:"topic"`
+		Payload json.RawMessage `json:"payload"`
+	}{}
+	if !readJson(w, r, &request) {
+		return
+	}
+	payload := []byte(request.Payload)
+	// 字符串形式的payload直接下发原始内容
+	var text string
+	if json.Unmarshal(request.Payload, &text) == nil {
+		payload = []byte(text)
+	}
+	if err := s.platform.sendMessage(deviceId, request.Topic, payload); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// request 下发请求并同步等待设备响应，超时时间通过timeout参数指定，如?timeout=10s
+func (s *apiServer) request(w http.ResponseWriter, r *http.Request, deviceId, requestType string, request map[string]interface{}) {
+	timeout := defaultRequestTimeout
+	if value := r.URL.Query().Get("timeout"); len(value) != 0 {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		timeout = parsed
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	response, err := s.platform.request(ctx, deviceId, requestType, request)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeError(w, http.StatusGatewayTimeout, err)
+			return
+		}
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(response)
+}
+
+// upgrade 下发升级通知，请求体为model.UpgradeInfo，event_type默认为firmware_upgrade
+func (s *apiServer) upgrade(w http.ResponseWriter, r *http.Request, deviceId string) {
+	request := struct {
+		model.UpgradeInfo
+		EventType string `json:"event_type"`
+	}{}
+	if !readJson(w, r, &request) {
+		return
+	}
+	switch request.EventType {
+	case "":
+		request.EventType = "firmware_upgrade"
+	case "firmware_upgrade", "firmware_upgrade_v2", "software_upgrade", "software_upgrade_v2":
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("unknown upgrade event type "+request.EventType))
+		return
+	}
+	s.sendEvent(w, deviceId, "$ota", request.EventType, request.UpgradeInfo)
+}
+
+func (s *apiServer) notifySubDevicesAdd(w http.ResponseWriter, r *http.Request, gatewayId string) {
+	request := model.SubDeviceInfo{}
+	if !readJson(w, r, &request) {
+		return
+	}
+	var devices []model.DeviceInfo
+	for _, info := range request.Devices {
+		record, err := s.platform.register(deviceRecord{
+			DeviceId:       info.DeviceId,
+			ProductId:      info.ProductId,
+			NodeId:         info.NodeId,
+			Name:           info.Name,
+			ParentDeviceId: gatewayId,
+		})
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		info.DeviceId = record.DeviceId
+		info.ParentDeviceId = gatewayId
+		devices = append(devices, info)
+	}
+	s.platform.notifySubDevices(gatewayId, "add_sub_device_notify", devices)
+	writeJson(w, http.StatusOK, devices)
+}
+
+func (s *apiServer) notifySubDevicesDelete(w http.ResponseWriter, r *http.Request, gatewayId string) {
+	request := struct {
+		Devices []string `json:"devices"`
+	}{}
+	if !readJson(w, r, &request) {
+		return
+	}
+	var devices []model.DeviceInfo
+	for _, deviceId := range request.Devices {
+		device, _, ok := s.platform.device(deviceId)
+		if !ok || device.ParentDeviceId != gatewayId {
+			writeError(w, http.StatusNotFound, errors.New("sub device "+deviceId+" not found"))
+			return
+		}
+		s.platform.unregister(deviceId)
+		devices = append(devices, model.DeviceInfo{
+			ParentDeviceId: gatewayId,
+			DeviceId:       deviceId,
+			NodeId:         device.NodeId,
+			ProductId:      device.ProductId,
+		})
+	}
+	s.platform.notifySubDevices(gatewayId, "delete_sub_device_notify", devices)
+	writeJson(w, http.StatusOK, devices)
+}
+
+func (s *apiServer) sendEvent(w http.ResponseWriter, deviceId, serviceId, eventType string, paras interface{}) {
+	if err := s.platform.sendEvent(deviceId, serviceId, eventType, paras); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleFile 文件上传(PUT)和下载(GET)，路径为/files/{device_id}/{file_name}
+func (s *apiServer) handleFile(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/files/"), "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 || strings.Contains(parts[1], "/") ||
+		parts[0] == ".." || parts[1] == ".." {
+		writeError(w, http.StatusBadRequest, errors.New("invalid file path"))
+		return
+	}
+	path := filepath.Join(s.fileDir, parts[0], parts[1])
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer file.Close()
+		if _, err := io.Copy(file, r.Body); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		glog.Infof("device %s uploaded file %s", parts[0], parts[1])
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		http.ServeFile(w, r, path)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func readJson(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{
+		"error_msg": err.Error(),
+	})
+}
@@ -0,0 +1,305 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/golang/glog"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/transport"
+	"net"
+	"sync"
+	"time"
+)
+
+var errSessionClosed = errors.New("session is closed")
+
+// session 一个设备的MQTT连接
+type session struct {
+	deviceId      string
+	clientId      string
+	conn          net.Conn
+	writeLock     sync.Mutex
+	lock          sync.RWMutex
+	subscriptions map[string]byte
+	messageId     uint16
+	closed        bool
+}
+
+func (s *session) write(packet packets.ControlPacket) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if s.closed {
+		return errSessionClosed
+	}
+	return packet.Write(s.conn)
+}
+
+// publish 向设备发送消息，qos1的消息不等待设备的PUBACK
+func (s *session) publish(topic string, qos byte, payload []byte) error {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = topic
+	packet.Qos = qos
+	packet.Payload = payload
+	if qos > 0 {
+		s.lock.Lock()
+		s.messageId++
+		if s.messageId == 0 {
+			s.messageId = 1
+		}
+		packet.MessageID = s.messageId
+		s.lock.Unlock()
+	}
+	return s.write(packet)
+}
+
+func (s *session) close() {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	_ = s.conn.Close()
+}
+
+func (s *session) subscribedQos(topic string) (byte, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	matched := false
+	var qos byte
+	for filter, filterQos := range s.subscriptions {
+		if transport.MatchTopic(filter, topic) {
+			matched = true
+			if filterQos > qos {
+				qos = filterQos
+			}
+		}
+	}
+	return qos, matched
+}
+
+// broker 实现设备接入所需的MQTT3.1.1子集：CONNECT、PUBLISH(qos0/1)、SUBSCRIBE、UNSUBSCRIBE、PINGREQ、DISCONNECT
+type broker struct {
+	platform *platform
+	lock     sync.RWMutex
+	sessions map[string]*session
+}
+
+func newBroker(platform *platform) *broker {
+	return &broker{
+		platform: platform,
+		sessions: make(map[string]*session),
+	}
+}
+
+func (b *broker) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			glog.Warningf("accept connection failed. err: %s", err.Error())
+			return
+		}
+		go b.handleConn(conn)
+	}
+}
+
+func (b *broker) handleConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	packet, err := packets.ReadPacket(conn)
+	if err != nil {
+		glog.Warningf("read connect packet failed. remote: %s, err: %s", conn.RemoteAddr(), err.Error())
+		return
+	}
+	connect, ok := packet.(*packets.ConnectPacket)
+	if !ok {
+		glog.Warningf("first packet is not connect. remote: %s", conn.RemoteAddr())
+		return
+	}
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	connack.ReturnCode = connect.Validate()
+	if connack.ReturnCode == packets.Accepted {
+		connack.ReturnCode = b.platform.authenticate(connect, hasPeerCertificate(conn))
+	}
+	if connack.ReturnCode != packets.Accepted {
+		glog.Warningf("reject device %s. client id: %s, code: %d", connect.Username, connect.ClientIdentifier, connack.ReturnCode)
+		_ = connack.Write(conn)
+		return
+	}
+
+	s := &session{
+		deviceId:      connect.Username,
+		clientId:      connect.ClientIdentifier,
+		conn:          conn,
+		subscriptions: make(map[string]byte),
+	}
+	if err := s.write(connack); err != nil {
+		return
+	}
+	b.addSession(s)
+	glog.Infof("device %s connected. client id: %s", s.deviceId, s.clientId)
+	defer func() {
+		s.close()
+		if b.removeSession(s) {
+			b.platform.deviceOffline(s.deviceId)
+		}
+		glog.Infof("device %s disconnected.", s.deviceId)
+	}()
+	b.platform.deviceOnline(s.deviceId)
+
+	keepAlive := time.Duration(connect.Keepalive) * time.Second
+	for {
+		if keepAlive > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := packet.(type) {
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				if err := s.write(puback); err != nil {
+					return
+				}
+			}
+			b.route(s, p.TopicName, p.Payload)
+		case *packets.SubscribePacket:
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			s.lock.Lock()
+			for i, topic := range p.Topics {
+				qos := p.Qoss[i]
+				if qos > 1 {
+					qos = 1
+				}
+				if !transport.ValidTopicFilter(topic) {
+					suback.ReturnCodes = append(suback.ReturnCodes, 0x80)
+					continue
+				}
+				s.subscriptions[topic] = qos
+				suback.ReturnCodes = append(suback.ReturnCodes, qos)
+			}
+			s.lock.Unlock()
+			if err := s.write(suback); err != nil {
+				return
+			}
+		case *packets.UnsubscribePacket:
+			s.lock.Lock()
+			for _, topic := range p.Topics {
+				delete(s.subscriptions, topic)
+			}
+			s.lock.Unlock()
+			unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			unsuback.MessageID = p.MessageID
+			if err := s.write(unsuback); err != nil {
+				return
+			}
+		case *packets.PingreqPacket:
+			if err := s.write(packets.NewControlPacket(packets.Pingresp)); err != nil {
+				return
+			}
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// route 设备上行消息先交给平台处理，再转发给订阅了该topic的其他连接
+func (b *broker) route(from *session, topic string, payload []byte) {
+	b.platform.handleUpstream(from.deviceId, topic, payload)
+	b.lock.RLock()
+	var targets []*session
+	var qoss []byte
+	for _, s := range b.sessions {
+		if qos, ok := s.subscribedQos(topic); ok {
+			targets = append(targets, s)
+			qoss = append(qoss, qos)
+		}
+	}
+	b.lock.RUnlock()
+	for i, target := range targets {
+		if err := target.publish(topic, qoss[i], payload); err != nil {
+			glog.Warningf("forward message to device %s failed. err: %s", target.deviceId, err.Error())
+		}
+	}
+}
+
+// send 平台向设备下发消息，设备不需要订阅系统topic
+func (b *broker) send(deviceId, topic string, payload []byte) error {
+	b.lock.RLock()
+	s, ok := b.sessions[deviceId]
+	b.lock.RUnlock()
+	if !ok {
+		return errDeviceOffline
+	}
+	return s.publish(topic, 1, payload)
+}
+
+func (b *broker) addSession(s *session) {
+	b.lock.Lock()
+	old, ok := b.sessions[s.deviceId]
+	b.sessions[s.deviceId] = s
+	b.lock.Unlock()
+	// 相同设备重复登录时踢掉旧连接
+	if ok {
+		old.close()
+	}
+}
+
+func (b *broker) removeSession(s *session) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.sessions[s.deviceId] != s {
+		return false
+	}
+	delete(b.sessions, s.deviceId)
+	return true
+}
+
+func (b *broker) isOnline(deviceId string) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	_, ok := b.sessions[deviceId]
+	return ok
+}
+
+func hasPeerCertificate(conn net.Conn) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return false
+	}
+	return len(tlsConn.ConnectionState().PeerCertificates) > 0
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// iotda-sim 本地的IoTDA平台模拟器，支持设备鉴权、设备引导、设备影子、文件上传下载和子设备管理，
+// 并通过REST接口下发命令、属性设置、OTA升级和子设备通知，用于本地开发和CI中替代真实的平台。
+//
+//	go run ./cmd/iotda-sim -device "your device id:your secret"
+//	curl -X POST localhost:8080/api/devices/{device_id}/commands -d '{"service_id":"s","command_name":"c","paras":{}}'
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// deviceFlags 通过多次指定-device id:secret注册设备
+type deviceFlags []deviceRecord
+
+func (d *deviceFlags) String() string {
+	return fmt.Sprintf("%d devices", len(*d))
+}
+
+func (d *deviceFlags) Set(value string) error {
+	index := strings.LastIndex(value, ":")
+	if index <= 0 {
+		return fmt.Errorf("device should be id:secret, got %s", value)
+	}
+	*d = append(*d, deviceRecord{DeviceId: value[:index], Secret: value[index+1:]})
+	return nil
+}
+
+func main() {
+	var devices deviceFlags
+	mqttAddr := flag.String("mqtt-addr", ":1883", "mqtt listen address, empty to disable")
+	mqttsAddr := flag.String("mqtts-addr", ":8883", "mqtts listen address, empty to disable")
+	httpAddr := flag.String("http-addr", ":8080", "rest api and file server listen address")
+	certFile := flag.String("cert", "", "server certificate, a self-signed certificate is generated if empty")
+	keyFile := flag.String("key", "", "server private key")
+	caOut := flag.String("ca-out", "iotda-sim-ca.pem", "where to write the generated self-signed certificate")
+	devicesFile := flag.String("devices", "", "json file with devices to register, [{\"device_id\":\"\",\"secret\":\"\"}]")
+	autoRegister := flag.Bool("auto-register", false, "accept unknown devices without checking the password")
+	bootstrapAddress := flag.String("bootstrap-address", "", "address returned to bootstrap requests, defaults to localhost with the mqtts port")
+	fileBaseUrl := flag.String("file-base-url", "", "base url of file upload and download urls, defaults to http://localhost with the http port")
+	fileDir := flag.String("file-dir", filepath.Join(os.TempDir(), "iotda-sim-files"), "directory to store uploaded files")
+	flag.Var(&devices, "device", "device to register, id:secret, can be repeated")
+	flag.Parse()
+	defer glog.Flush()
+
+	if len(*bootstrapAddress) == 0 {
+		*bootstrapAddress = "localhost" + portOf(*mqttsAddr)
+	}
+	if len(*fileBaseUrl) == 0 {
+		*fileBaseUrl = "http://localhost" + portOf(*httpAddr)
+	}
+	simPlatform := newPlatform(*autoRegister, *bootstrapAddress, strings.TrimSuffix(*fileBaseUrl, "/"))
+	if len(*devicesFile) != 0 {
+		content, err := ioutil.ReadFile(*devicesFile)
+		if err != nil {
+			glog.Exitf("read devices file failed. err: %s", err.Error())
+		}
+		var records []deviceRecord
+		if err := json.Unmarshal(content, &records); err != nil {
+			glog.Exitf("parse devices file failed. err: %s", err.Error())
+		}
+		devices = append(devices, records...)
+	}
+	for _, record := range devices {
+		if _, err := simPlatform.register(record); err != nil {
+			glog.Exitf("register device %s failed. err: %s", record.DeviceId, err.Error())
+		}
+	}
+	simBroker := newBroker(simPlatform)
+	simPlatform.sender = simBroker.send
+
+	if len(*mqttAddr) != 0 {
+		listener, err := net.Listen("tcp", *mqttAddr)
+		if err != nil {
+			glog.Exitf("listen mqtt failed. err: %s", err.Error())
+		}
+		glog.Infof("mqtt listen on %s", listener.Addr())
+		go simBroker.serve(listener)
+	}
+	if len(*mqttsAddr) != 0 {
+		certificate, err := loadOrGenerateCertificate(*certFile, *keyFile, *caOut)
+		if err != nil {
+			glog.Exitf("load server certificate failed. err: %s", err.Error())
+		}
+		listener, err := tls.Listen("tcp", *mqttsAddr, &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			ClientAuth:   tls.RequestClientCert,
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err != nil {
+			glog.Exitf("listen mqtts failed. err: %s", err.Error())
+		}
+		glog.Infof("mqtts listen on %s, server ca: %s", listener.Addr(), *caOut)
+		go simBroker.serve(listener)
+	}
+	go func() {
+		glog.Infof("rest api listen on %s", *httpAddr)
+		if err := http.ListenAndServe(*httpAddr, newApiServer(simPlatform, *fileDir)); err != nil {
+			glog.Exitf("listen http failed. err: %s", err.Error())
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+}
+
+func portOf(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return ":" + port
+}
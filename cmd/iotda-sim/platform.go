@@ -0,0 +1,646 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/golang/glog"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxHistoryMessages = 100
+
+var (
+	errDeviceOffline  = errors.New("device is offline")
+	errDeviceNotFound = errors.New("device not found")
+	errDeviceExisted  = errors.New("device already exists")
+)
+
+// deviceRecord 设备注册信息
+type deviceRecord struct {
+	DeviceId       string `json:"device_id"`
+	Secret         string `json:"secret,omitempty"`
+	ProductId      string `json:"product_id,omitempty"`
+	NodeId         string `json:"node_id,omitempty"`
+	Name           string `json:"name,omitempty"`
+	ParentDeviceId string `json:"parent_device_id,omitempty"`
+}
+
+// upstreamMessage 设备上报的一条消息
+type upstreamMessage struct {
+	Topic   string    `json:"topic"`
+	Payload string    `json:"payload"`
+	Time    time.Time `json:"time"`
+}
+
+// deviceState 设备在平台侧的状态
+type deviceState struct {
+	deviceRecord
+	Online          bool                               `json:"online"`
+	Status          string                             `json:"status,omitempty"`
+	SwVersion       string                             `json:"sw_version,omitempty"`
+	FwVersion       string                             `json:"fw_version,omitempty"`
+	UpgradeProgress *model.UpgradeProgress             `json:"upgrade_progress,omitempty"`
+	Shadow          map[string]*model.DeviceShadowData `json:"shadow"`
+	messages        []upstreamMessage
+}
+
+// platform 模拟IoTDA平台侧的业务：设备鉴权、设备引导、设备影子、时间同步、文件上传下载、子设备管理以及命令和属性的请求响应
+type platform struct {
+	lock             sync.Mutex
+	devices          map[string]*deviceState
+	pending          map[string]chan json.RawMessage
+	requestId        int64
+	subDeviceVersion int
+	autoRegister     bool
+	bootstrapAddress string
+	fileBaseUrl      string
+	sender           func(deviceId, topic string, payload []byte) error
+}
+
+func newPlatform(autoRegister bool, bootstrapAddress, fileBaseUrl string) *platform {
+	return &platform{
+		devices:          make(map[string]*deviceState),
+		pending:          make(map[string]chan json.RawMessage),
+		autoRegister:     autoRegister,
+		bootstrapAddress: bootstrapAddress,
+		fileBaseUrl:      fileBaseUrl,
+	}
+}
+
+// register 注册设备，设备ID为空时按产品ID和节点ID生成
+func (p *platform) register(record deviceRecord) (deviceRecord, error) {
+	if len(record.DeviceId) == 0 {
+		if len(record.ProductId) == 0 || len(record.NodeId) == 0 {
+			return record, errors.New("device_id or product_id and node_id is required")
+		}
+		record.DeviceId = record.ProductId + "_" + record.NodeId
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.devices[record.DeviceId]; ok {
+		return record, errDeviceExisted
+	}
+	p.devices[record.DeviceId] = &deviceState{
+		deviceRecord: record,
+		Shadow:       make(map[string]*model.DeviceShadowData),
+	}
+	return record, nil
+}
+
+func (p *platform) unregister(deviceId string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.devices[deviceId]; !ok {
+		return false
+	}
+	delete(p.devices, deviceId)
+	return true
+}
+
+// authenticate 校验设备的client id和密码，密码为HmacSha256(设备密钥, client id中的时间戳)
+func (p *platform) authenticate(connect *packets.ConnectPacket, hasCertificate bool) byte {
+	deviceId := connect.Username
+	if !strings.HasPrefix(connect.ClientIdentifier, deviceId+"_") {
+		return packets.ErrRefusedIDRejected
+	}
+	segments := strings.Split(strings.TrimPrefix(connect.ClientIdentifier, deviceId+"_"), "_")
+	if len(segments) != 3 {
+		return packets.ErrRefusedIDRejected
+	}
+	timestamp := segments[2]
+	if segments[1] == "1" && !validTimestamp(timestamp) {
+		return packets.ErrRefusedBadUsernameOrPassword
+	}
+
+	p.lock.Lock()
+	device, ok := p.devices[deviceId]
+	p.lock.Unlock()
+	if !ok {
+		if !p.autoRegister {
+			return packets.ErrRefusedNotAuthorised
+		}
+		glog.Infof("auto register device %s", deviceId)
+		_, _ = p.register(deviceRecord{DeviceId: deviceId})
+		return packets.Accepted
+	}
+	if len(device.Secret) == 0 {
+		// 未设置密钥的设备使用证书认证
+		if hasCertificate || p.autoRegister {
+			return packets.Accepted
+		}
+		return packets.ErrRefusedNotAuthorised
+	}
+	expected, err := iot.HmacSha256(device.Secret, timestamp)
+	if err != nil || !hmac.Equal([]byte(expected), connect.Password) {
+		return packets.ErrRefusedBadUsernameOrPassword
+	}
+	return packets.Accepted
+}
+
+// validTimestamp 校验client id中的时间戳，允许一小时的误差
+func validTimestamp(timestamp string) bool {
+	t, err := time.ParseInLocation("2006010215", timestamp, time.Local)
+	if err != nil {
+		return false
+	}
+	diff := time.Since(t)
+	return diff > -time.Hour && diff < 2*time.Hour
+}
+
+func (p *platform) deviceOnline(deviceId string) {
+	p.setOnline(deviceId, true)
+}
+
+func (p *platform) deviceOffline(deviceId string) {
+	p.setOnline(deviceId, false)
+}
+
+func (p *platform) setOnline(deviceId string, online bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if device, ok := p.devices[deviceId]; ok {
+		device.Online = online
+	}
+}
+
+// handleUpstream 处理设备上行消息
+func (p *platform) handleUpstream(deviceId, topic string, payload []byte) {
+	p.record(deviceId, topic, payload)
+	prefix := "$oc/devices/" + deviceId + "/sys/"
+	if !strings.HasPrefix(topic, prefix) {
+		return
+	}
+	path := strings.TrimPrefix(topic, prefix)
+	switch {
+	case path == "properties/report":
+		properties := &model.DeviceProperties{}
+		if json.Unmarshal(payload, properties) == nil {
+			p.updateShadow(deviceId, properties.Services)
+		}
+	case path == "gateway/sub_devices/properties/report":
+		devices := &model.DevicesService{}
+		if json.Unmarshal(payload, devices) == nil {
+			for _, device := range devices.Devices {
+				p.updateShadow(device.DeviceId, device.Services)
+			}
+		}
+	case strings.HasPrefix(path, "shadow/get/request_id="):
+		p.handleShadowQuery(deviceId, iot.GetTopicRequestId(topic), payload)
+	case strings.HasPrefix(path, "commands/response/request_id="),
+		strings.HasPrefix(path, "properties/set/response/request_id="),
+		strings.HasPrefix(path, "properties/get/response/request_id="):
+		p.complete(iot.GetTopicRequestId(topic), payload)
+	case path == "events/up":
+		data := &model.Data{}
+		if json.Unmarshal(payload, data) != nil {
+			return
+		}
+		objectDeviceId := data.ObjectDeviceId
+		if len(objectDeviceId) == 0 {
+			objectDeviceId = deviceId
+		}
+		for _, entry := range data.Services {
+			p.handleEvent(deviceId, objectDeviceId, entry)
+		}
+	case path == "bootstrap/up":
+		response := map[string]string{
+			"address": p.bootstrapAddress,
+		}
+		p.publishJson(deviceId, "$oc/devices/"+deviceId+"/sys/bootstrap/down", response)
+	}
+}
+
+func (p *platform) record(deviceId, topic string, payload []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	device, ok := p.devices[deviceId]
+	if !ok {
+		return
+	}
+	device.messages = append(device.messages, upstreamMessage{
+		Topic:   topic,
+		Payload: string(payload),
+		Time:    time.Now(),
+	})
+	if len(device.messages) > maxHistoryMessages {
+		device.messages = device.messages[len(device.messages)-maxHistoryMessages:]
+	}
+}
+
+func (p *platform) updateShadow(deviceId string, services []model.DevicePropertyEntry) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	device, ok := p.devices[deviceId]
+	if !ok {
+		return
+	}
+	for _, service := range services {
+		shadow, ok := device.Shadow[service.ServiceId]
+		if !ok {
+			shadow = &model.DeviceShadowData{ServiceId: service.ServiceId}
+			device.Shadow[service.ServiceId] = shadow
+		}
+		shadow.Reported = model.DeviceShadowPropertiesData{
+			Properties: mergeProperties(shadow.Reported.Properties, service.Properties),
+			EventTime:  service.EventTime,
+		}
+		if len(shadow.Reported.EventTime) == 0 {
+			shadow.Reported.EventTime = iot.GetEventTimeStamp()
+		}
+		shadow.Version++
+	}
+}
+
+// mergeProperties 属性上报只携带变化的属性，需要与已有的属性合并
+func mergeProperties(old, reported interface{}) interface{} {
+	oldMap, ok := old.(map[string]interface{})
+	if !ok {
+		oldMap = make(map[string]interface{})
+	}
+	var reportedMap map[string]interface{}
+	if json.Unmarshal([]byte(iot.Interface2JsonString(reported)), &reportedMap) != nil {
+		return reported
+	}
+	for key, value := range reportedMap {
+		oldMap[key] = value
+	}
+	return oldMap
+}
+
+func (p *platform) handleShadowQuery(deviceId, requestId string, payload []byte) {
+	request := &model.DevicePropertyQueryRequest{}
+	_ = json.Unmarshal(payload, request)
+	objectDeviceId := request.ObjectDeviceId
+	if len(objectDeviceId) == 0 {
+		objectDeviceId = deviceId
+	}
+	response := model.DeviceShadowQueryResponse{
+		ObjectDeviceId: objectDeviceId,
+		Shadow:         p.shadow(objectDeviceId, request.ServiceId),
+	}
+	p.publishJson(deviceId, "$oc/devices/"+deviceId+"/sys/shadow/get/response/request_id="+requestId, response)
+}
+
+func (p *platform) shadow(deviceId, serviceId string) []model.DeviceShadowData {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	device, ok := p.devices[deviceId]
+	if !ok {
+		return nil
+	}
+	shadows := make([]model.DeviceShadowData, 0, len(device.Shadow))
+	for _, shadow := range device.Shadow {
+		if len(serviceId) != 0 && shadow.ServiceId != serviceId {
+			continue
+		}
+		shadows = append(shadows, *shadow)
+	}
+	sort.Slice(shadows, func(i, j int) bool {
+		return shadows[i].ServiceId < shadows[j].ServiceId
+	})
+	return shadows
+}
+
+func (p *platform) handleEvent(deviceId, objectDeviceId string, entry model.DataEntry) {
+	paras := iot.Interface2JsonString(entry.Paras)
+	switch entry.ServiceId + "/" + entry.EventType {
+	case "$time_sync/time_sync":
+		request := &model.TimeSyncRequestServiceEventParas{}
+		_ = json.Unmarshal([]byte(paras), request)
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		p.sendEvent(objectDeviceId, "$time_sync", "time_sync_response", model.TimeSyncResponse{
+			DeviceSendTime: request.DeviceSendTime,
+			ServerRecvTime: now,
+			ServerSendTime: now,
+		})
+	case "$file_manager/get_upload_url", "$file_manager/get_download_url":
+		request := &model.FileRequestServiceEventParas{}
+		_ = json.Unmarshal([]byte(paras), request)
+		p.sendEvent(objectDeviceId, "$file_manager", entry.EventType+"_response", model.FileResponseServiceEventParas{
+			Url:            p.fileBaseUrl + "/files/" + url.PathEscape(objectDeviceId) + "/" + url.PathEscape(request.FileName),
+			BucketName:     "iotda-sim",
+			ObjectName:     request.FileName,
+			Expire:         3600,
+			FileAttributes: request.FileAttributes,
+		})
+	case "$ota/version_report":
+		versions := &struct {
+			SwVersion string `json:"sw_version"`
+			FwVersion string `json:"fw_version"`
+		}{}
+		_ = json.Unmarshal([]byte(paras), versions)
+		p.updateDevice(objectDeviceId, func(device *deviceState) {
+			device.SwVersion = versions.SwVersion
+			device.FwVersion = versions.FwVersion
+		})
+	case "$ota/upgrade_progress_report":
+		progress := &model.UpgradeProgress{}
+		_ = json.Unmarshal([]byte(paras), progress)
+		p.updateDevice(objectDeviceId, func(device *deviceState) {
+			device.UpgradeProgress = progress
+		})
+	case "$sub_device_manager/add_sub_device_request":
+		p.handleAddSubDevices(deviceId, paras)
+	case "$sub_device_manager/delete_sub_device_request":
+		p.handleDeleteSubDevices(deviceId, paras)
+	case "$sub_device_manager/sub_device_update_status":
+		p.handleUpdateSubDeviceStatus(deviceId, paras)
+	case "$sub_device_manager/sub_device_sync_request":
+		p.notifySubDevices(deviceId, "add_sub_device_notify", p.subDevices(deviceId))
+	}
+}
+
+func (p *platform) handleAddSubDevices(gatewayId, paras string) {
+	request := &model.SubDeviceInfo{}
+	_ = json.Unmarshal([]byte(paras), request)
+	response := model.SubDeviceAddResponse{
+		SuccessFulDevices: []model.DeviceInfo{},
+		FailedDevices:     []model.FailedDevice{},
+	}
+	for _, info := range request.Devices {
+		record, err := p.register(deviceRecord{
+			DeviceId:       info.DeviceId,
+			ProductId:      info.ProductId,
+			NodeId:         info.NodeId,
+			Name:           info.Name,
+			ParentDeviceId: gatewayId,
+		})
+		if err != nil {
+			response.FailedDevices = append(response.FailedDevices, model.FailedDevice{
+				DeviceId:  record.DeviceId,
+				ProductId: info.ProductId,
+				NodeId:    info.NodeId,
+				ErrorCode: "IOTDA.SIM.ADD_FAILED",
+				ErrorMsg:  err.Error(),
+			})
+			continue
+		}
+		info.DeviceId = record.DeviceId
+		info.ParentDeviceId = gatewayId
+		response.SuccessFulDevices = append(response.SuccessFulDevices, info)
+	}
+	p.sendEvent(gatewayId, "$sub_device_manager", "add_sub_device_response", response)
+}
+
+func (p *platform) handleDeleteSubDevices(gatewayId, paras string) {
+	request := &struct {
+		Devices []string `json:"devices"`
+	}{}
+	_ = json.Unmarshal([]byte(paras), request)
+	response := model.SubDeviceDeleteResponse{
+		SuccessFulDevices: []string{},
+		FailedDevices:     []model.FailedDevice{},
+	}
+	for _, deviceId := range request.Devices {
+		if p.parentOf(deviceId) != gatewayId || !p.unregister(deviceId) {
+			response.FailedDevices = append(response.FailedDevices, model.FailedDevice{
+				DeviceId:  deviceId,
+				ErrorCode: "IOTDA.SIM.DELETE_FAILED",
+				ErrorMsg:  errDeviceNotFound.Error(),
+			})
+			continue
+		}
+		response.SuccessFulDevices = append(response.SuccessFulDevices, deviceId)
+	}
+	p.sendEvent(gatewayId, "$sub_device_manager", "delete_sub_device_response", response)
+}
+
+func (p *platform) handleUpdateSubDeviceStatus(gatewayId, paras string) {
+	request := &model.SubDevicesStatus{}
+	_ = json.Unmarshal([]byte(paras), request)
+	response := model.SubDeviceStatusResp{
+		SuccessfulDevices: []model.SuccessDevice{},
+		FailedDevices:     []model.FailedDevice{},
+	}
+	for _, status := range request.DeviceStatuses {
+		if p.parentOf(status.DeviceId) != gatewayId {
+			response.FailedDevices = append(response.FailedDevices, model.FailedDevice{
+				DeviceId:  status.DeviceId,
+				ErrorCode: "IOTDA.SIM.UPDATE_FAILED",
+				ErrorMsg:  errDeviceNotFound.Error(),
+			})
+			continue
+		}
+		p.updateDevice(status.DeviceId, func(device *deviceState) {
+			device.Status = status.Status
+			device.Online = status.Status == "ONLINE"
+		})
+		response.SuccessfulDevices = append(response.SuccessfulDevices, model.SuccessDevice{
+			DeviceId: status.DeviceId,
+			Status:   status.Status,
+		})
+	}
+	p.sendEvent(gatewayId, "$sub_device_manager", "sub_device_update_status_response", response)
+}
+
+// notifySubDevices 通知网关子设备变更，eventType为add_sub_device_notify或者delete_sub_device_notify
+func (p *platform) notifySubDevices(gatewayId, eventType string, devices []model.DeviceInfo) {
+	p.lock.Lock()
+	p.subDeviceVersion++
+	version := p.subDeviceVersion
+	p.lock.Unlock()
+	p.sendEvent(gatewayId, "$sub_device_manager", eventType, model.SubDeviceInfo{
+		Devices: devices,
+		Version: version,
+	})
+}
+
+func (p *platform) subDevices(gatewayId string) []model.DeviceInfo {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	devices := []model.DeviceInfo{}
+	for _, device := range p.devices {
+		if device.ParentDeviceId != gatewayId {
+			continue
+		}
+		devices = append(devices, model.DeviceInfo{
+			ParentDeviceId: gatewayId,
+			NodeId:         device.NodeId,
+			DeviceId:       device.DeviceId,
+			Name:           device.Name,
+			ProductId:      device.ProductId,
+			Status:         device.Status,
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].DeviceId < devices[j].DeviceId
+	})
+	return devices
+}
+
+func (p *platform) parentOf(deviceId string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if device, ok := p.devices[deviceId]; ok {
+		return device.ParentDeviceId
+	}
+	return ""
+}
+
+func (p *platform) updateDevice(deviceId string, update func(device *deviceState)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if device, ok := p.devices[deviceId]; ok {
+		update(device)
+	}
+}
+
+// connectionOf 子设备的消息通过网关的连接收发
+func (p *platform) connectionOf(deviceId string) string {
+	if parent := p.parentOf(deviceId); len(parent) != 0 {
+		return parent
+	}
+	return deviceId
+}
+
+// sendEvent 向设备下发事件，子设备的事件通过网关下发
+func (p *platform) sendEvent(deviceId, serviceId, eventType string, paras interface{}) error {
+	connectionId := p.connectionOf(deviceId)
+	data := model.Data{
+		ObjectDeviceId: deviceId,
+		Services: []model.DataEntry{
+			{
+				ServiceId: serviceId,
+				EventType: eventType,
+				EventTime: iot.GetEventTimeStamp(),
+				Paras:     paras,
+			},
+		},
+	}
+	return p.publishJson(connectionId, "$oc/devices/"+connectionId+"/sys/events/down", data)
+}
+
+// sendMessage 向设备下发消息，topic为空时使用系统的消息下发topic
+func (p *platform) sendMessage(deviceId, topic string, payload []byte) error {
+	connectionId := p.connectionOf(deviceId)
+	if len(topic) == 0 {
+		topic = "$oc/devices/" + connectionId + "/sys/messages/down"
+	}
+	return p.sender(connectionId, topic, payload)
+}
+
+// request 向设备下发命令或者属性设置、查询请求并等待设备响应，requestType为commands、properties/set或properties/get
+func (p *platform) request(ctx context.Context, deviceId, requestType string, request map[string]interface{}) (json.RawMessage, error) {
+	connectionId := p.connectionOf(deviceId)
+	request["object_device_id"] = deviceId
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	p.requestId++
+	requestId := strconv.FormatInt(p.requestId, 10)
+	response := make(chan json.RawMessage, 1)
+	p.pending[requestId] = response
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		delete(p.pending, requestId)
+		p.lock.Unlock()
+	}()
+
+	topic := "$oc/devices/" + connectionId + "/sys/" + requestType + "/request_id=" + requestId
+	if err := p.sender(connectionId, topic, payload); err != nil {
+		return nil, err
+	}
+	select {
+	case result := <-response:
+		return result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *platform) complete(requestId string, payload []byte) {
+	p.lock.Lock()
+	response, ok := p.pending[requestId]
+	p.lock.Unlock()
+	if !ok {
+		glog.Warningf("no pending request for response. request id: %s", requestId)
+		return
+	}
+	select {
+	case response <- json.RawMessage(append([]byte(nil), payload...)):
+	default:
+	}
+}
+
+func (p *platform) publishJson(deviceId, topic string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := p.sender(deviceId, topic, payload); err != nil {
+		glog.Warningf("send message to device %s failed. topic: %s, err: %s", deviceId, topic, err.Error())
+		return err
+	}
+	return nil
+}
+
+// device 返回设备状态的副本
+func (p *platform) device(deviceId string) (deviceState, []upstreamMessage, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	device, ok := p.devices[deviceId]
+	if !ok {
+		return deviceState{}, nil, false
+	}
+	messages := make([]upstreamMessage, len(device.messages))
+	copy(messages, device.messages)
+	state := *device
+	state.Shadow = make(map[string]*model.DeviceShadowData, len(device.Shadow))
+	for serviceId, shadow := range device.Shadow {
+		copied := *shadow
+		state.Shadow[serviceId] = &copied
+	}
+	return state, messages, true
+}
+
+func (p *platform) deviceIds() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ids := make([]string, 0, len(p.devices))
+	for id := range p.devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -63,6 +63,9 @@ type DeviceUpgradeHandler func(upgradeType byte, info model.UpgradeInfo) model.U
 // SwFwVersionReporter 设备上报软固件版本,第一个返回值为软件版本，第二个返回值为固件版本
 type SwFwVersionReporter func() (string, string)
 
+// SubDeviceVersionReporter 网关上报子设备的软固件版本，第一个返回值为软件版本，第二个返回值为固件版本
+type SubDeviceVersionReporter func(deviceId string) (string, string)
+
 // DeviceShadowQueryResponseHandler 设备获取设备影子数据
 type DeviceShadowQueryResponseHandler func(response model.DeviceShadowQueryResponse)
 
@@ -86,3 +89,6 @@ type DeviceConnectHandler func(client mqtt.Client)
 
 // SyncTimeResponseHandler 时间同步响应回调
 type SyncTimeResponseHandler func(deviceSendTime, serverRecvTime, serverSendTime int64)
+
+// FileReceivedHandler 平台主动下发的文件下载并校验完成后回调，err不为空时表示下载或校验失败
+type FileReceivedHandler func(fileName, filePath string, err error)
@@ -33,3 +33,6 @@ import "github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
 type ReportRuleDelete func(event model.DeviceEvents) bool
 
 type RuleActionHandler func(actions []model.Action) bool
+
+// RuleActionResultHandler 端侧规则的动作执行完成后调用，每个动作对应一个结果
+type RuleActionResultHandler func(results []model.RuleActionResult)
@@ -31,17 +31,19 @@ package client
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/golang/glog"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/constants"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
-	"io/ioutil"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/tlsconfig"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/transport"
+	"net"
+	"net/url"
 	"sync"
 	"time"
 )
@@ -65,6 +67,8 @@ func NewBootstrapClient(authConfig config.ConnectAuthConfig) (BootstrapClient, e
 			CertKeyPath:  authConfig.CertKeyFilePath,
 		},
 		iotdaServer: newResult(),
+		log:         authConfig.GetLogger(),
+		tls:         authConfig.TLS,
 	}
 
 	res, err := client.init()
@@ -84,6 +88,8 @@ type bsClient struct {
 	client         mqtt.Client         // 使用的MQTT客户端
 	iotdaServer    *Result             // 设备接入平台地址
 	connectTimeOut time.Duration
+	log            logger.Logger
+	tls            tlsconfig.Settings // 与平台建链共用的证书校验和代理配置
 }
 
 func (bs *bsClient) init() (bool, error) {
@@ -102,7 +108,7 @@ func (bs *bsClient) init() (bool, error) {
 		options.SetPassword("")
 		deviceCert, err := tls.LoadX509KeyPair(bs.scopeConfig.CertFilePath, bs.scopeConfig.CertKeyPath)
 		if err != nil {
-			glog.Error("load device cert failed")
+			bs.log.Error("load device cert failed", logger.Err(err))
 			panic("load device cert failed")
 		}
 		clientCerts = append(clientCerts, deviceCert)
@@ -112,37 +118,37 @@ func (bs *bsClient) init() (bool, error) {
 	options.SetConnectRetry(true)
 	options.SetConnectTimeout(2 * time.Second)
 
-	ca, err := ioutil.ReadFile(bs.serverCaPath)
+	tlsConfig, err := bs.tls.MqttConfig(bs.serverCaPath, clientCerts, constants.CipherSuites)
 	if err != nil {
-		glog.Error("load bs server ca failed\n")
+		bs.log.Error("load bs server ca failed", logger.Err(err))
 		panic(err)
 	}
-	serverCaPool := x509.NewCertPool()
-	serverCaPool.AppendCertsFromPEM(ca)
-
-	tlsConfig := &tls.Config{
-		RootCAs:            serverCaPool,
-		Certificates:       clientCerts,
-		InsecureSkipVerify: true,
-		MaxVersion:         tls.VersionTLS13,
-		MinVersion:         tls.VersionTLS12,
-		CipherSuites:       constants.CipherSuites,
-		VerifyConnection:   iot.VerifyConnection(serverCaPool),
-	}
 	options.SetTLSConfig(tlsConfig)
+	dialer, err := bs.tls.Dialer()
+	if err != nil {
+		bs.log.Error("create proxy dialer failed", logger.Err(err))
+		return false, err
+	}
+	if dialer != nil {
+		options.SetCustomOpenConnectionFn(func(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			return transport.Dial(ctx, uri.String(), tlsConfig, dialer)
+		})
+	}
 
 	bs.client = mqtt.NewClient(options)
 	if token := bs.client.Connect(); token.WaitTimeout(bs.connectTimeOut) && token.Error() != nil {
-		glog.Warningf("device %s create bootstrap client failed,error = %v", bs.id, token.Error())
+		bs.log.Warn("create bootstrap client failed", logger.Err(token.Error()))
 		return false, token.Error()
 	}
 	downTopic := fmt.Sprintf("$oc/devices/%s/sys/bootstrap/down", bs.id)
 	subRes := bs.client.Subscribe(downTopic, 0, bs.handleSubscribeHandler())
 	if subRes.WaitTimeout(bs.connectTimeOut) && subRes.Error() != nil {
-		glog.Warningf("sub topic %s failed,error is %s\n", downTopic, subRes.Error())
+		bs.log.Warn("subscribe topic failed", logger.Topic(downTopic), logger.Err(subRes.Error()))
 		panic("sub bs topic failed.")
 	} else {
-		glog.Infof("sub topic %s success\n", downTopic)
+		bs.log.Info("subscribe topic success", logger.Topic(downTopic))
 	}
 	return true, nil
 }
@@ -150,14 +156,14 @@ func (bs *bsClient) init() (bool, error) {
 func (bs *bsClient) handleSubscribeHandler() func(client mqtt.Client, message mqtt.Message) {
 	return func(client mqtt.Client, message mqtt.Message) {
 		go func() {
-			glog.Infof("get message from bs server")
+			bs.log.Info("get message from bs server", logger.Topic(message.Topic()))
 			serverResponse := &serverResponse{}
 			err := json.Unmarshal(message.Payload(), serverResponse)
 			if err != nil {
-				glog.Warningf("subscribe bootstrap topic failed. err: %s", err.Error())
+				bs.log.Warn("decode bootstrap response failed", logger.Err(err))
 				bs.iotdaServer.CompleteError(err)
 			} else {
-				glog.Infof("bootstrap success. address is : %s", serverResponse.Address)
+				bs.log.Info("bootstrap success", logger.String("address", serverResponse.Address))
 				bs.iotdaServer.Complete(serverResponse.Address, serverResponse.DeviceSecret)
 			}
 		}()
@@ -169,7 +175,7 @@ func (bs *bsClient) generatePwd() (string, error) {
 	if bs.scopeConfig != nil && bs.scopeConfig.ScopeId != "" && bs.scopeConfig.ScopeType == constants.AuthTypePassword {
 		password, err := base64.StdEncoding.DecodeString(bs.Secret)
 		if err != nil {
-			glog.Warningf("decode Secret failed.")
+			bs.log.Warn("decode secret failed", logger.Err(err))
 			return "", err
 		}
 		newPwd, err := iot.HmacSha256(string(password), bs.id)
@@ -197,20 +203,20 @@ func (bs *bsClient) Boot(properties *model.BootStrapProperties) (string, string)
 	propertiesData := iot.Interface2JsonString(properties)
 	pubRes := bs.client.Publish(upTopic, 0, false, propertiesData)
 	if pubRes.Wait() && pubRes.Error() != nil {
-		glog.Warningf("bootstrap failed. err: %s", pubRes.Error())
+		bs.log.Warn("bootstrap failed", logger.Topic(upTopic), logger.Err(pubRes.Error()))
 		return "", ""
 	}
 	timeout, cancelFunc := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancelFunc()
 	success, err := bs.iotdaServer.WaitTimeout(timeout)
 	if err != nil {
-		glog.Warningf("wait iotdaServer response err. err: %s", err.Error())
+		bs.log.Warn("wait bootstrap response failed", logger.Err(err))
 		return "", ""
 	}
 	if success {
 		return "tls://" + bs.iotdaServer.Value(), bs.iotdaServer.secret
 	}
-	glog.Warningf("receive response from server failed. err: %s", err.Error())
+	bs.log.Warn("receive response from server failed")
 	return "", ""
 }
 
@@ -0,0 +1,208 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	uuid "github.com/satori/go.uuid"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 文件传输状态
+const (
+	FileTransferWaitingUrl = "waiting_url"
+	FileTransferRunning    = "running"
+)
+
+// ErrFileUrlExpired 平台下发的文件URL已过期
+var ErrFileUrlExpired = errors.New("file url expired")
+
+// FileTransfers 并发安全地跟踪正在进行的文件上传下载，每次传输有独立的id，
+// 同名文件的并发传输按请求顺序分配平台下发的URL，传输结束后立即删除记录
+type FileTransfers struct {
+	lock      sync.Mutex
+	transfers map[string]*FileTransfer
+	requests  *PendingRequests
+}
+
+// FileTransfer 一次文件上传或下载
+type FileTransfer struct {
+	Id        string
+	FileName  string
+	Action    string
+	StartTime time.Time
+
+	bounded  bool // 调用方的ctx已经设置了截止时间
+	lock     sync.Mutex
+	state    string
+	url      string
+	expireAt time.Time
+	request  *PendingRequest
+	cancel   context.CancelFunc
+	registry *FileTransfers
+}
+
+// FileTransferInfo 文件传输的快照
+type FileTransferInfo struct {
+	Id        string
+	FileName  string
+	Action    string
+	State     string
+	StartTime time.Time
+	ExpireAt  time.Time // URL的过期时间，未获取到URL或平台未返回过期时间时为零值
+}
+
+func NewFileTransfers(requests *PendingRequests) *FileTransfers {
+	return &FileTransfers{
+		transfers: make(map[string]*FileTransfer),
+		requests:  requests,
+	}
+}
+
+// Start 登记一次传输并注册URL响应的等待者，需要在发送获取URL请求之前调用。
+// timeout大于0时作为整个传输的截止时间，返回的ctx在超时、Cancel或Finish后取消，传输结束后必须调用Finish
+func (ft *FileTransfers) Start(ctx context.Context, fileName, action string, timeout time.Duration) (context.Context, *FileTransfer) {
+	_, bounded := ctx.Deadline()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	transfer := &FileTransfer{
+		Id:        uuid.NewV4().String(),
+		FileName:  fileName,
+		Action:    action,
+		StartTime: time.Now(),
+		bounded:   bounded,
+		state:     FileTransferWaitingUrl,
+		request:   ft.requests.Register(FileUrlKey(fileName, action)),
+		cancel:    cancel,
+		registry:  ft,
+	}
+	ft.lock.Lock()
+	ft.transfers[transfer.Id] = transfer
+	ft.lock.Unlock()
+	return ctx, transfer
+}
+
+// Cancel 取消指定id的传输，传输不存在时返回false
+func (ft *FileTransfers) Cancel(id string) bool {
+	ft.lock.Lock()
+	transfer, ok := ft.transfers[id]
+	ft.lock.Unlock()
+	if !ok {
+		return false
+	}
+	transfer.cancel()
+	return true
+}
+
+// List 返回正在进行的传输，按开始时间排序
+func (ft *FileTransfers) List() []FileTransferInfo {
+	ft.lock.Lock()
+	transfers := make([]*FileTransfer, 0, len(ft.transfers))
+	for _, transfer := range ft.transfers {
+		transfers = append(transfers, transfer)
+	}
+	ft.lock.Unlock()
+
+	infos := make([]FileTransferInfo, 0, len(transfers))
+	for _, transfer := range transfers {
+		infos = append(infos, transfer.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartTime.Before(infos[j].StartTime)
+	})
+	return infos
+}
+
+// WaitUrl 等待平台下发URL，调用Start时的ctx没有设置超时时间时最多等待timeout
+func (transfer *FileTransfer) WaitUrl(ctx context.Context, timeout time.Duration) (string, error) {
+	op := transfer.Action + " file"
+	if !transfer.bounded && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	response, err := transfer.request.Wait(ctx, 0)
+	if err != nil {
+		return "", err
+	}
+	paras := response.(model.FileResponseServiceEventParas)
+	if len(paras.Url) == 0 {
+		return "", iot.NewDeviceError(op, "", iot.ErrFileTransfer, errors.New("platform return empty url"))
+	}
+	transfer.lock.Lock()
+	defer transfer.lock.Unlock()
+	// 平台按秒下发URL的有效期
+	if paras.Expire > 0 {
+		transfer.expireAt = transfer.StartTime.Add(time.Duration(paras.Expire) * time.Second)
+		if !time.Now().Before(transfer.expireAt) {
+			return "", iot.NewDeviceError(op, "", iot.ErrFileTransfer, ErrFileUrlExpired)
+		}
+	}
+	transfer.url = paras.Url
+	transfer.state = FileTransferRunning
+	return paras.Url, nil
+}
+
+// Expired URL是否已过期，过期后不能再发起新的请求，需要重新获取URL
+func (transfer *FileTransfer) Expired() bool {
+	transfer.lock.Lock()
+	defer transfer.lock.Unlock()
+	return !transfer.expireAt.IsZero() && !time.Now().Before(transfer.expireAt)
+}
+
+func (transfer *FileTransfer) Info() FileTransferInfo {
+	transfer.lock.Lock()
+	defer transfer.lock.Unlock()
+	return FileTransferInfo{
+		Id:        transfer.Id,
+		FileName:  transfer.FileName,
+		Action:    transfer.Action,
+		State:     transfer.state,
+		StartTime: transfer.StartTime,
+		ExpireAt:  transfer.expireAt,
+	}
+}
+
+// Finish 结束传输，删除记录并取消等待，可以重复调用
+func (transfer *FileTransfer) Finish() {
+	transfer.request.Cancel()
+	transfer.cancel()
+	transfer.registry.lock.Lock()
+	delete(transfer.registry.transfers, transfer.Id)
+	transfer.registry.lock.Unlock()
+}
@@ -29,56 +29,89 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/golang/glog"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/callback"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/constants"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/file"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/metrics"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/ota"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/rule"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/store"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/transport"
 	"github.com/panjf2000/ants/v2"
-	"io/ioutil"
 	"math"
 	"math/rand"
-	"os"
-	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type MqttDeviceClient struct {
 	config.DeviceParamsConfig
-	client            mqtt.Client
-	ConnectAuthConfig *config.ConnectAuthConfig
-	RuleManageService rule.RuleManageService
-	Pool              *ants.Pool
-	Queue             *iot.CircularQueue
-	retryTimes        int64
-	calculate         int64
+	transport           transport.Transport
+	ConnectAuthConfig   *config.ConnectAuthConfig
+	RuleManageService   *rule.RuleManageService
+	RuleActionExecutors *rule.ActionExecutors // 按动作类型执行端侧规则的动作，注册的执行器覆盖内置的执行器
+	Pool                *ants.Pool
+	BufferStore         store.MessageStore
+	PendingRequests     *PendingRequests
+	FileTransfers       *FileTransfers // 正在进行的文件上传下载
+	FileReceiver        *file.Receiver // 设置后下载平台主动下发(download_url_push事件)的文件并上报下载结果
+	Subscriptions       *Subscriptions
+	StateMachine        *StateMachine
+	OtaManager          *ota.Manager          // 设置后由OtaManager下载、校验并安装升级包，否则调用DeviceUpgradeHandler
+	SubDeviceOtaManager *ota.SubDeviceManager // 网关设置后处理平台下发给子设备的升级事件
+	Metrics             metrics.Metrics       // 指标记录接口，为空时不记录
+	Logger              logger.Logger         // 设备使用的Logger，为空时使用ConnectAuthConfig.GetLogger()
+	draining            int32
 }
 
 func (mqttClient *MqttDeviceClient) Connect() bool {
+	err := mqttClient.ConnectWithContext(context.Background())
+	if err != nil {
+		mqttClient.GetLogger().Warn("connect failed", logger.Err(err))
+		return false
+	}
+	return true
+}
+
+// ConnectWithContext 与平台建链，ctx取消或超时后停止重试并返回错误
+func (mqttClient *MqttDeviceClient) ConnectWithContext(ctx context.Context) error {
+	if mqttClient.State() == StateClosed {
+		return iot.NewDeviceError("connect", "", iot.ErrClosed, nil)
+	}
+	// 建链之前恢复本地保存的规则，离线期间规则也能执行
+	mqttClient.startRules()
 	// 退避重试。默认最大退避时间30s
 	minBackoffTime := mqttClient.ConnectAuthConfig.MinBackOffTime
 	maxBackoffTime := mqttClient.ConnectAuthConfig.MaxBackOffTime
 	backOffTime := mqttClient.ConnectAuthConfig.BackOffTime
+	// 重试次数和退避指数只属于本次建链，用户调用和后台重连可能同时执行
+	var retryTimes, calculate int64
 	// 建链失败进行重试
-	internal := mqttClient.connectMqttBroker()
-	// 只有开启了自动重连，sdk还会进行自动重连
-	for !internal && *mqttClient.ConnectAuthConfig.AutoReconnect {
+	mqttClient.transition(StateConnecting, nil, 1, 0)
+	err := mqttClient.connectMqttBroker(ctx)
+	// 只有开启了自动重连，sdk还会进行自动重连. 证书加载失败时重试没有意义
+	for err != nil && *mqttClient.ConnectAuthConfig.AutoReconnect && !errors.Is(err, iot.ErrTLSLoad) {
 		lowBound := int64(float64(backOffTime) * 0.8)
 		highBound := int64(float64(backOffTime) * 1.0)
 		rand.Seed(time.Now().Unix())
 		randomBackoff := rand.Int63n(highBound - lowBound)
 		// 防止幂次方计算出现超大值
-		if mqttClient.calculate > 20 {
-			mqttClient.calculate = 0
+		if calculate > 20 {
+			calculate = 0
 		}
-		pow := math.Pow(2, float64(mqttClient.calculate))
+		pow := math.Pow(2, float64(calculate))
 		backoffWithJitter := int64(pow) * (randomBackoff + lowBound)
 
 		var waitTimeMs int64
@@ -87,117 +120,183 @@ func (mqttClient *MqttDeviceClient) Connect() bool {
 		} else {
 			waitTimeMs = minBackoffTime + backoffWithJitter
 		}
-		glog.Warningf("client will retry to reconnect after %d ms", waitTimeMs)
-		time.Sleep(time.Duration(waitTimeMs) * time.Millisecond)
-		mqttClient.retryTimes++
-		mqttClient.calculate++
-		internal = mqttClient.connectMqttBroker()
-		if !internal {
-			glog.Warningf("connect mqtt go broker retry. times: %d", mqttClient.retryTimes)
+		mqttClient.GetLogger().Warn("client will retry to reconnect", logger.Any("wait_ms", waitTimeMs))
+		waitTime := time.Duration(waitTimeMs) * time.Millisecond
+		mqttClient.transition(StateBackoff, err, retryTimes+1, waitTime)
+		timer := time.NewTimer(waitTime)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			mqttClient.transition(StateDisconnected, ctx.Err(), retryTimes+1, 0)
+			return iot.NewDeviceError("connect", "", iot.ErrNotConnected, ctx.Err())
+		case <-timer.C:
+		}
+		retryTimes++
+		calculate++
+		mqttClient.getMetrics().ReconnectAttempt(mqttClient.ConnectAuthConfig.Id, retryTimes+1)
+		mqttClient.transition(StateConnecting, nil, retryTimes+1, 0)
+		err = mqttClient.connectMqttBroker(ctx)
+		if err != nil {
+			mqttClient.GetLogger().Warn("connect mqtt broker retry failed", logger.Any("times", retryTimes), logger.Err(err))
 		}
 	}
-	mqttClient.retryTimes = 0
-	return internal
+	if err != nil {
+		mqttClient.transition(StateDisconnected, err, retryTimes+1, 0)
+		return err
+	}
+	// 建链过程中设备被关闭
+	if mqttClient.State() == StateClosed {
+		mqttClient.transport.Disconnect(0)
+		return iot.NewDeviceError("connect", "", iot.ErrClosed, nil)
+	}
+	mqttClient.transition(StateConnected, nil, retryTimes+1, 0)
+	return nil
 }
 
-func (mqttClient *MqttDeviceClient) configureTLS(options *mqtt.ClientOptions) error {
-	if !strings.ContainsAny(mqttClient.ConnectAuthConfig.Servers, "tls|ssl|mqtts") {
-		return nil
+// State 当前的连接状态
+func (mqttClient *MqttDeviceClient) State() ConnectionState {
+	if mqttClient.StateMachine == nil {
+		if mqttClient.IsConnect() {
+			return StateConnected
+		}
+		return StateDisconnected
+	}
+	return mqttClient.StateMachine.State()
+}
+
+// SubscribeStateEvents 订阅连接状态变化事件，buffer为通道容量，通道已满时丢弃事件。
+// 调用返回的函数取消订阅，设备关闭后通道也会被关闭
+func (mqttClient *MqttDeviceClient) SubscribeStateEvents(buffer int) (<-chan StateEvent, func()) {
+	if mqttClient.StateMachine == nil {
+		events := make(chan StateEvent)
+		close(events)
+		return events, func() {}
 	}
+	return mqttClient.StateMachine.Subscribe(buffer)
+}
 
-	ca, err := ioutil.ReadFile(mqttClient.ConnectAuthConfig.ServerCaPath)
-	if err != nil {
-		glog.Error("load server ca failed\n")
-		return err
+func (mqttClient *MqttDeviceClient) transition(to ConnectionState, reason error, attempt int64, nextRetry time.Duration) {
+	if mqttClient.StateMachine == nil {
+		return
+	}
+	if mqttClient.StateMachine.transition(to, reason, attempt, nextRetry) {
+		mqttClient.GetLogger().Info("connection state changed", logger.String("state", to.String()), logger.Any("attempt", attempt), logger.Err(reason))
 	}
-	serverCaPool := x509.NewCertPool()
-	serverCaPool.AppendCertsFromPEM(ca)
-	tlsConfig := &tls.Config{
-		RootCAs:            serverCaPool,
-		InsecureSkipVerify: true,
-		MaxVersion:         tls.VersionTLS13,
-		MinVersion:         tls.VersionTLS12,
-		CipherSuites:       constants.CipherSuites,
-		VerifyConnection:   iot.VerifyConnection(serverCaPool),
+}
+
+func (mqttClient *MqttDeviceClient) configureTLS() (*tls.Config, error) {
+	if !useTLS(mqttClient.ConnectAuthConfig.Servers) {
+		return nil, nil
 	}
 
+	var clientCerts []tls.Certificate
 	// 设备使用x.509证书认证
 	if mqttClient.ConnectAuthConfig.AuthType == constants.AuthTypeX509 {
-		if len(mqttClient.ConnectAuthConfig.ServerCaPath) == 0 || len(mqttClient.ConnectAuthConfig.CertFilePath) == 0 || len(mqttClient.ConnectAuthConfig.CertKeyFilePath) == 0 {
-			glog.Error("device use x.509 auth but not set cert")
-			return err
+		if len(mqttClient.ConnectAuthConfig.CertFilePath) == 0 || len(mqttClient.ConnectAuthConfig.CertKeyFilePath) == 0 {
+			mqttClient.GetLogger().Error("device use x.509 auth but not set cert")
+			return nil, iot.NewDeviceError("connect", "", iot.ErrTLSLoad, errors.New("device cert path is empty"))
 		}
 		deviceCert, err := tls.LoadX509KeyPair(mqttClient.ConnectAuthConfig.CertFilePath, mqttClient.ConnectAuthConfig.CertKeyFilePath)
 		if err != nil {
-			glog.Error("load device cert failed")
-			return err
+			mqttClient.GetLogger().Error("load device cert failed", logger.Err(err))
+			return nil, iot.NewDeviceError("connect", "", iot.ErrTLSLoad, err)
 		}
-		var clientCerts []tls.Certificate
 		clientCerts = append(clientCerts, deviceCert)
-		tlsConfig.Certificates = clientCerts
 	}
-	options.SetTLSConfig(tlsConfig)
-	return nil
+	tlsConfig, err := mqttClient.ConnectAuthConfig.TLS.MqttConfig(mqttClient.ConnectAuthConfig.ServerCaPath, clientCerts, constants.CipherSuites)
+	if err != nil {
+		mqttClient.GetLogger().Error("load server ca failed", logger.Err(err))
+		return nil, iot.NewDeviceError("connect", "", iot.ErrTLSLoad, err)
+	}
+	return tlsConfig, nil
 }
 
-func (mqttClient *MqttDeviceClient) connectMqttBroker() bool {
-	options := mqtt.NewClientOptions()
+func (mqttClient *MqttDeviceClient) connectMqttBroker(ctx context.Context) error {
+	factory := mqttClient.ConnectAuthConfig.TransportFactory
+	if factory == nil {
+		protocolFactory, err := transport.NewFactory(mqttClient.ConnectAuthConfig.ProtocolVersion)
+		if err != nil {
+			return iot.NewDeviceError("connect", "", iot.ErrInvalidParams, err)
+		}
+		factory = protocolFactory
+	}
 	newPwd, err := iot.HmacSha256(mqttClient.ConnectAuthConfig.Secret, iot.TimeStamp())
 	if err != nil {
-		return false
+		return iot.NewDeviceError("connect", "", iot.ErrInvalidParams, err)
+	}
+	// 回调只会在建链之后触发，此时conn已经赋值
+	var conn transport.Transport
+	options := transport.Options{
+		Broker:           mqttClient.ConnectAuthConfig.Servers,
+		ClientId:         assembleClientId(*mqttClient.ConnectAuthConfig),
+		Username:         mqttClient.ConnectAuthConfig.Id,
+		Password:         newPwd,
+		ConnectTimeout:   20 * time.Second,
+		InflightMessages: mqttClient.ConnectAuthConfig.InflightMessages,
+		SessionExpiry:    mqttClient.ConnectAuthConfig.SessionExpiry,
+		OnConnect:        mqttClient.createConnectHandler(&conn),
+		OnConnectionLost: mqttClient.createConnectionLostHandler(&conn),
+		DefaultHandler:   mqttClient.createDefaultMessageHandler(),
 	}
-	options.SetPassword(newPwd)
 	if mqttClient.ConnectAuthConfig.UseBootstrap {
 		info := mqttClient.getServerInfo()
 		if info == nil {
-			return false
+			return iot.NewDeviceError("connect", "", iot.ErrNotConnected, errors.New("get server info from bootstrap server failed"))
 		}
 		if len(info.Secret) != 0 {
 			newPwd, err = iot.HmacSha256(info.Secret, iot.TimeStamp())
 			if err != nil {
-				return false
+				return iot.NewDeviceError("connect", "", iot.ErrInvalidParams, err)
 			}
-			options.SetPassword(newPwd)
+			options.Password = newPwd
 		}
-		options.AddBroker(info.ServerUri)
-	} else {
-		options.AddBroker(mqttClient.ConnectAuthConfig.Servers)
+		options.Broker = info.ServerUri
 	}
 
-	options.SetClientID(assembleClientId(*mqttClient.ConnectAuthConfig))
-	options.SetUsername(mqttClient.ConnectAuthConfig.Id)
-	options.SetConnectTimeout(20 * time.Second)
-	// 关闭sdk内部重连，使用自定义重连刷新时间戳
-	options.SetAutoReconnect(false)
-	options.SetConnectRetry(false)
-	options.SetMaxResumePubInFlight(mqttClient.ConnectAuthConfig.InflightMessages)
-
 	if mqttClient.ConnectAuthConfig.ConnectTimeout <= 12000 && mqttClient.ConnectAuthConfig.ConnectTimeout >= 30 {
-		options.SetKeepAlive(time.Duration(mqttClient.ConnectAuthConfig.ConnectTimeout) * time.Second)
+		options.KeepAlive = time.Duration(mqttClient.ConnectAuthConfig.ConnectTimeout) * time.Second
 	} else {
-		options.SetKeepAlive(120 * time.Second)
+		options.KeepAlive = 120 * time.Second
 	}
-	options.OnConnectionLost = mqttClient.createConnectionLostHandler()
-	options.OnConnect = mqttClient.createConnectHandler()
-	options.DefaultPublishHandler = mqttClient.createDefaultMessageHandler()
 
-	if err := mqttClient.configureTLS(options); err != nil {
-		return false
+	tlsConfig, err := mqttClient.configureTLS()
+	if err != nil {
+		return err
 	}
-	mqttClient.client = mqtt.NewClient(options)
-	if token := mqttClient.client.Connect(); token.WaitTimeout(mqttClient.ConnectAuthConfig.ConnectTimeOut) && token.Error() != nil {
-		glog.Warningf("device %s init failed,error = %v", mqttClient.ConnectAuthConfig.Id, token.Error())
-		return false
+	options.TLSConfig = tlsConfig
+	dialer, err := mqttClient.ConnectAuthConfig.TLS.Dialer()
+	if err != nil {
+		return iot.NewDeviceError("connect", "", iot.ErrInvalidParams, err)
+	}
+	options.DialContext = dialer
+	conn = factory(options)
+	mqttClient.transport = conn
+	connectCtx := ctx
+	if mqttClient.ConnectAuthConfig.ConnectTimeOut > 0 {
+		var cancel context.CancelFunc
+		connectCtx, cancel = context.WithTimeout(ctx, mqttClient.ConnectAuthConfig.ConnectTimeOut)
+		defer cancel()
 	}
-	mqttClient.RuleManageService = rule.RuleManageService{
-		RuleIdList:       make(map[string]bool),
-		RuleInfoMap:      make(map[string]model.RuleInfo),
-		TimerRuleMap:     make(map[string]rule.TimerRuleInstance),
-		ConditionExecute: rule.ConditionExecute{},
+	if err := mqttClient.transport.Connect(connectCtx); err != nil {
+		mqttClient.GetLogger().Warn("connect mqtt broker failed", logger.Err(err))
+		var reasonCodeErr *transport.ReasonCodeError
+		if errors.As(err, &reasonCodeErr) {
+			return iot.NewDeviceError("connect", "", iot.ErrBrokerRejected, err)
+		}
+		return iot.NewDeviceError("connect", "", iot.ErrNotConnected, err)
 	}
-	go logFlush()
+	return nil
+}
 
-	return true
+// startRules 开启端侧规则时启动规则管理服务，重连时规则保持不变
+func (mqttClient *MqttDeviceClient) startRules() {
+	if !mqttClient.ConnectAuthConfig.RuleEnable || mqttClient.RuleManageService == nil {
+		return
+	}
+	mqttClient.RuleManageService.SetExecutionHandler(mqttClient.reportRuleExecution)
+	if err := mqttClient.RuleManageService.Start(mqttClient.CreateRuleActionHandler()); err != nil {
+		mqttClient.GetLogger().Warn("load rules from store failed", logger.Err(err))
+	}
 }
 
 func (mqttClient *MqttDeviceClient) getServerInfo() *model.ServerInfo {
@@ -207,12 +306,12 @@ func (mqttClient *MqttDeviceClient) getServerInfo() *model.ServerInfo {
 	}
 	bootstracpClient, err := NewBootstrapClient(*mqttClient.ConnectAuthConfig)
 	if err != nil {
-		glog.Warningf("create bootstrap client failed,err %s\n", err)
+		mqttClient.GetLogger().Warn("create bootstrap client failed", logger.Err(err))
 		return nil
 	}
 	serverAddress, deviceSecret := bootstracpClient.Boot(mqttClient.ConnectAuthConfig.BootStrapBody)
 	if len(serverAddress) == 0 {
-		glog.Warningf("get server address from bootstrap server failed")
+		mqttClient.GetLogger().Warn("get server address from bootstrap server failed")
 		bootstracpClient.Close()
 		return nil
 	}
@@ -228,156 +327,502 @@ func (mqttClient *MqttDeviceClient) getServerInfo() *model.ServerInfo {
 }
 
 func (mqttClient *MqttDeviceClient) Close(timeout uint) {
-	mqttClient.client.Disconnect(timeout)
+	mqttClient.transition(StateClosed, nil, 0, 0)
+	if mqttClient.RuleManageService != nil {
+		mqttClient.RuleManageService.Stop()
+	}
+	if mqttClient.transport != nil {
+		mqttClient.transport.Disconnect(time.Duration(timeout) * time.Millisecond)
+	}
 	mqttClient.Pool.Release()
+	if mqttClient.SubDeviceOtaManager != nil {
+		mqttClient.SubDeviceOtaManager.Close()
+	}
+	if mqttClient.BufferStore != nil {
+		if err := mqttClient.BufferStore.Close(); err != nil {
+			mqttClient.GetLogger().Warn("close buffer store failed", logger.Err(err))
+		}
+	}
+	if mqttClient.RuleManageService != nil && mqttClient.RuleManageService.ExecutionStore != nil {
+		if err := mqttClient.RuleManageService.ExecutionStore.Close(); err != nil {
+			mqttClient.GetLogger().Warn("close rule execution store failed", logger.Err(err))
+		}
+	}
 }
 
 func (mqttClient *MqttDeviceClient) IsConnect() bool {
-	return mqttClient.client.IsConnected()
+	return mqttClient.transport != nil && mqttClient.transport.IsConnected()
 }
 
 func (mqttClient *MqttDeviceClient) PublishMessage(topic string, qos byte, message string) bool {
-	if !mqttClient.client.IsConnected() {
-		if mqttClient.Queue != nil {
+	err := mqttClient.PublishMessageWithContext(context.Background(), topic, qos, message)
+	if err != nil {
+		mqttClient.GetLogger().Warn("send message failed", logger.Topic(topic), logger.Err(err))
+		return false
+	}
+	return true
+}
+
+// PublishMessageWithContext 发布消息并等待发布完成，未建链时消息会进入缓存并返回ErrNotConnected
+func (mqttClient *MqttDeviceClient) PublishMessageWithContext(ctx context.Context, topic string, qos byte, message string) error {
+	if !mqttClient.IsConnect() {
+		if mqttClient.BufferStore != nil {
 			bufferMessage := model.BufferMessage{
 				Topic:   topic,
 				Qos:     qos,
 				Message: message,
 			}
-			mqttClient.Queue.Push(bufferMessage)
+			if err := mqttClient.BufferStore.Push(bufferMessage); err != nil {
+				mqttClient.GetLogger().Warn("buffer message failed", logger.Topic(topic), logger.Err(err))
+			}
+			mqttClient.getMetrics().BufferedMessages(mqttClient.ConnectAuthConfig.Id, mqttClient.BufferStore.Len())
 		}
-		return false
+		return iot.NewDeviceError("publish", topic, iot.ErrNotConnected, nil)
 	}
-	if token := mqttClient.client.Publish(topic, qos, false, message); token.Wait() && token.Error() != nil {
-		glog.Warningf("device %s send message failed", mqttClient.ConnectAuthConfig.Id)
-		return false
+	return mqttClient.publish(ctx, topic, qos, message)
+}
+
+func (mqttClient *MqttDeviceClient) publish(ctx context.Context, topic string, qos byte, message string) error {
+	return mqttClient.publishMessage(ctx, transport.Message{
+		Topic:   topic,
+		Qos:     qos,
+		Payload: []byte(message),
+	})
+}
+
+func (mqttClient *MqttDeviceClient) publishMessage(ctx context.Context, message transport.Message) error {
+	topic := message.Topic
+	if !mqttClient.IsConnect() {
+		return iot.NewDeviceError("publish", topic, iot.ErrNotConnected, nil)
 	}
-	glog.Infof("public message success. topic: %s,  message: %s", topic, message)
-	return true
+	if mqttClient.ConnectAuthConfig.PublishTimeOut > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mqttClient.ConnectAuthConfig.PublishTimeOut)
+		defer cancel()
+	}
+	start := time.Now()
+	err := mqttClient.transport.Publish(ctx, message)
+	mqttClient.getMetrics().PublishDone(mqttClient.ConnectAuthConfig.Id, metrics.TopicClass(topic), time.Since(start), err)
+	if err != nil {
+		var reasonCodeErr *transport.ReasonCodeError
+		if errors.As(err, &reasonCodeErr) {
+			return iot.NewDeviceError("publish", topic, iot.ErrBrokerRejected, err)
+		}
+		if ctx.Err() != nil {
+			return iot.NewDeviceError("publish", topic, iot.ErrPublishTimeout, err)
+		}
+		if !mqttClient.IsConnect() {
+			return iot.NewDeviceError("publish", topic, iot.ErrNotConnected, err)
+		}
+		return iot.NewDeviceError("publish", topic, iot.ErrBrokerRejected, err)
+	}
+	mqttClient.GetLogger().Debug("publish message success", logger.Topic(topic), logger.String("payload", string(message.Payload)))
+	return nil
+}
+
+// respond 响应平台请求。MQTT5下平台携带了响应topic时，按响应topic回复并带上关联数据
+func (mqttClient *MqttDeviceClient) respond(request transport.Message, topic string, qos byte, response string) error {
+	message := transport.Message{
+		Topic:   topic,
+		Qos:     qos,
+		Payload: []byte(response),
+	}
+	if request.Properties != nil && len(request.Properties.ResponseTopic) != 0 {
+		message.Topic = request.Properties.ResponseTopic
+		message.Properties = &transport.Properties{
+			CorrelationData: request.Properties.CorrelationData,
+		}
+	}
+	return mqttClient.publishMessage(context.Background(), message)
+}
+
+// PublishMessageWithProperties 发布携带MQTT5属性的消息，如消息过期时间、用户属性等，MQTT3.1.1下属性会被忽略。
+// 未建链时消息不会进入缓存
+func (mqttClient *MqttDeviceClient) PublishMessageWithProperties(ctx context.Context, topic string, qos byte, message string, properties *transport.Properties) error {
+	return mqttClient.publishMessage(ctx, transport.Message{
+		Topic:      topic,
+		Qos:        qos,
+		Payload:    []byte(message),
+		Properties: properties,
+	})
 }
 
+// PublishObjectWithContext 将对象序列化为json后发布
+func (mqttClient *MqttDeviceClient) PublishObjectWithContext(ctx context.Context, topic string, qos byte, v interface{}) error {
+	message, err := iot.MarshalJsonString(v)
+	if err != nil {
+		return err
+	}
+	return mqttClient.PublishMessageWithContext(ctx, topic, qos, message)
+}
+
+// PublishBufferMessage 按缓存顺序发送断链期间缓存的消息，消息发布成功后才从缓存中删除
 func (mqttClient *MqttDeviceClient) PublishBufferMessage() {
-	if mqttClient.Queue == nil {
+	if mqttClient.BufferStore == nil {
 		return
 	}
-	for mqttClient.Queue.Len() > 0 {
-		pop := mqttClient.Queue.Pop()
-		message, ok := pop.(model.BufferMessage)
-		if ok {
-			mqttClient.PublishMessage(message.Topic, message.Qos, message.Message)
-			continue
+	// 同一时间只允许一个协程发送缓存消息，避免消息重复和乱序
+	if !atomic.CompareAndSwapInt32(&mqttClient.draining, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&mqttClient.draining, 0)
+	for {
+		entry, ok, err := mqttClient.BufferStore.Peek()
+		if err != nil {
+			mqttClient.GetLogger().Warn("read buffer message failed", logger.Err(err))
+			return
+		}
+		if !ok {
+			return
 		}
-		glog.Warningf("message type was not match.")
+		message := entry.Message
+		if err := mqttClient.publish(context.Background(), message.Topic, message.Qos, message.Message); err != nil {
+			mqttClient.GetLogger().Warn("publish buffer message failed, retry after reconnect", logger.Topic(message.Topic), logger.Err(err))
+			return
+		}
+		if err := mqttClient.BufferStore.Ack(entry.Seq); err != nil {
+			mqttClient.GetLogger().Warn("remove buffer message failed", logger.Err(err))
+			return
+		}
+		mqttClient.getMetrics().BufferedMessages(mqttClient.ConnectAuthConfig.Id, mqttClient.BufferStore.Len())
 	}
 }
 
 func (mqttClient *MqttDeviceClient) SubscribeCustomizeTopic(topic string, handler callback.MessageHandler) {
-	if token := mqttClient.client.Subscribe(topic, mqttClient.ConnectAuthConfig.Qos, func(client mqtt.Client, message mqtt.Message) {
-		payload := message.Payload()
-		handler(string(payload))
-	}); token.Wait() && token.Error() != nil {
-		glog.Warningf("device subscribe customize message topic failed. deviceId: %s, topic: %s", mqttClient.ConnectAuthConfig.Id, topic)
+	ctx, cancel := context.WithTimeout(context.Background(), mqttClient.ConnectAuthConfig.PublishTimeOut)
+	defer cancel()
+	err := mqttClient.SubscribeCustomizeTopicWithContext(ctx, topic, mqttClient.ConnectAuthConfig.Qos, handler)
+	if err != nil {
+		mqttClient.GetLogger().Warn("subscribe customize topic failed", logger.Topic(topic), logger.Err(err))
 		return
 	}
-	glog.Infof("subscribe customize topic success. topic: %s", topic)
+	mqttClient.GetLogger().Info("subscribe customize topic success", logger.Topic(topic))
+}
+
+// SubscribeCustomizeTopicWithContext 订阅自定义topic，topic支持+和#通配符。
+// 订阅会被记录下来，建链前调用或者断链重连后都会自动重新订阅
+func (mqttClient *MqttDeviceClient) SubscribeCustomizeTopicWithContext(ctx context.Context, topic string, qos byte, handler callback.MessageHandler) error {
+	if !transport.ValidTopicFilter(topic) || handler == nil {
+		return iot.NewDeviceError("subscribe", topic, iot.ErrInvalidParams, errors.New("invalid topic or handler"))
+	}
+	mqttClient.Subscriptions.Add(Subscription{
+		Topic:   topic,
+		Qos:     qos,
+		Handler: handler,
+	})
+	if !mqttClient.IsConnect() {
+		mqttClient.GetLogger().Info("device is not connected, topic will be subscribed after connect", logger.Topic(topic))
+		return nil
+	}
+	if err := mqttClient.subscribe(ctx, mqttClient.transport, topic, qos); err != nil {
+		return iot.NewDeviceError("subscribe", topic, iot.ErrBrokerRejected, err)
+	}
+	return nil
+}
+
+// UnsubscribeCustomizeTopic 取消订阅自定义topic，取消后重连时不再重新订阅
+func (mqttClient *MqttDeviceClient) UnsubscribeCustomizeTopic(topic string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), mqttClient.ConnectAuthConfig.PublishTimeOut)
+	defer cancel()
+	err := mqttClient.UnsubscribeCustomizeTopicWithContext(ctx, topic)
+	if err != nil {
+		mqttClient.GetLogger().Warn("unsubscribe customize topic failed", logger.Topic(topic), logger.Err(err))
+		return false
+	}
+	mqttClient.GetLogger().Info("unsubscribe customize topic success", logger.Topic(topic))
+	return true
+}
+
+// UnsubscribeCustomizeTopicWithContext 取消订阅自定义topic
+func (mqttClient *MqttDeviceClient) UnsubscribeCustomizeTopicWithContext(ctx context.Context, topic string) error {
+	if !mqttClient.Subscriptions.Remove(topic) {
+		return iot.NewDeviceError("unsubscribe", topic, iot.ErrInvalidParams, errors.New("topic is not subscribed"))
+	}
+	if !mqttClient.IsConnect() {
+		return nil
+	}
+	if mqttClient.ConnectAuthConfig.PublishTimeOut > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mqttClient.ConnectAuthConfig.PublishTimeOut)
+		defer cancel()
+	}
+	if err := mqttClient.transport.Unsubscribe(ctx, topic); err != nil {
+		return iot.NewDeviceError("unsubscribe", topic, iot.ErrBrokerRejected, err)
+	}
+	return nil
+}
+
+func (mqttClient *MqttDeviceClient) subscribe(ctx context.Context, conn transport.Transport, topic string, qos byte) error {
+	if mqttClient.ConnectAuthConfig.PublishTimeOut > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mqttClient.ConnectAuthConfig.PublishTimeOut)
+		defer cancel()
+	}
+	return conn.Subscribe(ctx, topic, qos, mqttClient.createCustomizeMessageHandler(topic))
+}
+
+// resubscribe 建链成功后按订阅记录重新订阅自定义topic
+func (mqttClient *MqttDeviceClient) resubscribe(conn transport.Transport) {
+	subscriptions := mqttClient.Subscriptions.List()
+	for _, subscription := range subscriptions {
+		if err := mqttClient.subscribe(context.Background(), conn, subscription.Topic, subscription.Qos); err != nil {
+			mqttClient.GetLogger().Warn("resubscribe customize topic failed", logger.Topic(subscription.Topic), logger.Err(err))
+			continue
+		}
+		mqttClient.GetLogger().Info("resubscribe customize topic success", logger.Topic(subscription.Topic))
+	}
+}
+
+// createCustomizeMessageHandler 按订阅时的topic过滤器查找处理函数，处理函数被替换后立即生效
+func (mqttClient *MqttDeviceClient) createCustomizeMessageHandler(filter string) transport.MessageHandler {
+	return func(message transport.Message) {
+		subscription, ok := mqttClient.Subscriptions.Get(filter)
+		if !ok {
+			return
+		}
+		mqttClient.getMetrics().MessageReceived(mqttClient.ConnectAuthConfig.Id, metrics.TopicClassCustom)
+		start := time.Now()
+		subscription.Handler(string(message.Payload))
+		mqttClient.getMetrics().HandlerDone(mqttClient.ConnectAuthConfig.Id, metrics.TopicClassCustom, time.Since(start))
+	}
+}
+
+// dispatchCustomizeMessage 将消息分发给全部匹配的自定义订阅
+func (mqttClient *MqttDeviceClient) dispatchCustomizeMessage(message transport.Message) {
+	for _, subscription := range mqttClient.Subscriptions.Match(message.Topic) {
+		subscription.Handler(string(message.Payload))
+	}
+}
+
+// pahoClient 返回底层的paho客户端，兼容以mqtt.Client为参数的回调，MQTT5下为nil
+func (mqttClient *MqttDeviceClient) pahoClient(conn transport.Transport) mqtt.Client {
+	if client, ok := conn.(interface{ PahoClient() mqtt.Client }); ok {
+		return client.PahoClient()
+	}
+	return nil
 }
 
+// CreateRuleActionHandler 设置了RuleActionHandler时由其处理全部动作，否则按动作类型执行，
+// 每个动作的结果记录日志并交给RuleActionResultHandler，全部成功时返回true
 func (mqttClient *MqttDeviceClient) CreateRuleActionHandler() func(actionList []model.Action) bool {
 	return func(actionList []model.Action) bool {
+		mqttClient.getMetrics().RuleFired(mqttClient.ConnectAuthConfig.Id, len(actionList))
 		if mqttClient.RuleActionHandler != nil {
 			return mqttClient.RuleActionHandler(actionList)
 		}
-		for _, action := range actionList {
-			if !strings.EqualFold(action.DeviceId, mqttClient.ConnectAuthConfig.Id) {
-				glog.Warningf("action device is not match. target: %s, action: %s", mqttClient.ConnectAuthConfig.Id, action.DeviceId)
-				continue
-			}
-			if mqttClient.CommandHandler == nil {
-				glog.Warningf("command handler is not define.")
+		executors := mqttClient.RuleActionExecutors
+		if executors == nil {
+			executors = rule.NewActionExecutors()
+		}
+		results := executors.Execute(mqttClient.StateMachine.done(), actionList, mqttClient.ruleActionExecutors())
+		success := true
+		for _, result := range results {
+			if result.Success {
+				mqttClient.GetLogger().Debug("execute rule action success", logger.String("rule_id", result.RuleId), logger.String("type", result.Type))
 				continue
 			}
-			command := action.Command
-			deviceCommand := model.Command{
-				CommandName: command.CommandName,
-				ServiceId:   command.ServiceId,
-				Paras:       command.CommandBody,
-			}
-			success, _ := mqttClient.CommandHandler(deviceCommand)
-			if !success {
-				glog.Warningf("handle command failed.")
-			}
+			success = false
+			mqttClient.GetLogger().Warn("execute rule action failed", logger.String("rule_id", result.RuleId), logger.String("type", result.Type),
+				logger.String("action_device_id", result.DeviceId), logger.String("error", result.Error))
+		}
+		if mqttClient.RuleManageService != nil {
+			mqttClient.RuleManageService.RecordActionResults(results)
+		}
+		if mqttClient.RuleActionResultHandler != nil {
+			mqttClient.RuleActionResultHandler(results)
+		}
+		return success
+	}
+}
+
+// reportRuleExecution 按ConnectAuthConfig.RuleExecReport将规则的执行记录上报平台
+func (mqttClient *MqttDeviceClient) reportRuleExecution(execution model.RuleExecution) {
+	var entry model.DataEntry
+	switch mqttClient.ConnectAuthConfig.RuleExecReport {
+	case constants.RuleExecutionReportEvent:
+		entry = model.DataEntry{
+			ServiceId: "$device_rule",
+			EventType: "device_rule_execution_report",
+			EventTime: iot.GetEventTimeStamp(),
+			Paras:     execution,
+		}
+	case constants.RuleExecutionReportLog:
+		entry = model.DataEntry{
+			ServiceId: "$log",
+			EventType: "log_report",
+			EventTime: iot.GetEventTimeStamp(),
+			Paras: model.DeviceLogEntry{
+				Timestamp: strconv.FormatInt(execution.Time.UnixNano()/int64(time.Millisecond), 10),
+				Type:      "DEVICE_RULE",
+				Content:   iot.Interface2JsonString(execution),
+			},
 		}
-		return true
+	default:
+		return
+	}
+	// 离线时等待发布超时，不阻塞规则的执行
+	err := mqttClient.Pool.Submit(func() {
+		topic := iot.FormatTopic(constants.DeviceToPlatformTopic, mqttClient.ConnectAuthConfig.Id)
+		data := model.Data{Services: []model.DataEntry{entry}}
+		if err := mqttClient.PublishObjectWithContext(mqttClient.StateMachine.done(), topic, mqttClient.ConnectAuthConfig.Qos, data); err != nil {
+			mqttClient.GetLogger().Warn("report rule execution failed", logger.String("rule_id", execution.RuleId), logger.Err(err))
+		}
+	})
+	if err != nil {
+		mqttClient.GetLogger().Warn("submit rule execution report failed", logger.Err(err))
 	}
 }
 
-func (mqttClient *MqttDeviceClient) createDefaultMessageHandler() func(client mqtt.Client, message mqtt.Message) {
-	return func(client mqtt.Client, message mqtt.Message) {
+// ruleActionExecutors 内置的规则动作执行器，FUNCTION和HTTP由rule.ActionExecutors执行
+func (mqttClient *MqttDeviceClient) ruleActionExecutors() map[string]rule.ActionExecutor {
+	return map[string]rule.ActionExecutor{
+		rule.ActionTypeDeviceCommand: mqttClient.executeRuleCommand,
+		rule.ActionTypeProperty:      mqttClient.executeRuleProperties,
+		rule.ActionTypeMessage:       mqttClient.executeRuleMessage,
+	}
+}
+
+// executeRuleCommand 本设备的命令交给CommandHandler，其他设备的命令交给网关的SubDeviceCommandHandler。
+// ctx结束后不再等待回调，回调在后台执行完成
+func (mqttClient *MqttDeviceClient) executeRuleCommand(ctx context.Context, action model.Action) error {
+	command := model.Command{
+		CommandName: action.Command.CommandName,
+		ServiceId:   action.Command.ServiceId,
+		Paras:       action.Command.CommandBody,
+	}
+	handler := mqttClient.CommandHandler
+	if !strings.EqualFold(action.DeviceId, mqttClient.ConnectAuthConfig.Id) {
+		if mqttClient.SubDeviceCommandHandler == nil {
+			return fmt.Errorf("%w: action device %s is not match", rule.ErrActionNotSupported, action.DeviceId)
+		}
+		command.ObjectDeviceId = action.DeviceId
+		handler = mqttClient.SubDeviceCommandHandler
+	}
+	if handler == nil {
+		return fmt.Errorf("%w: command handler is not define", rule.ErrActionNotSupported)
+	}
+	result := make(chan bool, 1)
+	go func() {
+		success, _ := handler(command)
+		result <- success
+	}()
+	select {
+	case success := <-result:
+		if !success {
+			return fmt.Errorf("handle rule command %s failed", command.CommandName)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("handle rule command %s failed: %w", command.CommandName, ctx.Err())
+	}
+}
+
+// executeRuleProperties 直接上报属性，不再触发端侧规则，避免规则之间循环触发
+func (mqttClient *MqttDeviceClient) executeRuleProperties(ctx context.Context, action model.Action) error {
+	if action.Properties == nil {
+		return fmt.Errorf("%w: rule properties is empty", iot.ErrInvalidParams)
+	}
+	properties := model.DeviceProperties{
+		Services: []model.DevicePropertyEntry{{
+			ServiceId:  action.Properties.ServiceId,
+			Properties: action.Properties.Properties,
+			EventTime:  iot.GetEventTimeStamp(),
+		}},
+	}
+	return mqttClient.PublishObjectWithContext(ctx, iot.FormatTopic(constants.PropertiesUpTopic, mqttClient.ConnectAuthConfig.Id), mqttClient.ConnectAuthConfig.Qos, properties)
+}
+
+func (mqttClient *MqttDeviceClient) executeRuleMessage(ctx context.Context, action model.Action) error {
+	if action.Message == nil {
+		return fmt.Errorf("%w: rule message is empty", iot.ErrInvalidParams)
+	}
+	topic := action.Message.Topic
+	if topic == "" {
+		topic = constants.MessageUpTopic
+	}
+	topic = iot.FormatTopic(topic, mqttClient.ConnectAuthConfig.Id)
+	if payload, ok := action.Message.Payload.(string); ok {
+		return mqttClient.PublishMessageWithContext(ctx, topic, mqttClient.ConnectAuthConfig.Qos, payload)
+	}
+	return mqttClient.PublishObjectWithContext(ctx, topic, mqttClient.ConnectAuthConfig.Qos, action.Message.Payload)
+}
+
+func (mqttClient *MqttDeviceClient) createDefaultMessageHandler() transport.MessageHandler {
+	return func(message transport.Message) {
+		topicClass := metrics.TopicClass(message.Topic)
+		mqttClient.getMetrics().MessageReceived(mqttClient.ConnectAuthConfig.Id, topicClass)
 		err := mqttClient.Pool.Submit(func() {
-			topic := message.Topic()
-			glog.Infof("receive message from device. topic: %s, message: %s", topic, string(message.Payload()))
+			start := time.Now()
+			defer func() {
+				mqttClient.getMetrics().HandlerDone(mqttClient.ConnectAuthConfig.Id, topicClass, time.Since(start))
+			}()
+			topic := message.Topic
+			mqttClient.GetLogger().Debug("receive message from platform", logger.Topic(topic), logger.String("payload", string(message.Payload)))
 			if strings.Contains(topic, "/messages/down") {
-				mqttClient.handleDeviceMessageDown(client, message)
+				mqttClient.handleDeviceMessageDown(message)
 				return
 			}
 			if strings.Contains(topic, "sys/commands/request_id") {
-				mqttClient.handleDeviceCommand(client, message)
+				mqttClient.handleDeviceCommand(message)
 				return
 			}
 			if strings.Contains(topic, "/sys/properties/set/request_id") {
-				mqttClient.handleDevicePropertiesSet(client, message)
+				mqttClient.handleDevicePropertiesSet(message)
 				return
 			}
 			if strings.Contains(topic, "/sys/properties/get/request_id") {
-				mqttClient.handleDevicePropertiesQuery(client, message)
+				mqttClient.handleDevicePropertiesQuery(message)
 				return
 			}
 			if strings.Contains(topic, "/sys/shadow/get/response") {
-				mqttClient.handleDevicePropertiesQueryResponse(client, message)
+				mqttClient.handleDevicePropertiesQueryResponse(message)
 				return
 			}
 			if strings.Contains(topic, "/sys/events/down") {
-				mqttClient.handleDeviceEvent(client, message)
+				mqttClient.handleDeviceEvent(message)
 				return
 			}
+			// 会话恢复时消息可能先于订阅回调注册到达
+			mqttClient.dispatchCustomizeMessage(message)
 		})
 		if err != nil {
-			glog.Warningf("submit message failed. topic: %s, err: %s", message.Topic(), err.Error())
+			mqttClient.GetLogger().Warn("submit message failed", logger.Topic(message.Topic), logger.Err(err))
 		}
 	}
 }
 
-func (mqttClient *MqttDeviceClient) handleDeviceCommand(client mqtt.Client, message mqtt.Message) {
+func (mqttClient *MqttDeviceClient) handleDeviceCommand(message transport.Message) {
 	command := &model.Command{}
-	if json.Unmarshal(message.Payload(), command) != nil {
-		glog.Warningf("unmarshal platform command failed,device id = %s，message = %s", mqttClient.ConnectAuthConfig.Id, message)
+	if json.Unmarshal(message.Payload, command) != nil {
+		mqttClient.GetLogger().Warn("unmarshal platform command failed", logger.Topic(message.Topic), logger.String("payload", string(message.Payload)))
 	}
 
+	requestId := iot.GetTopicRequestId(message.Topic)
 	flag, response := mqttClient.CommandHandler(*command)
 	var res string
 	if flag {
-		glog.Infof("device %s handle command success", mqttClient.ConnectAuthConfig.Id)
+		mqttClient.GetLogger().Info("handle command success", logger.RequestId(requestId))
 		res = iot.Interface2JsonString(model.CommandResponse{
 			ResultCode: 0,
 			Paras:      response,
 		})
 	} else {
-		glog.Warningf("device %s handle command failed", mqttClient.ConnectAuthConfig.Id)
+		mqttClient.GetLogger().Warn("handle command failed", logger.RequestId(requestId))
 		res = iot.Interface2JsonString(model.CommandResponse{
 			ResultCode: 1,
 			Paras:      response,
 		})
 	}
-	if token := mqttClient.client.Publish(iot.FormatTopic(constants.CommandResponseTopic, mqttClient.ConnectAuthConfig.Id)+iot.GetTopicRequestId(message.Topic()),
-		1, false, res); token.Wait() && token.Error() != nil {
-		glog.Infof("device %s send command response failed", mqttClient.ConnectAuthConfig.Id)
+	topic := iot.FormatTopic(constants.CommandResponseTopic, mqttClient.ConnectAuthConfig.Id) + requestId
+	if err := mqttClient.respond(message, topic, 1, res); err != nil {
+		mqttClient.GetLogger().Warn("send command response failed", logger.RequestId(requestId), logger.Err(err))
 	}
 }
 
-func (mqttClient *MqttDeviceClient) handleDevicePropertiesSet(client mqtt.Client, message mqtt.Message) {
+func (mqttClient *MqttDeviceClient) handleDevicePropertiesSet(message transport.Message) {
 	propertiesSetRequest := &model.DevicePropertyDownRequest{}
-	if json.Unmarshal(message.Payload(), propertiesSetRequest) != nil {
-		glog.Warningf("unmarshal platform properties set request failed,device id = %s，message = %s", mqttClient.ConnectAuthConfig.Id, message)
+	if json.Unmarshal(message.Payload, propertiesSetRequest) != nil {
+		mqttClient.GetLogger().Warn("unmarshal platform properties set request failed", logger.Topic(message.Topic), logger.String("payload", string(message.Payload)))
 	}
 
 	handleFlag := true
@@ -408,70 +853,117 @@ func (mqttClient *MqttDeviceClient) handleDevicePropertiesSet(client mqtt.Client
 		response.ResultDesc = "Set properties failed."
 		res = iot.Interface2JsonString(response)
 	}
-	if token := mqttClient.client.Publish(iot.FormatTopic(constants.PropertiesSetResponseTopic, mqttClient.ConnectAuthConfig.Id)+iot.GetTopicRequestId(message.Topic()),
-		mqttClient.ConnectAuthConfig.Qos, false, res); token.Wait() && token.Error() != nil {
-		glog.Warningf("unmarshal platform properties set request failed,device id = %s，message = %s", mqttClient.ConnectAuthConfig.Id, message)
+	topic := iot.FormatTopic(constants.PropertiesSetResponseTopic, mqttClient.ConnectAuthConfig.Id) + iot.GetTopicRequestId(message.Topic)
+	if err := mqttClient.respond(message, topic, mqttClient.ConnectAuthConfig.Qos, res); err != nil {
+		mqttClient.GetLogger().Warn("send properties set response failed", logger.RequestId(iot.GetTopicRequestId(message.Topic)), logger.Err(err))
 	}
 
 }
 
-func (mqttClient *MqttDeviceClient) handleDeviceMessageDown(client mqtt.Client, message mqtt.Message) {
+func (mqttClient *MqttDeviceClient) handleDeviceMessageDown(message transport.Message) {
 	for _, handler := range mqttClient.MessageHandlers {
-		handler(string(message.Payload()))
+		handler(string(message.Payload))
 	}
 }
 
-func (mqttClient *MqttDeviceClient) handleDevicePropertiesQuery(client mqtt.Client, message mqtt.Message) {
+func (mqttClient *MqttDeviceClient) handleDevicePropertiesQuery(message transport.Message) {
 	propertiesQueryRequest := &model.DevicePropertyQueryRequest{}
-	if json.Unmarshal(message.Payload(), propertiesQueryRequest) != nil {
-		glog.Warningf("device %s unmarshal properties query request failed %s", mqttClient.ConnectAuthConfig.Id, message)
+	if json.Unmarshal(message.Payload, propertiesQueryRequest) != nil {
+		mqttClient.GetLogger().Warn("unmarshal properties query request failed", logger.Topic(message.Topic), logger.String("payload", string(message.Payload)))
 	}
 
 	queryResult := mqttClient.PropertyQueryHandler(*propertiesQueryRequest)
 	responseToPlatform := iot.Interface2JsonString(queryResult)
-	if token := mqttClient.client.Publish(iot.FormatTopic(constants.PropertiesQueryResponseTopic, mqttClient.ConnectAuthConfig.Id)+iot.GetTopicRequestId(message.Topic()),
-		mqttClient.ConnectAuthConfig.Qos, false, responseToPlatform); token.Wait() && token.Error() != nil {
-		glog.Warningf("device %s send properties query response failed.", mqttClient.ConnectAuthConfig.Id)
+	topic := iot.FormatTopic(constants.PropertiesQueryResponseTopic, mqttClient.ConnectAuthConfig.Id) + iot.GetTopicRequestId(message.Topic)
+	if err := mqttClient.respond(message, topic, mqttClient.ConnectAuthConfig.Qos, responseToPlatform); err != nil {
+		mqttClient.GetLogger().Warn("send properties query response failed", logger.RequestId(iot.GetTopicRequestId(message.Topic)), logger.Err(err))
 	}
 }
-func (mqttClient *MqttDeviceClient) handleDevicePropertiesQueryResponse(client mqtt.Client, message mqtt.Message) {
+func (mqttClient *MqttDeviceClient) handleDevicePropertiesQueryResponse(message transport.Message) {
 	propertiesQueryResponse := &model.DeviceShadowQueryResponse{}
-	if json.Unmarshal(message.Payload(), propertiesQueryResponse) != nil {
-		glog.Warningf("device %s unmarshal property response failed,message %s", mqttClient.ConnectAuthConfig.Id, iot.Interface2JsonString(message))
+	if json.Unmarshal(message.Payload, propertiesQueryResponse) != nil {
+		mqttClient.GetLogger().Warn("unmarshal shadow query response failed", logger.Topic(message.Topic), logger.String("payload", string(message.Payload)))
+	}
+	mqttClient.PendingRequests.Complete(ShadowQueryKey(iot.GetTopicRequestId(message.Topic)), *propertiesQueryResponse)
+	if mqttClient.DeviceShadowQueryResponseHandler != nil {
+		mqttClient.DeviceShadowQueryResponseHandler(*propertiesQueryResponse)
 	}
-	mqttClient.DeviceShadowQueryResponseHandler(*propertiesQueryResponse)
 }
 
-func (mqttClient *MqttDeviceClient) createConnectionLostHandler() func(client mqtt.Client, reason error) {
+func (mqttClient *MqttDeviceClient) createConnectionLostHandler(conn *transport.Transport) func(reason error) {
 	// 断链后进行自定义重连
-	connectionLostHandler := func(client mqtt.Client, reason error) {
+	connectionLostHandler := func(reason error) {
+		// 已经被新的连接替换
+		if *conn != mqttClient.transport {
+			return
+		}
+		mqttClient.GetLogger().Warn("connection lost from server", logger.Err(reason))
+		mqttClient.transition(StateDisconnected, reason, 0, 0)
 		if mqttClient.ConnectionLostHandler != nil {
-			glog.Warningf("connection lost from server. reason: %s\n", reason.Error())
-			mqttClient.ConnectionLostHandler(client, reason)
+			mqttClient.ConnectionLostHandler(mqttClient.pahoClient(*conn), reason)
 		}
 		if *mqttClient.ConnectAuthConfig.AutoReconnect {
-			glog.Warningf("connection lost from server. begin to reconnect broker. reason: %s\n", reason.Error())
-			connected := mqttClient.Connect()
-			if connected {
-				glog.Infof("reconnect mqtt go broker success.")
-			}
+			mqttClient.startReconnect()
 		}
 	}
 	return connectionLostHandler
 }
 
-func (mqttClient *MqttDeviceClient) createConnectHandler() func(client mqtt.Client) {
+// startReconnect 在后台重连，不阻塞传输层的断链回调，同一时间只有一个重连在进行，设备关闭后停止重连
+func (mqttClient *MqttDeviceClient) startReconnect() {
+	stateMachine := mqttClient.StateMachine
+	if stateMachine == nil {
+		go mqttClient.Connect()
+		return
+	}
+	if stateMachine.State() == StateClosed || !stateMachine.beginReconnect() {
+		return
+	}
+	mqttClient.GetLogger().Info("begin to reconnect broker")
+	go func() {
+		defer stateMachine.endReconnect()
+		if err := mqttClient.ConnectWithContext(stateMachine.done()); err != nil {
+			mqttClient.GetLogger().Warn("reconnect mqtt broker failed", logger.Err(err))
+			return
+		}
+		mqttClient.GetLogger().Info("reconnect mqtt broker success")
+	}()
+}
+
+func (mqttClient *MqttDeviceClient) createConnectHandler(conn *transport.Transport) func() {
 	// 断链后进行自定义重连
-	onConnectHandler := func(client mqtt.Client) {
-		glog.Infof("connect from server.")
+	onConnectHandler := func() {
+		// 建链超时后被放弃或已经被新的连接替换
+		if *conn != mqttClient.transport {
+			(*conn).Disconnect(0)
+			return
+		}
+		mqttClient.GetLogger().Info("connect to server success")
+		mqttClient.resubscribe(*conn)
 		err := mqttClient.Pool.Submit(func() {
 			mqttClient.PublishBufferMessage()
 		})
 		if err != nil {
-			glog.Warningf("submit buffer ")
+			mqttClient.GetLogger().Warn("submit buffer message task failed", logger.Err(err))
+		}
+		if mqttClient.OtaManager != nil {
+			if err := mqttClient.Pool.Submit(mqttClient.recoverUpgrade); err != nil {
+				mqttClient.GetLogger().Warn("submit recover upgrade task failed", logger.Err(err))
+			}
+		}
+		// 离线期间平台上的规则可能发生变化，重新获取版本有变化的规则
+		if mqttClient.ConnectAuthConfig.RuleEnable {
+			err := mqttClient.Pool.Submit(func() {
+				mqttClient.RuleManageService.SyncRules(func(event model.DeviceEvents) bool {
+					return mqttClient.reportEvent(event)
+				})
+			})
+			if err != nil {
+				mqttClient.GetLogger().Warn("submit sync rules task failed", logger.Err(err))
+			}
 		}
 		if mqttClient.ConnectHandler != nil {
-			mqttClient.ConnectHandler(client)
+			mqttClient.ConnectHandler(mqttClient.pahoClient(*conn))
 			return
 		}
 	}
@@ -479,11 +971,11 @@ func (mqttClient *MqttDeviceClient) createConnectHandler() func(client mqtt.Clie
 }
 
 // 平台向设备下发的事件callback
-func (mqttClient *MqttDeviceClient) handleDeviceEvent(client mqtt.Client, message mqtt.Message) {
+func (mqttClient *MqttDeviceClient) handleDeviceEvent(message transport.Message) {
 	data := &model.Data{}
-	err := json.Unmarshal(message.Payload(), data)
+	err := json.Unmarshal(message.Payload, data)
 	if err != nil {
-		glog.Warningf("handle platform to device data failed. err: %s", err.Error())
+		mqttClient.GetLogger().Warn("handle platform to device data failed", logger.Topic(message.Topic), logger.Err(err))
 		return
 	}
 	for _, entry := range data.Services {
@@ -494,7 +986,7 @@ func (mqttClient *MqttDeviceClient) handleDeviceEvent(client mqtt.Client, messag
 		case "$file_manager":
 			mqttClient.handleFileService(entry)
 		case "$ota":
-			mqttClient.handleOtaService(entry)
+			mqttClient.handleOtaService(data.ObjectDeviceId, entry)
 		case "$log":
 			mqttClient.handleDeviceLogService(entry)
 		case "$time_sync":
@@ -513,7 +1005,7 @@ func (mqttClient *MqttDeviceClient) handleDeviceRuleService(entry model.DataEntr
 		paras := model.RuleParas{}
 		err := json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), &paras)
 		if err != nil {
-			glog.Warningf("convert event msg to rule info failed. err: %s", err)
+			mqttClient.GetLogger().Warn("convert event msg to rule info failed", logger.Err(err))
 			return
 		}
 		mqttClient.RuleManageService.QueryRuleResponse(paras.RulesInfos, mqttClient.CreateRuleActionHandler())
@@ -523,7 +1015,7 @@ func (mqttClient *MqttDeviceClient) handleDeviceRuleService(entry model.DataEntr
 func (mqttClient *MqttDeviceClient) handleDeviceLogService(entry model.DataEntry) {
 	if strings.EqualFold("log_config", entry.EventType) {
 		// 平台下发日志收集通知
-		glog.Infof("platform send log collect command")
+		mqttClient.GetLogger().Info("platform send log collect command")
 		logConfig := &config.LogCollectionConfig{}
 		if json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), logConfig) != nil {
 			return
@@ -544,41 +1036,76 @@ func (mqttClient *MqttDeviceClient) handleTimeSyncService(entry model.DataEntry)
 		if json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), timeSyncResponse) != nil {
 			return
 		}
-		mqttClient.SyncTimeResponseHandler(timeSyncResponse.DeviceSendTime, timeSyncResponse.ServerRecvTime, timeSyncResponse.ServerSendTime)
+		mqttClient.PendingRequests.Complete(TimeSyncKey(timeSyncResponse.DeviceSendTime), *timeSyncResponse)
+		if mqttClient.SyncTimeResponseHandler != nil {
+			mqttClient.SyncTimeResponseHandler(timeSyncResponse.DeviceSendTime, timeSyncResponse.ServerRecvTime, timeSyncResponse.ServerSendTime)
+		}
 	}
 }
 
-func (mqttClient *MqttDeviceClient) handleOtaService(entry model.DataEntry) {
+func (mqttClient *MqttDeviceClient) handleOtaService(objectDeviceId string, entry model.DataEntry) {
+	// 网关收到的子设备升级事件
+	if len(objectDeviceId) != 0 && objectDeviceId != mqttClient.ConnectAuthConfig.Id {
+		mqttClient.handleSubDeviceOtaService(objectDeviceId, entry)
+		return
+	}
 	eventType := entry.EventType
 	switch eventType {
 	case "version_query":
 		// 查询软固件版本
 		mqttClient.reportVersion()
-	case "firmware_upgrade", "firmware_upgrade_v2":
+	case "firmware_upgrade", "firmware_upgrade_v2", "software_upgrade", "software_upgrade_v2":
 		upgradeInfo := &model.UpgradeInfo{}
-		jsonString := iot.Interface2JsonString(entry.Paras)
-		err := json.Unmarshal([]byte(jsonString), upgradeInfo)
-		if err != nil {
-			glog.Warningf("unmarshal firware upgrade failed. err: %s", err.Error())
+		if err := json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), upgradeInfo); err != nil {
+			mqttClient.GetLogger().Warn("unmarshal upgrade event failed", logger.String("event_type", eventType), logger.Err(err))
 			return
 		}
-		if "firmware_upgrade" == eventType {
-			mqttClient.upgradeDevice(1, upgradeInfo)
-		} else {
-			mqttClient.upgradeDevice(3, upgradeInfo)
-		}
+		mqttClient.upgradeDevice(upgradeTypes[eventType], upgradeInfo)
+	}
+}
 
-	case "software_upgrade", "software_upgrade_v2":
-		upgradeInfo := &model.UpgradeInfo{}
-		if json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), upgradeInfo) != nil {
+// upgradeTypes 升级事件对应的升级类型
+var upgradeTypes = map[string]byte{
+	"software_upgrade":    ota.UpgradeTypeSoftware,
+	"firmware_upgrade":    ota.UpgradeTypeFirmware,
+	"software_upgrade_v2": ota.UpgradeTypeObsSoftware,
+	"firmware_upgrade_v2": ota.UpgradeTypeObsFirmware,
+}
+
+// handleSubDeviceOtaService 处理平台下发给子设备的版本查询和升级事件
+func (mqttClient *MqttDeviceClient) handleSubDeviceOtaService(deviceId string, entry model.DataEntry) {
+	log := mqttClient.GetLogger().With(logger.String("sub_device_id", deviceId))
+	if entry.EventType == "version_query" {
+		if mqttClient.SubDeviceVersionReporter == nil {
+			log.Warn("sub device version reporter is not set")
 			return
 		}
-		if "software_upgrade" == eventType {
-			mqttClient.upgradeDevice(0, upgradeInfo)
-		} else {
-			mqttClient.upgradeDevice(2, upgradeInfo)
-		}
+		sw, fw := mqttClient.SubDeviceVersionReporter(deviceId)
+		mqttClient.publishVersion(deviceId, sw, fw)
+		return
+	}
+	upgradeType, ok := upgradeTypes[entry.EventType]
+	if !ok {
+		return
+	}
+	upgradeInfo := model.UpgradeInfo{}
+	if err := json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), &upgradeInfo); err != nil {
+		log.Warn("unmarshal sub device upgrade failed", logger.Err(err))
+		return
+	}
+	if mqttClient.SubDeviceOtaManager == nil {
+		log.Warn("sub device ota manager is not set", logger.String("event_type", entry.EventType))
+		return
 	}
+	if err := mqttClient.SubDeviceOtaManager.UseTLS(mqttClient.ConnectAuthConfig.TLS); err != nil {
+		mqttClient.rejectUpgrade(deviceId, err)
+		return
+	}
+	// 升级耗时较长，不阻塞消息处理
+	go mqttClient.SubDeviceOtaManager.Upgrade(mqttClient.StateMachine.done(), deviceId, ota.Task{UpgradeType: upgradeType, Info: upgradeInfo},
+		func(progress model.UpgradeProgress) error {
+			return mqttClient.reportUpgradeProgressOf(deviceId, progress)
+		})
 }
 
 func (mqttClient *MqttDeviceClient) handleFileService(entry model.DataEntry) {
@@ -590,16 +1117,47 @@ func (mqttClient *MqttDeviceClient) handleFileService(entry model.DataEntry) {
 		if json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), fileResponse) != nil {
 			return
 		}
-		mqttClient.FileUrls[fileResponse.ObjectName+constants.FileActionUpload] = fileResponse.Url
+		mqttClient.PendingRequests.Complete(FileUrlKey(fileResponse.ObjectName, constants.FileActionUpload), *fileResponse)
 	case "get_download_url_response":
 		fileResponse := &model.FileResponseServiceEventParas{}
 		if json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), fileResponse) != nil {
 			return
 		}
-		mqttClient.FileUrls[fileResponse.ObjectName+constants.FileActionDownload] = fileResponse.Url
+		// 等待的下载已经超时或取消时丢弃迟到的响应
+		if !mqttClient.PendingRequests.Complete(FileUrlKey(fileResponse.ObjectName, constants.FileActionDownload), *fileResponse) {
+			mqttClient.GetLogger().Warn("no download is waiting for url, drop response", logger.String("file", fileResponse.ObjectName))
+		}
+	case constants.FileDownloadPushEvent:
+		// 平台主动下发的文件
+		fileResponse := &model.FileResponseServiceEventParas{}
+		if json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), fileResponse) != nil {
+			return
+		}
+		mqttClient.receiveFile(*fileResponse)
 	}
 }
 
+// receiveFile 下载平台主动下发的文件并上报下载结果
+func (mqttClient *MqttDeviceClient) receiveFile(paras model.FileResponseServiceEventParas) {
+	if mqttClient.FileReceiver == nil {
+		mqttClient.GetLogger().Warn("file receiver is not set, ignore file from platform", logger.String("file", paras.ObjectName))
+		return
+	}
+	// 下载耗时较长，不阻塞消息处理
+	go func() {
+		err := mqttClient.FileReceiver.UseTLS(mqttClient.ConnectAuthConfig.TLS)
+		if err != nil {
+			mqttClient.GetLogger().Error("load file download tls config failed", logger.String("file", paras.ObjectName), logger.Err(err))
+		} else {
+			_, err = mqttClient.FileReceiver.Receive(mqttClient.StateMachine.done(), paras)
+		}
+		response := file.CreateFileUploadDownLoadResultResponse(paras.ObjectName, constants.FileActionDownload, err == nil)
+		if err := mqttClient.PublishObjectWithContext(context.Background(), iot.FormatTopic(constants.DeviceToPlatformTopic, mqttClient.ConnectAuthConfig.Id), mqttClient.ConnectAuthConfig.Qos, response); err != nil {
+			mqttClient.GetLogger().Error("report file download result failed", logger.String("file", paras.ObjectName), logger.Err(err))
+		}
+	}()
+}
+
 func (mqttClient *MqttDeviceClient) handleSubDeviceService(entry model.DataEntry) {
 	eventType := entry.EventType
 	switch eventType {
@@ -636,6 +1194,7 @@ func (mqttClient *MqttDeviceClient) handleSubDeviceService(entry model.DataEntry
 		if json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), subDeviceResponse) != nil {
 			return
 		}
+		mqttClient.PendingRequests.Complete(EventKey(entry.ServiceId, eventType), *subDeviceResponse)
 		if mqttClient.SubDeviceAddResponseHandler == nil {
 			return
 		}
@@ -645,6 +1204,7 @@ func (mqttClient *MqttDeviceClient) handleSubDeviceService(entry model.DataEntry
 		if json.Unmarshal([]byte(iot.Interface2JsonString(entry.Paras)), subDeviceResponse) != nil {
 			return
 		}
+		mqttClient.PendingRequests.Complete(EventKey(entry.ServiceId, eventType), *subDeviceResponse)
 		if mqttClient.SubDeviceDeleteResponseHandler == nil {
 			return
 		}
@@ -655,16 +1215,21 @@ func (mqttClient *MqttDeviceClient) handleSubDeviceService(entry model.DataEntry
 func (mqttClient *MqttDeviceClient) reportEvent(event model.DeviceEvents) bool {
 	eventStr := iot.Interface2JsonString(event)
 	topic := iot.FormatTopic(constants.DeviceToPlatformTopic, mqttClient.ConnectAuthConfig.Id)
-	if token := mqttClient.client.Publish(topic, mqttClient.ConnectAuthConfig.Qos, false, eventStr); token.Wait() && token.Error() != nil {
-		glog.Errorf("device %s report version query failed,type %d", mqttClient.ConnectAuthConfig.Id)
+	if err := mqttClient.publish(context.Background(), topic, mqttClient.ConnectAuthConfig.Qos, eventStr); err != nil {
+		mqttClient.GetLogger().Error("report event failed", logger.Topic(topic), logger.Err(err))
 		return false
 	}
-	glog.Infof("report event success. topic: %s, msg: %s", topic, eventStr)
+	mqttClient.GetLogger().Info("report event success", logger.Topic(topic), logger.String("payload", eventStr))
 	return true
 }
 
 func (mqttClient *MqttDeviceClient) reportVersion() {
 	sw, fw := mqttClient.SwFwVersionReporter()
+	mqttClient.publishVersion(mqttClient.ConnectAuthConfig.Id, sw, fw)
+}
+
+// publishVersion 上报设备或子设备的软固件版本
+func (mqttClient *MqttDeviceClient) publishVersion(deviceId, sw, fw string) {
 	dataEntry := model.DataEntry{
 		ServiceId: "$ota",
 		EventType: "version_report",
@@ -678,18 +1243,67 @@ func (mqttClient *MqttDeviceClient) reportVersion() {
 		},
 	}
 	data := model.Data{
-		ObjectDeviceId: mqttClient.ConnectAuthConfig.Id,
+		ObjectDeviceId: deviceId,
 		Services:       []model.DataEntry{dataEntry},
 	}
-	glog.Infof("report version query. body: %s", iot.Interface2JsonString(data))
-	if token := mqttClient.client.Publish(iot.FormatTopic(constants.DeviceToPlatformTopic, mqttClient.ConnectAuthConfig.Id), mqttClient.ConnectAuthConfig.Qos,
-		false, iot.Interface2JsonString(data)); token.Wait() && token.Error() != nil {
-		glog.Errorf("device %s report version query failed,type %d", mqttClient.ConnectAuthConfig.Id)
+	mqttClient.GetLogger().Info("report version", logger.String("object_device_id", deviceId), logger.String("sw_version", sw), logger.String("fw_version", fw))
+	if err := mqttClient.publish(context.Background(), iot.FormatTopic(constants.DeviceToPlatformTopic, mqttClient.ConnectAuthConfig.Id), mqttClient.ConnectAuthConfig.Qos,
+		iot.Interface2JsonString(data)); err != nil {
+		mqttClient.GetLogger().Error("report version failed", logger.Err(err))
 	}
 }
 
 func (mqttClient *MqttDeviceClient) upgradeDevice(upgradeType byte, upgradeInfo *model.UpgradeInfo) {
+	if mqttClient.OtaManager != nil {
+		if err := mqttClient.OtaManager.UseTLS(mqttClient.ConnectAuthConfig.TLS); err != nil {
+			mqttClient.rejectUpgrade(mqttClient.ConnectAuthConfig.Id, err)
+			return
+		}
+		// 升级耗时较长，不阻塞消息处理
+		go mqttClient.OtaManager.Upgrade(mqttClient.StateMachine.done(), ota.Task{UpgradeType: upgradeType, Info: *upgradeInfo},
+			func(progress model.UpgradeProgress) error {
+				return mqttClient.reportUpgradeProgress(progress)
+			})
+		return
+	}
+	if mqttClient.DeviceUpgradeHandler == nil {
+		mqttClient.GetLogger().Warn("device upgrade handler is not set", logger.Any("upgrade_type", upgradeType))
+		return
+	}
 	progress := mqttClient.DeviceUpgradeHandler(upgradeType, *upgradeInfo)
+	if err := mqttClient.reportUpgradeProgress(progress); err != nil {
+		mqttClient.GetLogger().Error("report upgrade progress failed", logger.Any("upgrade_type", upgradeType), logger.Err(err))
+	}
+}
+
+// rejectUpgrade 按ConnectAuthConfig.TLS创建下载客户端失败时直接上报升级失败
+func (mqttClient *MqttDeviceClient) rejectUpgrade(deviceId string, err error) {
+	err = iot.NewDeviceError("upgrade", deviceId, iot.ErrTLSLoad, err)
+	mqttClient.GetLogger().Error("load ota http tls config failed", logger.DeviceId(deviceId), logger.Err(err))
+	progress := model.UpgradeProgress{
+		ResultCode:  ota.ResultInternalError,
+		Description: err.Error(),
+	}
+	if err := mqttClient.reportUpgradeProgressOf(deviceId, progress); err != nil {
+		mqttClient.GetLogger().Error("report upgrade progress failed", logger.DeviceId(deviceId), logger.Err(err))
+	}
+}
+
+// recoverUpgrade 存在升级记录时上报新版本号，并上报之前未上报成功的确认结果
+func (mqttClient *MqttDeviceClient) recoverUpgrade() {
+	if mqttClient.OtaManager.Persisted() && mqttClient.SwFwVersionReporter != nil {
+		mqttClient.reportVersion()
+	}
+	mqttClient.OtaManager.Recover(mqttClient.reportUpgradeProgress)
+}
+
+// reportUpgradeProgress 上报升级进度
+func (mqttClient *MqttDeviceClient) reportUpgradeProgress(progress model.UpgradeProgress) error {
+	return mqttClient.reportUpgradeProgressOf(mqttClient.ConnectAuthConfig.Id, progress)
+}
+
+// reportUpgradeProgressOf 上报设备或子设备的升级进度
+func (mqttClient *MqttDeviceClient) reportUpgradeProgressOf(deviceId string, progress model.UpgradeProgress) error {
 	dataEntry := model.DataEntry{
 		ServiceId: "$ota",
 		EventType: "upgrade_progress_report",
@@ -697,14 +1311,12 @@ func (mqttClient *MqttDeviceClient) upgradeDevice(upgradeType byte, upgradeInfo
 		Paras:     progress,
 	}
 	data := model.Data{
-		ObjectDeviceId: mqttClient.ConnectAuthConfig.Id,
+		ObjectDeviceId: deviceId,
 		Services:       []model.DataEntry{dataEntry},
 	}
 
-	if token := mqttClient.client.Publish(iot.FormatTopic(constants.DeviceToPlatformTopic, mqttClient.ConnectAuthConfig.Id), mqttClient.ConnectAuthConfig.Qos,
-		false, iot.Interface2JsonString(data)); token.Wait() && token.Error() != nil {
-		glog.Errorf("device %s upgrade failed,type %d", mqttClient.ConnectAuthConfig.Id, upgradeType)
-	}
+	return mqttClient.publish(context.Background(), iot.FormatTopic(constants.DeviceToPlatformTopic, mqttClient.ConnectAuthConfig.Id), mqttClient.ConnectAuthConfig.Qos,
+		iot.Interface2JsonString(data))
 }
 
 func (mqttClient *MqttDeviceClient) reportLogsWorker() {
@@ -722,7 +1334,7 @@ func (mqttClient *MqttDeviceClient) deviceCommandLogCollect() {
 			}
 			logs := mqttClient.DeviceCommandLogCollector(mqttClient.Lcc.GetEndTime())
 			if len(logs) == 0 {
-				glog.Warningf("no log about device command")
+				mqttClient.GetLogger().Warn("no log about device command")
 				break
 			}
 			mqttClient.reportLogs(logs)
@@ -739,7 +1351,7 @@ func (mqttClient *MqttDeviceClient) deviceMessageLogCollect() {
 			}
 			logs := mqttClient.DeviceMessageLogCollector(mqttClient.Lcc.GetEndTime())
 			if len(logs) == 0 {
-				glog.Warningf("no log about device message")
+				mqttClient.GetLogger().Warn("no log about device message")
 				break
 			}
 			mqttClient.reportLogs(logs)
@@ -756,7 +1368,7 @@ func (mqttClient *MqttDeviceClient) devicePropertyReportLogCollect() {
 			}
 			logs := mqttClient.DevicePropertyLogCollector(mqttClient.Lcc.GetEndTime())
 			if len(logs) == 0 {
-				glog.Warningf("no log about device property")
+				mqttClient.GetLogger().Warn("no log about device property")
 				break
 			}
 			mqttClient.reportLogs(logs)
@@ -773,7 +1385,7 @@ func (mqttClient *MqttDeviceClient) deviceStatusLogCollect() {
 			}
 			logs := mqttClient.DeviceStatusLogCollector(mqttClient.Lcc.GetEndTime())
 			if len(logs) == 0 {
-				glog.Warningf("no log about device status")
+				mqttClient.GetLogger().Warn("no log about device status")
 				break
 			}
 			mqttClient.reportLogs(logs)
@@ -798,7 +1410,19 @@ func (mqttClient *MqttDeviceClient) reportLogs(logs []model.DeviceLogEntry) {
 	}
 
 	reportedLog := iot.Interface2JsonString(data)
-	mqttClient.client.Publish(iot.FormatTopic(constants.DeviceToPlatformTopic, mqttClient.ConnectAuthConfig.Id), 0, false, reportedLog)
+	if err := mqttClient.publish(context.Background(), iot.FormatTopic(constants.DeviceToPlatformTopic, mqttClient.ConnectAuthConfig.Id), 0, reportedLog); err != nil {
+		mqttClient.GetLogger().Warn("report logs failed", logger.Err(err))
+	}
+}
+
+// useTLS 根据接入地址的协议判断是否使用TLS
+func useTLS(servers string) bool {
+	for _, scheme := range []string{"tls://", "ssl://", "mqtts://", "tcps://", "wss://"} {
+		if strings.HasPrefix(strings.ToLower(servers), scheme) {
+			return true
+		}
+	}
+	return false
 }
 
 func assembleClientId(device config.ConnectAuthConfig) string {
@@ -815,17 +1439,17 @@ func assembleClientId(device config.ConnectAuthConfig) string {
 	return strings.Join(segments, "_")
 }
 
-func logFlush() {
-	ticker := time.Tick(5 * time.Second)
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-	for {
-		select {
-		case <-ticker:
-			glog.Flush()
-			break
-		case <-interrupt:
-			return
-		}
+func (mqttClient *MqttDeviceClient) getMetrics() metrics.Metrics {
+	if mqttClient.Metrics == nil {
+		return metrics.NewNopMetrics()
+	}
+	return mqttClient.Metrics
+}
+
+// GetLogger 返回设备使用的Logger，日志携带device_id字段
+func (mqttClient *MqttDeviceClient) GetLogger() logger.Logger {
+	if mqttClient.Logger != nil {
+		return mqttClient.Logger
 	}
+	return mqttClient.ConnectAuthConfig.GetLogger()
 }
@@ -0,0 +1,340 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/constants"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/ota"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/rule"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/transport/fake"
+	"github.com/panjf2000/ants/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testDeviceId = "test_device"
+
+// newTestClient 与device.NewMqttDevice相同的方式创建连接到模拟平台的客户端
+func newTestClient(t *testing.T, platform *fake.Platform, setup func(client *MqttDeviceClient)) *MqttDeviceClient {
+	t.Helper()
+	autoReconnect := false
+	authConfig := &config.ConnectAuthConfig{
+		Id:               testDeviceId,
+		Secret:           "test secret",
+		Servers:          "tcp://fake-platform:1883",
+		AutoReconnect:    &autoReconnect,
+		ConnectTimeOut:   5 * time.Second,
+		PublishTimeOut:   5 * time.Second,
+		RequestTimeOut:   5 * time.Second,
+		BackOffTime:      1000,
+		MinBackOffTime:   1000,
+		MaxBackOffTime:   1000,
+		ThreadNum:        4,
+		TransportFactory: platform.Factory(),
+	}
+	pool, err := ants.NewPool(authConfig.ThreadNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pendingRequests := NewPendingRequests()
+	client := &MqttDeviceClient{
+		ConnectAuthConfig:   authConfig,
+		Pool:                pool,
+		PendingRequests:     pendingRequests,
+		FileTransfers:       NewFileTransfers(pendingRequests),
+		RuleActionExecutors: rule.NewActionExecutors(),
+		RuleManageService:   rule.NewRuleManageService(nil),
+		Subscriptions:       NewSubscriptions(),
+		StateMachine:        NewStateMachine(),
+		Logger:              authConfig.GetLogger(),
+	}
+	if setup != nil {
+		setup(client)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.ConnectWithContext(ctx); err != nil {
+		t.Fatalf("connect to fake platform failed: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close(0)
+	})
+	return client
+}
+
+// waitPublished 等待设备在topic上发布消息并解析消息内容
+func waitPublished(t *testing.T, platform *fake.Platform, topic string, v interface{}) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	published, err := platform.WaitPublished(ctx, topic)
+	if err != nil {
+		t.Fatalf("wait message on %s failed: %v", topic, err)
+	}
+	if err := json.Unmarshal(published.Message.Payload, v); err != nil {
+		t.Fatalf("unmarshal %s failed: %v", string(published.Message.Payload), err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		ok         bool
+		response   interface{}
+		resultCode byte
+	}{
+		{name: "success", ok: true, response: map[string]interface{}{"state": "on"}, resultCode: 0},
+		{name: "success without paras", ok: true, resultCode: 0},
+		{name: "failed", ok: false, response: "device busy", resultCode: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platform := fake.NewPlatform()
+			received := make(chan model.Command, 1)
+			newTestClient(t, platform, func(client *MqttDeviceClient) {
+				client.CommandHandler = func(command model.Command) (bool, interface{}) {
+					received <- command
+					return tt.ok, tt.response
+				}
+			})
+			command := model.Command{
+				ServiceId:   "switch",
+				CommandName: "turn_on",
+				Paras:       map[string]interface{}{"level": float64(3)},
+			}
+			requestId, err := platform.SendCommand(testDeviceId, command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := model.CommandResponse{}
+			waitPublished(t, platform, iot.FormatTopic(constants.CommandResponseTopic, testDeviceId)+requestId, &response)
+			if got := <-received; !reflect.DeepEqual(got, command) {
+				t.Errorf("handler received %+v, want %+v", got, command)
+			}
+			if response.ResultCode != tt.resultCode {
+				t.Errorf("result code = %d, want %d", response.ResultCode, tt.resultCode)
+			}
+			if !reflect.DeepEqual(response.Paras, tt.response) {
+				t.Errorf("paras = %#v, want %#v", response.Paras, tt.response)
+			}
+		})
+	}
+}
+
+func TestPropertiesSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		handlers   []bool
+		resultCode byte
+	}{
+		{name: "no handler", resultCode: 0},
+		{name: "success", handlers: []bool{true}, resultCode: 0},
+		{name: "one handler failed", handlers: []bool{true, false}, resultCode: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platform := fake.NewPlatform()
+			newTestClient(t, platform, func(client *MqttDeviceClient) {
+				for _, ok := range tt.handlers {
+					ok := ok
+					client.AddPropertiesSetHandler(func(request model.DevicePropertyDownRequest) bool {
+						return ok
+					})
+				}
+			})
+			requestId, err := platform.SetProperties(testDeviceId, model.DevicePropertyDownRequest{
+				Services: []model.DevicePropertyDownRequestEntry{{
+					ServiceId:  "sensor",
+					Properties: map[string]interface{}{"interval": 10},
+				}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := struct {
+				ResultCode byte `json:"result_code"`
+			}{}
+			waitPublished(t, platform, iot.FormatTopic(constants.PropertiesSetResponseTopic, testDeviceId)+requestId, &response)
+			if response.ResultCode != tt.resultCode {
+				t.Errorf("result code = %d, want %d", response.ResultCode, tt.resultCode)
+			}
+		})
+	}
+}
+
+func TestPropertiesQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		serviceId string
+		want      model.DevicePropertyEntry
+	}{
+		{
+			name:      "known service",
+			serviceId: "sensor",
+			want:      model.DevicePropertyEntry{ServiceId: "sensor", Properties: map[string]interface{}{"temp": float64(25)}},
+		},
+		{
+			name:      "unknown service",
+			serviceId: "light",
+			want:      model.DevicePropertyEntry{ServiceId: "light"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platform := fake.NewPlatform()
+			newTestClient(t, platform, func(client *MqttDeviceClient) {
+				client.SetPropertyQueryHandler(func(query model.DevicePropertyQueryRequest) model.DevicePropertyEntry {
+					entry := model.DevicePropertyEntry{ServiceId: query.ServiceId}
+					if query.ServiceId == "sensor" {
+						entry.Properties = map[string]interface{}{"temp": 25}
+					}
+					return entry
+				})
+			})
+			requestId, err := platform.QueryProperties(testDeviceId, model.DevicePropertyQueryRequest{ServiceId: tt.serviceId})
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := model.DevicePropertyEntry{}
+			waitPublished(t, platform, iot.FormatTopic(constants.PropertiesQueryResponseTopic, testDeviceId)+requestId, &response)
+			if !reflect.DeepEqual(response, tt.want) {
+				t.Errorf("response = %+v, want %+v", response, tt.want)
+			}
+		})
+	}
+}
+
+func TestOtaUpgrade(t *testing.T) {
+	content := []byte("firmware v2 image")
+	sum := md5.Sum(content)
+	sign := hex.EncodeToString(sum[:])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/firmware.bin" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		sign       string
+		resultCode int
+		installed  bool
+	}{
+		{name: "success", path: "/firmware.bin", sign: sign, resultCode: ota.ResultSuccess, installed: true},
+		{name: "sign mismatch", path: "/firmware.bin", sign: hex.EncodeToString(make([]byte, md5.Size)), resultCode: ota.ResultVerifyFailed},
+		{name: "package not found", path: "/missing.bin", sign: sign, resultCode: ota.ResultInternalError},
+		{name: "unsigned", path: "/firmware.bin", resultCode: ota.ResultVerifyFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platform := fake.NewPlatform()
+			installed := make(chan []byte, 1)
+			newTestClient(t, platform, func(client *MqttDeviceClient) {
+				client.OtaManager = ota.NewManager(ota.Options{
+					Dir: t.TempDir(),
+					Installer: ota.InstallerFunc(func(ctx context.Context, task ota.Task, packagePath string) error {
+						data, err := os.ReadFile(packagePath)
+						installed <- data
+						return err
+					}),
+					CurrentVersion: func(task ota.Task) string {
+						return "v1"
+					},
+				})
+			})
+			err := platform.SendEvent(testDeviceId, model.Data{
+				Services: []model.DataEntry{{
+					ServiceId: "$ota",
+					EventType: "firmware_upgrade",
+					Paras: model.UpgradeInfo{
+						Version:  "v2",
+						Url:      server.URL + tt.path,
+						FileSize: len(content),
+						Sign:     tt.sign,
+					},
+				}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 依次读取进度上报，直到升级成功或失败
+			var progress model.UpgradeProgress
+			for progress.ResultCode == ota.ResultSuccess && progress.Progress != 100 {
+				data := struct {
+					Services []struct {
+						EventType string                `json:"event_type"`
+						Paras     model.UpgradeProgress `json:"paras"`
+					} `json:"services"`
+				}{}
+				waitPublished(t, platform, iot.FormatTopic(constants.DeviceToPlatformTopic, testDeviceId), &data)
+				if len(data.Services) != 1 || data.Services[0].EventType != "upgrade_progress_report" {
+					t.Fatalf("unexpected event %+v", data)
+				}
+				progress = data.Services[0].Paras
+			}
+			if progress.ResultCode != tt.resultCode {
+				t.Fatalf("result code = %d, want %d: %s", progress.ResultCode, tt.resultCode, progress.Description)
+			}
+			select {
+			case data := <-installed:
+				if !tt.installed {
+					t.Fatal("package installed after failed upgrade")
+				}
+				if string(data) != string(content) {
+					t.Errorf("installed %q, want %q", data, content)
+				}
+				if progress.Version != "v2" {
+					t.Errorf("version = %s, want v2", progress.Version)
+				}
+			default:
+				if tt.installed {
+					t.Fatal("package not installed")
+				}
+				if progress.Version != "v1" {
+					t.Errorf("version = %s, want v1", progress.Version)
+				}
+			}
+		})
+	}
+}
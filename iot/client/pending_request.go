@@ -0,0 +1,149 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package client
+
+import (
+	"context"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PendingRequests 等待平台响应的请求，key可以是request_id、文件名或者事件类型。
+// 同一个key有多个等待者时，平台响应按注册顺序依次交给等待者
+type PendingRequests struct {
+	lock    sync.Mutex
+	waiters map[string][]*PendingRequest
+}
+
+// PendingRequest 一个等待平台响应的请求
+type PendingRequest struct {
+	key      string
+	response chan interface{}
+	registry *PendingRequests
+}
+
+func NewPendingRequests() *PendingRequests {
+	return &PendingRequests{
+		waiters: make(map[string][]*PendingRequest),
+	}
+}
+
+// Register 注册等待者，需要在发送请求之前调用，避免响应先于注册到达
+func (pr *PendingRequests) Register(key string) *PendingRequest {
+	request := &PendingRequest{
+		key:      key,
+		response: make(chan interface{}, 1),
+		registry: pr,
+	}
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	pr.waiters[key] = append(pr.waiters[key], request)
+	return request
+}
+
+// Complete 将平台响应交给最早注册的等待者，没有等待者时返回false
+func (pr *PendingRequests) Complete(key string, response interface{}) bool {
+	pr.lock.Lock()
+	waiters := pr.waiters[key]
+	if len(waiters) == 0 {
+		pr.lock.Unlock()
+		return false
+	}
+	request := waiters[0]
+	if len(waiters) == 1 {
+		delete(pr.waiters, key)
+	} else {
+		pr.waiters[key] = waiters[1:]
+	}
+	pr.lock.Unlock()
+
+	request.response <- response
+	return true
+}
+
+func (pr *PendingRequests) remove(request *PendingRequest) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	waiters := pr.waiters[request.key]
+	for i, waiter := range waiters {
+		if waiter != request {
+			continue
+		}
+		waiters = append(waiters[:i:i], waiters[i+1:]...)
+		if len(waiters) == 0 {
+			delete(pr.waiters, request.key)
+		} else {
+			pr.waiters[request.key] = waiters
+		}
+		return
+	}
+}
+
+// Wait 等待平台响应，ctx没有设置超时时间时使用timeout作为超时时间
+func (request *PendingRequest) Wait(ctx context.Context, timeout time.Duration) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	select {
+	case response := <-request.response:
+		return response, nil
+	case <-ctx.Done():
+		request.Cancel()
+		return nil, iot.NewDeviceError("wait response", request.key, iot.ErrResponseTimeout, ctx.Err())
+	}
+}
+
+// Cancel 取消等待，不再需要响应时调用
+func (request *PendingRequest) Cancel() {
+	request.registry.remove(request)
+}
+
+// ShadowQueryKey 设备影子查询响应的key
+func ShadowQueryKey(requestId string) string {
+	return "shadow/" + requestId
+}
+
+// TimeSyncKey 时间同步响应的key，平台响应中会携带设备发送请求的时间
+func TimeSyncKey(deviceSendTime int64) string {
+	return "$time_sync/" + strconv.FormatInt(deviceSendTime, 10)
+}
+
+// FileUrlKey 文件上传下载URL响应的key
+func FileUrlKey(objectName, action string) string {
+	return "$file_manager/" + action + "/" + objectName
+}
+
+// EventKey 没有请求标识的事件响应，按事件类型匹配
+func EventKey(serviceId, eventType string) string {
+	return serviceId + "/" + eventType
+}
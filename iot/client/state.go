@@ -0,0 +1,177 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionState 设备与平台的连接状态
+type ConnectionState int32
+
+const (
+	// StateDisconnected 未建链或断链后未开始重连
+	StateDisconnected ConnectionState = iota
+	// StateConnecting 正在建链
+	StateConnecting
+	// StateConnected 已建链
+	StateConnected
+	// StateBackoff 建链失败，等待下一次重试
+	StateBackoff
+	// StateClosed 设备已关闭，不再重连
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateBackoff:
+		return "backoff"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateEvent 连接状态变化事件
+type StateEvent struct {
+	From      ConnectionState
+	To        ConnectionState
+	Reason    error         // 状态变化的原因，如建链失败或断链的错误
+	Attempt   int64         // 本轮建链的第几次尝试，从1开始
+	NextRetry time.Duration // 进入StateBackoff时距离下一次重试的时间
+	Time      time.Time
+}
+
+// StateMachine 记录连接状态并通知订阅者，Closed之后状态不再变化
+type StateMachine struct {
+	lock         sync.Mutex
+	state        ConnectionState
+	watchers     map[int]chan StateEvent
+	nextId       int
+	reconnecting int32
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+func NewStateMachine() *StateMachine {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &StateMachine{
+		state:    StateDisconnected,
+		watchers: make(map[int]chan StateEvent),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// State 当前的连接状态
+func (sm *StateMachine) State() ConnectionState {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	return sm.state
+}
+
+// Subscribe 订阅状态变化事件，buffer为通道容量，订阅者处理不及时导致通道已满时丢弃事件。
+// 返回的函数用于取消订阅，取消后通道被关闭
+func (sm *StateMachine) Subscribe(buffer int) (<-chan StateEvent, func()) {
+	events := make(chan StateEvent, buffer)
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	id := sm.nextId
+	sm.nextId++
+	sm.watchers[id] = events
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			sm.lock.Lock()
+			defer sm.lock.Unlock()
+			if _, ok := sm.watchers[id]; ok {
+				delete(sm.watchers, id)
+				close(events)
+			}
+		})
+	}
+}
+
+// transition 切换状态并通知订阅者，状态未变化或已经Closed时忽略，返回是否切换成功
+func (sm *StateMachine) transition(to ConnectionState, reason error, attempt int64, nextRetry time.Duration) bool {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	if sm.state == StateClosed || sm.state == to {
+		return false
+	}
+	event := StateEvent{
+		From:      sm.state,
+		To:        to,
+		Reason:    reason,
+		Attempt:   attempt,
+		NextRetry: nextRetry,
+		Time:      time.Now(),
+	}
+	sm.state = to
+	for _, watcher := range sm.watchers {
+		select {
+		case watcher <- event:
+		default:
+		}
+	}
+	if to == StateClosed {
+		sm.cancel()
+		for id, watcher := range sm.watchers {
+			delete(sm.watchers, id)
+			close(watcher)
+		}
+	}
+	return true
+}
+
+// done Closed之后被取消，用于停止后台重连和后台任务，sm为nil时返回context.Background()
+func (sm *StateMachine) done() context.Context {
+	if sm == nil {
+		return context.Background()
+	}
+	return sm.ctx
+}
+
+// beginReconnect 保证同一时间只有一个后台重连
+func (sm *StateMachine) beginReconnect() bool {
+	return atomic.CompareAndSwapInt32(&sm.reconnecting, 0, 1)
+}
+
+func (sm *StateMachine) endReconnect() {
+	atomic.StoreInt32(&sm.reconnecting, 0)
+}
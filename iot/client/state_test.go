@@ -0,0 +1,165 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStateMachineTransition(t *testing.T) {
+	errConnect := errors.New("connect refused")
+	type step struct {
+		to     ConnectionState
+		reason error
+		ok     bool
+	}
+	cases := []struct {
+		name  string
+		steps []step
+		state ConnectionState
+	}{
+		{
+			name: "connect",
+			steps: []step{
+				{to: StateConnecting, ok: true},
+				{to: StateConnected, ok: true},
+			},
+			state: StateConnected,
+		},
+		{
+			name: "same state ignored",
+			steps: []step{
+				{to: StateConnecting, ok: true},
+				{to: StateConnecting, ok: false},
+			},
+			state: StateConnecting,
+		},
+		{
+			name: "backoff then reconnect",
+			steps: []step{
+				{to: StateConnecting, ok: true},
+				{to: StateBackoff, reason: errConnect, ok: true},
+				{to: StateConnecting, ok: true},
+				{to: StateConnected, ok: true},
+				{to: StateDisconnected, reason: errConnect, ok: true},
+			},
+			state: StateDisconnected,
+		},
+		{
+			name: "closed is terminal",
+			steps: []step{
+				{to: StateConnected, ok: true},
+				{to: StateClosed, ok: true},
+				{to: StateConnecting, ok: false},
+				{to: StateClosed, ok: false},
+			},
+			state: StateClosed,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sm := NewStateMachine()
+			events, _ := sm.Subscribe(len(c.steps))
+			var want []StateEvent
+			from := StateDisconnected
+			for i, s := range c.steps {
+				ok := sm.transition(s.to, s.reason, int64(i+1), time.Second)
+				if ok != s.ok {
+					t.Fatalf("step %d: transition(%v) = %v, want %v", i, s.to, ok, s.ok)
+				}
+				if ok {
+					want = append(want, StateEvent{From: from, To: s.to, Reason: s.reason, Attempt: int64(i + 1)})
+					from = s.to
+				}
+			}
+			if state := sm.State(); state != c.state {
+				t.Fatalf("State() = %v, want %v", state, c.state)
+			}
+			for i, w := range want {
+				select {
+				case e := <-events:
+					if e.From != w.From || e.To != w.To || e.Reason != w.Reason || e.Attempt != w.Attempt {
+						t.Fatalf("event %d = %+v, want %+v", i, e, w)
+					}
+				default:
+					t.Fatalf("event %d missing, want %+v", i, w)
+				}
+			}
+			if c.state == StateClosed {
+				if _, ok := <-events; ok {
+					t.Fatal("watcher not closed after StateClosed")
+				}
+				select {
+				case <-sm.done().Done():
+				default:
+					t.Fatal("done context not cancelled after StateClosed")
+				}
+			} else {
+				select {
+				case e := <-events:
+					t.Fatalf("unexpected event %+v", e)
+				default:
+				}
+			}
+		})
+	}
+}
+
+func TestStateMachineSubscribe(t *testing.T) {
+	sm := NewStateMachine()
+	full, _ := sm.Subscribe(0)
+	events, unsubscribe := sm.Subscribe(1)
+	// 通道已满时丢弃事件，不阻塞状态切换
+	sm.transition(StateConnecting, nil, 1, 0)
+	sm.transition(StateConnected, nil, 1, 0)
+	if e := <-events; e.To != StateConnecting {
+		t.Fatalf("first event to %v, want %v", e.To, StateConnecting)
+	}
+	select {
+	case e := <-full:
+		t.Fatalf("unexpected event %+v on unbuffered watcher", e)
+	default:
+	}
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Fatal("channel not closed after unsubscribe")
+	}
+	// 取消订阅后Closed不会重复关闭通道
+	sm.transition(StateClosed, nil, 1, 0)
+	if _, ok := <-full; ok {
+		t.Fatal("watcher not closed after StateClosed")
+	}
+	var nilMachine *StateMachine
+	if nilMachine.done().Err() != nil {
+		t.Fatal("nil state machine done context cancelled")
+	}
+}
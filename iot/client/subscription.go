@@ -0,0 +1,106 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package client
+
+import (
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/callback"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/transport"
+	"sort"
+	"sync"
+)
+
+// Subscription 一条自定义topic订阅
+type Subscription struct {
+	Topic   string
+	Qos     byte
+	Handler callback.MessageHandler
+}
+
+// Subscriptions 自定义topic订阅记录，重连成功后会按记录重新订阅
+type Subscriptions struct {
+	lock  sync.RWMutex
+	items map[string]Subscription
+}
+
+func NewSubscriptions() *Subscriptions {
+	return &Subscriptions{
+		items: make(map[string]Subscription),
+	}
+}
+
+// Add 添加订阅记录，相同topic的订阅会被覆盖
+func (s *Subscriptions) Add(subscription Subscription) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.items[subscription.Topic] = subscription
+}
+
+// Remove 删除订阅记录，记录不存在时返回false
+func (s *Subscriptions) Remove(topic string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.items[topic]; !ok {
+		return false
+	}
+	delete(s.items, topic)
+	return true
+}
+
+// Get 获取topic过滤器对应的订阅记录
+func (s *Subscriptions) Get(topic string) (Subscription, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	subscription, ok := s.items[topic]
+	return subscription, ok
+}
+
+// List 返回全部订阅记录，按topic排序
+func (s *Subscriptions) List() []Subscription {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	subscriptions := make([]Subscription, 0, len(s.items))
+	for _, subscription := range s.items {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Topic < subscriptions[j].Topic
+	})
+	return subscriptions
+}
+
+// Match 返回与消息topic匹配的全部订阅记录，支持+和#通配符
+func (s *Subscriptions) Match(topic string) []Subscription {
+	var matched []Subscription
+	for _, subscription := range s.List() {
+		if transport.MatchTopic(subscription.Topic, topic) {
+			matched = append(matched, subscription)
+		}
+	}
+	return matched
+}
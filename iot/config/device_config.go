@@ -29,7 +29,12 @@
 package config
 
 import (
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/metrics"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/store"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/tlsconfig"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/transport"
 	"sync"
 	"time"
 )
@@ -51,15 +56,43 @@ type ConnectAuthConfig struct {
 	ScopeId            string
 	BootStrapBody      *model.BootStrapProperties // 使用设备引导功能时，静态策略为数据上报时的结构体
 	ConnectTimeOut     time.Duration              // 与平台建链超时时间
+	PublishTimeOut     time.Duration              // 发布消息等待完成的超时时间，默认30s
+	RequestTimeOut     time.Duration              // 等待平台响应的超时时间，默认30s
 	AutoReconnect      *bool                      // 是否支持断链重连，默认为True
 	BackOffTime        int64                      // 退避系数，默认1000ms
 	MinBackOffTime     int64                      // 最小退避时间, 默认1000ms
 	MaxBackOffTime     int64                      // 最大退避时间, 默认30000ms
 	ThreadNum          int                        // 协程数量，用于处理平台的消息,默认10
 	RuleEnable         bool                       // 是否开启端侧规则
-	MaxBufferMessage   int                        // max buffer max
+	MaxBufferMessage   int                        // 断链期间最多缓存的消息条数
+	MaxBufferBytes     int64                      // 断链期间最多缓存的消息字节数，0表示不限制
+	MaxBufferAge       time.Duration              // 断链期间缓存消息的最长保留时间，0表示不限制
+	BufferFilePath     string                     // 离线消息持久化文件路径，设置后缓存的消息写入磁盘，进程重启后继续发送
+	BufferStore        store.MessageStore         // 自定义离线消息缓存，设置后忽略BufferFilePath
+	RuleFilePath       string                     // 端侧规则持久化文件路径，设置后设备重启时在建链之前恢复规则
+	RuleStore          store.RuleStore            // 自定义端侧规则存储，设置后忽略RuleFilePath
+	MaxRuleExecutions  int                        // 保留的端侧规则执行记录条数，默认1000
+	RuleExecFilePath   string                     // 端侧规则执行记录持久化文件路径，不设置时只保存在内存中
+	RuleExecStore      store.RuleExecutionStore   // 自定义端侧规则执行记录存储，设置后忽略RuleExecFilePath
+	RuleExecReport     string                     // 执行记录上报平台的方式，constants.RuleExecutionReportEvent或RuleExecutionReportLog，默认不上报
+	RuleTrigger        model.RuleTrigger          // 规则没有设置trigger时的触发方式，默认每次上报的属性满足条件时都执行
 	InflightMessages   int                        // qos1时最多可以同时发布多条消息，默认20条
 	ConnectTimeout     int                        // 心跳时间
+	ProtocolVersion    string                     // MQTT协议版本，transport.ProtocolMqtt311或transport.ProtocolMqtt5，默认3.1.1
+	SessionExpiry      time.Duration              // MQTT5会话过期时间，0表示断链即清理会话
+	TransportFactory   transport.Factory          // 自定义MQTT连接的创建方式，设置后忽略ProtocolVersion，如使用fake.Platform进行测试
+	Logger             logger.Logger              // 日志接口，默认使用logger.Default()
+	Metrics            metrics.Metrics            // 指标记录接口，如prometheus.NewCollector()，默认不记录
+	TLS                tlsconfig.Settings         // MQTT、设备发放、文件和OTA下载共用的证书校验和代理配置
+}
+
+// GetLogger 返回设备使用的Logger，日志携带device_id字段
+func (authConfig *ConnectAuthConfig) GetLogger() logger.Logger {
+	log := authConfig.Logger
+	if log == nil {
+		log = logger.Default()
+	}
+	return log.With(logger.DeviceId(authConfig.Id))
 }
 
 type ScopeConfig struct {
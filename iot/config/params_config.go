@@ -42,17 +42,20 @@ type DeviceParamsConfig struct {
 	SubDeviceAddResponseHandler      callback.SubDeviceAddResponseHandler
 	SubDeviceDeleteResponseHandler   callback.SubDeviceDeleteResponseHandler
 	SwFwVersionReporter              callback.SwFwVersionReporter
+	SubDeviceVersionReporter         callback.SubDeviceVersionReporter
 	DeviceUpgradeHandler             callback.DeviceUpgradeHandler
 	DeviceStatusLogCollector         callback.DeviceStatusLogCollector
 	DevicePropertyLogCollector       callback.DevicePropertyLogCollector
 	DeviceMessageLogCollector        callback.DeviceMessageLogCollector
 	DeviceCommandLogCollector        callback.DeviceCommandLogCollector
-	FileUrls                         map[string]string
+	FileUrls                         map[string]string // Deprecated: 文件传输由MqttDeviceClient.FileTransfers跟踪，sdk不再读写该字段
 	Lcc                              *LogCollectionConfig
 	ConnectionLostHandler            callback.ConnectionLostHandler
 	ConnectHandler                   callback.DeviceConnectHandler
 	SyncTimeResponseHandler          callback.SyncTimeResponseHandler
 	RuleActionHandler                callback.RuleActionHandler
+	RuleActionResultHandler          callback.RuleActionResultHandler
+	SubDeviceCommandHandler          callback.CommandHandler // 网关处理端侧规则中子设备的命令，ObjectDeviceId为子设备id
 }
 
 func (config *DeviceParamsConfig) AddCommandHandler(handler callback.CommandHandler) {
@@ -131,3 +134,11 @@ func (config *DeviceParamsConfig) SetDeviceMessageLogCollector(collector callbac
 func (config *DeviceParamsConfig) SetDeviceCommandLogCollector(collector callback.DeviceCommandLogCollector) {
 	config.DeviceCommandLogCollector = collector
 }
+
+func (config *DeviceParamsConfig) SetRuleActionResultHandler(handler callback.RuleActionResultHandler) {
+	config.RuleActionResultHandler = handler
+}
+
+func (config *DeviceParamsConfig) SetSubDeviceCommandHandler(handler callback.CommandHandler) {
+	config.SubDeviceCommandHandler = handler
+}
@@ -71,6 +71,9 @@ const (
 	FileActionUpload   string = "upload"
 	FileActionDownload string = "download"
 
+	// FileDownloadPushEvent 平台主动下发文件的事件类型，与设备请求下载URL的响应get_download_url_response区分
+	FileDownloadPushEvent string = "download_url_push"
+
 	// DeviceToPlatformTopic 设备或网关向平台发送请求
 	DeviceToPlatformTopic string = "$oc/devices/{device_id}/sys/events/up"
 
@@ -83,6 +86,12 @@ const (
 	AuthTypeX509     uint8 = 1
 )
 
+// 端侧规则执行记录上报平台的方式
+const (
+	RuleExecutionReportEvent = "event" // 上报$device_rule服务的device_rule_execution_report事件
+	RuleExecutionReportLog   = "log"   // 作为DEVICE_RULE类型的设备日志上报
+)
+
 var CipherSuites = []uint16{
 	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
 	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
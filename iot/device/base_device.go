@@ -29,8 +29,12 @@
 package device
 
 import (
+	"context"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/callback"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/client"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/file"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"time"
 )
 
 // BaseDevice 设备基类， 所有设备均继承该类
@@ -49,3 +53,30 @@ type BaseDevice interface {
 	ReportLogs(logs []model.DeviceLogEntry) bool
 	RequestTimeSync() bool
 }
+
+// ContextDevice 在BaseDevice的基础上增加支持ctx取消、返回失败原因和等待平台响应的接口。
+// 新增的接口放在这里，避免已有的BaseDevice实现编译失败
+type ContextDevice interface {
+	BaseDevice
+
+	State() client.ConnectionState
+	SubscribeStateEvents(buffer int) (<-chan client.StateEvent, func())
+	UploadArchive(filename string, patterns []string, options file.ArchiveOptions) bool
+
+	// 以下接口支持ctx取消，并返回失败原因
+	ConnectWithContext(ctx context.Context) error
+	SendMessageWithContext(ctx context.Context, message model.Message) error
+	ReportPropertiesWithContext(ctx context.Context, properties model.DeviceProperties) error
+	BatchReportSubDevicesPropertiesWithContext(ctx context.Context, service model.DevicesService) error
+	QueryDeviceShadowWithContext(ctx context.Context, query model.DevicePropertyQueryRequest) error
+	UploadFileWithContext(ctx context.Context, filename, filePath string) error
+	UploadArchiveWithContext(ctx context.Context, filename string, patterns []string, options file.ArchiveOptions) error
+	DownloadFileWithContext(ctx context.Context, filename, filePath string) error
+	ReportDeviceInfoWithContext(ctx context.Context, swVersion, fwVersion string) error
+	ReportLogsWithContext(ctx context.Context, logs []model.DeviceLogEntry) error
+	RequestTimeSyncWithContext(ctx context.Context) error
+
+	// 以下接口会等待平台响应
+	QueryDeviceShadowSync(ctx context.Context, query model.DevicePropertyQueryRequest) (model.DeviceShadowQueryResponse, error)
+	RequestTimeSyncSync(ctx context.Context) (time.Duration, error)
+}
@@ -29,77 +29,163 @@
 package device
 
 import (
-	"flag"
-	"github.com/golang/glog"
+	"context"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/client"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/constants"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/file"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/rule"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/store"
 	"github.com/panjf2000/ants/v2"
 	uuid "github.com/satori/go.uuid"
+	"os"
 	"time"
 )
 
 type MqttDevice struct {
 	Client             client.MqttDeviceClient
 	ConnectionAuthInfo *config.ConnectAuthConfig
+	FileTransfer       file.TransferOptions // 文件上传下载的分段、重试、限速和进度配置
 }
 
 func NewMqttDevice(authConfig *config.ConnectAuthConfig) *MqttDevice {
-	// 初始化日志， 设置日志级别
-	flag.Parse()
-	err := flag.Set("stderrthreshold", "info")
-	if err != nil {
-		glog.Warningf("init log level failed.")
-	}
 	if authConfig == nil {
-		glog.Warningf("connection auth config is nil.")
+		logger.Default().Warn("connection auth config is nil")
 		return nil
 	}
+	log := authConfig.GetLogger()
 	if !checkAuthConfig(authConfig) {
-		glog.Infof("auth config params was invalid.")
+		log.Warn("auth config params was invalid")
 		return nil
 	}
 	pool, err := ants.NewPool(authConfig.ThreadNum)
 	if err != nil {
-		glog.Warningf("init go routing pool failed. err: %s", err.Error())
+		log.Warn("init go routing pool failed", logger.Err(err))
 		return nil
 	}
+	pendingRequests := client.NewPendingRequests()
 	device := &MqttDevice{
 		ConnectionAuthInfo: authConfig,
 		Client: client.MqttDeviceClient{
-			ConnectAuthConfig: authConfig,
-			Pool:              pool,
+			ConnectAuthConfig:   authConfig,
+			Pool:                pool,
+			PendingRequests:     pendingRequests,
+			FileTransfers:       client.NewFileTransfers(pendingRequests),
+			RuleActionExecutors: rule.NewActionExecutors(),
+			Subscriptions:       client.NewSubscriptions(),
+			StateMachine:        client.NewStateMachine(),
+			Logger:              log,
+			Metrics:             authConfig.Metrics,
 		},
 	}
-	if authConfig.MaxBufferMessage > 0 {
-		device.Client.Queue = iot.NewCircularQueue(authConfig.MaxBufferMessage)
+	bufferStore, err := createBufferStore(authConfig)
+	if err != nil {
+		log.Warn("init buffer store failed", logger.Err(err))
+		pool.Release()
+		return nil
+	}
+	// 创建失败时释放已经创建的协程池和缓存，用户设置的BufferStore由用户关闭
+	release := func() {
+		pool.Release()
+		if bufferStore != nil && authConfig.BufferStore == nil {
+			if err := bufferStore.Close(); err != nil {
+				log.Warn("close buffer store failed", logger.Err(err))
+			}
+		}
+	}
+	device.Client.BufferStore = bufferStore
+	if bufferStore != nil && authConfig.Metrics != nil {
+		authConfig.Metrics.BufferedMessages(authConfig.Id, bufferStore.Len())
 	}
-	device.Client.FileUrls = make(map[string]string)
+	ruleStore, err := createRuleStore(authConfig)
+	if err != nil {
+		log.Warn("init rule store failed", logger.Err(err))
+		release()
+		return nil
+	}
+	device.Client.RuleManageService = rule.NewRuleManageService(ruleStore)
+	device.Client.RuleManageService.DefaultTrigger = authConfig.RuleTrigger
+	executionStore, err := createRuleExecutionStore(authConfig)
+	if err != nil {
+		log.Warn("init rule execution store failed", logger.Err(err))
+		release()
+		return nil
+	}
+	device.Client.RuleManageService.ExecutionStore = executionStore
 	return device
 }
 
+// createRuleExecutionStore 创建端侧规则执行记录的存储，未配置文件路径时保存在内存中
+func createRuleExecutionStore(authConfig *config.ConnectAuthConfig) (store.RuleExecutionStore, error) {
+	if authConfig.RuleExecStore != nil {
+		return authConfig.RuleExecStore, nil
+	}
+	if len(authConfig.RuleExecFilePath) != 0 {
+		return store.NewFileRuleExecutionStore(authConfig.RuleExecFilePath, authConfig.MaxRuleExecutions)
+	}
+	return store.NewMemoryRuleExecutionStore(authConfig.MaxRuleExecutions), nil
+}
+
+// createRuleStore 创建端侧规则的持久化存储，未配置时返回nil
+func createRuleStore(authConfig *config.ConnectAuthConfig) (store.RuleStore, error) {
+	if authConfig.RuleStore != nil {
+		return authConfig.RuleStore, nil
+	}
+	if len(authConfig.RuleFilePath) != 0 {
+		return store.NewFileRuleStore(authConfig.RuleFilePath)
+	}
+	return nil, nil
+}
+
+// createBufferStore 创建断链期间的消息缓存，未配置缓存时返回nil
+func createBufferStore(authConfig *config.ConnectAuthConfig) (store.MessageStore, error) {
+	if authConfig.BufferStore != nil {
+		return authConfig.BufferStore, nil
+	}
+	policy := store.EvictionPolicy{
+		MaxMessages: authConfig.MaxBufferMessage,
+		MaxBytes:    authConfig.MaxBufferBytes,
+		MaxAge:      authConfig.MaxBufferAge,
+	}
+	if len(authConfig.BufferFilePath) != 0 {
+		return store.NewFileStore(authConfig.BufferFilePath, store.FileStoreOptions{
+			Policy: policy,
+		})
+	}
+	if authConfig.MaxBufferMessage > 0 {
+		return store.NewMemoryStore(policy), nil
+	}
+	return nil, nil
+}
+
 func checkAuthConfig(authConfig *config.ConnectAuthConfig) bool {
 	if authConfig == nil {
 		return false
 	}
 	if len(authConfig.Id) == 0 {
-		glog.Warning("device id is empty.")
+		logger.Default().Warn("device id is empty")
 		return false
 	}
 	if authConfig.AuthType == 0 && len(authConfig.Secret) == 0 {
-		glog.Warning("password is empty when auth type is password.")
+		authConfig.GetLogger().Warn("password is empty when auth type is password")
 		return false
 	}
 	if len(authConfig.Servers) == 0 {
-		glog.Warning("params server is empty.")
+		authConfig.GetLogger().Warn("params server is empty")
 		return false
 	}
 	if authConfig.ConnectTimeOut == 0 {
 		authConfig.ConnectTimeOut = 30 * time.Second
 	}
+	if authConfig.PublishTimeOut == 0 {
+		authConfig.PublishTimeOut = 30 * time.Second
+	}
+	if authConfig.RequestTimeOut == 0 {
+		authConfig.RequestTimeOut = 30 * time.Second
+	}
 	if authConfig.AutoReconnect == nil {
 		var b = true
 		authConfig.AutoReconnect = &b
@@ -132,24 +218,41 @@ func (mqttDevice *MqttDevice) Connect() bool {
 	return mqttDevice.Client.Connect()
 }
 
+// ConnectWithContext 与平台建链，返回建链失败的具体原因
+func (mqttDevice *MqttDevice) ConnectWithContext(ctx context.Context) error {
+	return mqttDevice.Client.ConnectWithContext(ctx)
+}
+
 func (mqttDevice *MqttDevice) SendMessage(message model.Message) bool {
+	return mqttDevice.logError("send message", mqttDevice.SendMessageWithContext(context.Background(), message))
+}
+
+func (mqttDevice *MqttDevice) SendMessageWithContext(ctx context.Context, message model.Message) error {
 	topic := message.Topic
 	if topic == "" {
 		topic = constants.MessageUpTopic
 	}
-	return mqttDevice.Client.PublishMessage(iot.FormatTopic(topic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, message.Payload)
+	return mqttDevice.Client.PublishMessageWithContext(ctx, iot.FormatTopic(topic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, message.Payload)
 }
 
 func (mqttDevice *MqttDevice) ReportProperties(properties model.DeviceProperties) bool {
-	propertiesData := iot.Interface2JsonString(properties)
-	result := mqttDevice.Client.PublishMessage(iot.FormatTopic(constants.PropertiesUpTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, propertiesData)
-	if result && mqttDevice.ConnectionAuthInfo.RuleEnable {
+	return mqttDevice.logError("report properties", mqttDevice.ReportPropertiesWithContext(context.Background(), properties))
+}
+
+func (mqttDevice *MqttDevice) ReportPropertiesWithContext(ctx context.Context, properties model.DeviceProperties) error {
+	err := mqttDevice.Client.PublishObjectWithContext(ctx, iot.FormatTopic(constants.PropertiesUpTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, properties)
+	// 端侧规则在本地执行，上报失败或离线时也需要判断规则
+	if mqttDevice.ConnectionAuthInfo.RuleEnable {
 		mqttDevice.Client.RuleManageService.HandleRule(properties.Services, mqttDevice.Client.CreateRuleActionHandler())
 	}
-	return result
+	return err
 }
 
 func (mqttDevice *MqttDevice) BatchReportSubDevicesProperties(service model.DevicesService) bool {
+	return mqttDevice.logError("batch report sub devices properties", mqttDevice.BatchReportSubDevicesPropertiesWithContext(context.Background(), service))
+}
+
+func (mqttDevice *MqttDevice) BatchReportSubDevicesPropertiesWithContext(ctx context.Context, service model.DevicesService) error {
 	subDeviceCounts := len(service.Devices)
 
 	batchReportSubDeviceProperties := 0
@@ -169,71 +272,142 @@ func (mqttDevice *MqttDevice) BatchReportSubDevicesProperties(service model.Devi
 		sds := model.DevicesService{
 			Devices: service.Devices[begin:end],
 		}
-		return mqttDevice.Client.PublishMessage(iot.FormatTopic(constants.GatewayBatchReportSubDeviceTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(sds))
+		err := mqttDevice.Client.PublishObjectWithContext(ctx, iot.FormatTopic(constants.GatewayBatchReportSubDeviceTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, sds)
+		if err != nil {
+			return err
+		}
 	}
 
-	return true
+	return nil
 }
 
 func (mqttDevice *MqttDevice) QueryDeviceShadow(query model.DevicePropertyQueryRequest) {
-	requestId := uuid.NewV4()
-	message := mqttDevice.Client.PublishMessage(iot.FormatTopic(constants.DeviceShadowQueryRequestTopic, mqttDevice.ConnectionAuthInfo.Id)+requestId.String(), mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(query))
-	if message {
-		glog.Warningf("device %s query device shadow data failed,request id = %s", mqttDevice.ConnectionAuthInfo.Id, requestId)
+	mqttDevice.logError("query device shadow", mqttDevice.QueryDeviceShadowWithContext(context.Background(), query))
+}
+
+// QueryDeviceShadowWithContext 发送设备影子查询请求，平台的响应通过DeviceShadowQueryResponseHandler回调
+func (mqttDevice *MqttDevice) QueryDeviceShadowWithContext(ctx context.Context, query model.DevicePropertyQueryRequest) error {
+	return mqttDevice.queryDeviceShadow(ctx, uuid.NewV4().String(), query)
+}
+
+// QueryDeviceShadowSync 查询设备影子并等待平台响应，ctx未设置超时时间时使用RequestTimeOut
+func (mqttDevice *MqttDevice) QueryDeviceShadowSync(ctx context.Context, query model.DevicePropertyQueryRequest) (model.DeviceShadowQueryResponse, error) {
+	requestId := uuid.NewV4().String()
+	request := mqttDevice.Client.PendingRequests.Register(client.ShadowQueryKey(requestId))
+	defer request.Cancel()
+	if err := mqttDevice.queryDeviceShadow(ctx, requestId, query); err != nil {
+		return model.DeviceShadowQueryResponse{}, err
+	}
+	response, err := request.Wait(ctx, mqttDevice.ConnectionAuthInfo.RequestTimeOut)
+	if err != nil {
+		return model.DeviceShadowQueryResponse{}, err
 	}
+	return response.(model.DeviceShadowQueryResponse), nil
+}
+
+func (mqttDevice *MqttDevice) queryDeviceShadow(ctx context.Context, requestId string, query model.DevicePropertyQueryRequest) error {
+	return mqttDevice.Client.PublishObjectWithContext(ctx, iot.FormatTopic(constants.DeviceShadowQueryRequestTopic, mqttDevice.ConnectionAuthInfo.Id)+requestId, mqttDevice.ConnectionAuthInfo.Qos, query)
 }
 
 func (mqttDevice *MqttDevice) UploadFile(filename, filePath string) bool {
-	request := mqttDevice.generateUploadFileRequest(filename, filePath)
-	if request == nil {
-		return false
+	return mqttDevice.logError("upload file", mqttDevice.UploadFileWithContext(context.Background(), filename, filePath))
+}
+
+// UploadFileWithContext 上传文件，ctx未设置超时时间时等待平台下发上传URL的时间为RequestTimeOut
+func (mqttDevice *MqttDevice) UploadFileWithContext(ctx context.Context, filename, filePath string) error {
+	httpClient, err := mqttDevice.createHttpClient("upload file")
+	if err != nil {
+		return err
 	}
-	if !mqttDevice.Client.PublishMessage(iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(*request)) {
-		glog.Warningf("publish file upload request url failed")
-		return false
+	request, err := mqttDevice.generateUploadFileRequest(filename, filePath)
+	if err != nil {
+		return err
 	}
-	glog.Info("publish file upload request url success")
-
-	ticker := time.Tick(time.Second)
-	for {
-		select {
-		case <-ticker:
-			_, ok := mqttDevice.Client.FileUrls[filename+constants.FileActionUpload]
-			if ok {
-				glog.Infof("platform send file upload url success")
-				goto BreakPoint
-			}
+	transferCtx, transfer := mqttDevice.Client.FileTransfers.Start(ctx, filename, constants.FileActionUpload, mqttDevice.FileTransfer.Timeout)
+	defer transfer.Finish()
+	if err := mqttDevice.Client.PublishObjectWithContext(transferCtx, iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, *request); err != nil {
+		mqttDevice.Client.GetLogger().Warn("publish file upload request url failed", logger.String("file", filename), logger.Err(err))
+		return err
+	}
+	mqttDevice.Client.GetLogger().Info("publish file upload request url success", logger.String("file", filename), logger.String("transfer", transfer.Id))
 
-		}
+	uploadUrl, err := mqttDevice.waitFileUrl(transferCtx, transfer)
+	if err != nil {
+		return err
 	}
-BreakPoint:
+	mqttDevice.Client.GetLogger().Info("get file upload url success", logger.String("file", filename), logger.String("url", uploadUrl))
 
-	if len(mqttDevice.Client.FileUrls[filename+constants.FileActionUpload]) == 0 {
-		glog.Errorf("get file upload url failed")
-		return false
+	uploadErr := httpClient.UploadFileWithContext(transferCtx, filePath, uploadUrl, mqttDevice.FileTransfer)
+	// 传输被取消或超时后仍然使用调用方的ctx上报失败结果
+	response := file.CreateFileUploadDownLoadResultResponse(filename, constants.FileActionUpload, uploadErr == nil)
+	if err := mqttDevice.Client.PublishObjectWithContext(ctx, iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, response); err != nil {
+		mqttDevice.Client.GetLogger().Error("report file upload result failed", logger.String("file", filename), logger.Err(err))
+		return err
+	}
+	if uploadErr != nil {
+		mqttDevice.Client.GetLogger().Error("upload file failed", logger.String("file", filename), logger.Err(uploadErr))
+		return uploadErr
 	}
-	glog.Infof("file upload url is %s", mqttDevice.Client.FileUrls[filename+constants.FileActionUpload])
+	return nil
+}
 
-	uploadFlag := file.CreateHttpClient().UploadFile(filePath, mqttDevice.Client.FileUrls[filename+constants.FileActionUpload])
-	if !uploadFlag {
-		glog.Errorf("upload file failed")
-		return false
+// UploadArchive 将patterns匹配的文件或目录打包为一个压缩文件后上传，用于日志收集等场景
+func (mqttDevice *MqttDevice) UploadArchive(filename string, patterns []string, options file.ArchiveOptions) bool {
+	return mqttDevice.logError("upload archive", mqttDevice.UploadArchiveWithContext(context.Background(), filename, patterns, options))
+}
+
+// UploadArchiveWithContext 先在临时目录生成压缩文件，再按UploadFileWithContext的流程上传，完成后删除临时文件
+func (mqttDevice *MqttDevice) UploadArchiveWithContext(ctx context.Context, filename string, patterns []string, options file.ArchiveOptions) error {
+	archive, err := os.CreateTemp(options.TempDir, "iot-archive-*")
+	if err != nil {
+		return iot.NewDeviceError("upload archive", "", iot.ErrFileTransfer, err)
+	}
+	defer os.Remove(archive.Name())
+	result, err := file.CreateArchive(ctx, patterns, archive, options)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
 	}
+	if err != nil {
+		mqttDevice.Client.GetLogger().Error("create archive failed", logger.String("file", filename), logger.Err(err))
+		return iot.NewDeviceError("upload archive", "", iot.ErrFileTransfer, err)
+	}
+	if len(result.Skipped) > 0 {
+		mqttDevice.Client.GetLogger().Warn("archive size limit exceeded, skip older files", logger.String("file", filename),
+			logger.Any("skipped", result.Skipped))
+	}
+	mqttDevice.Client.GetLogger().Info("create archive success", logger.String("file", filename),
+		logger.Any("files", len(result.Files)), logger.Any("bytes", result.Bytes))
+	return mqttDevice.UploadFileWithContext(ctx, filename, archive.Name())
+}
 
-	response := file.CreateFileUploadDownLoadResultResponse(filename, constants.FileActionUpload, uploadFlag)
+// createHttpClient 按ConnectAuthConfig.TLS创建文件上传下载使用的客户端
+func (mqttDevice *MqttDevice) createHttpClient(op string) (file.HttpClient, error) {
+	httpClient, err := file.CreateHttpClientWithSettings(mqttDevice.ConnectionAuthInfo.TLS)
+	if err != nil {
+		mqttDevice.Client.GetLogger().Error("create http client failed", logger.Err(err))
+		return nil, iot.NewDeviceError(op, "", iot.ErrTLSLoad, err)
+	}
+	return httpClient, nil
+}
 
-	if !mqttDevice.Client.PublishMessage(iot.FormatTopic(constants.PlatformEventToDeviceTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(response)) {
-		glog.Error("report file upload file result failed")
-		return false
+// waitFileUrl 等待平台下发文件上传或下载的URL
+func (mqttDevice *MqttDevice) waitFileUrl(ctx context.Context, transfer *client.FileTransfer) (string, error) {
+	url, err := transfer.WaitUrl(ctx, mqttDevice.ConnectionAuthInfo.RequestTimeOut)
+	if err != nil {
+		mqttDevice.Client.GetLogger().Error("wait file url failed", logger.String("action", transfer.Action),
+			logger.String("file", transfer.FileName), logger.String("transfer", transfer.Id), logger.Err(err))
+		return "", err
 	}
-	return true
+	mqttDevice.Client.GetLogger().Info("platform send file url success", logger.String("action", transfer.Action),
+		logger.String("transfer", transfer.Id))
+	return url, nil
 }
 
-func (mqttDevice *MqttDevice) generateUploadFileRequest(filename, filePath string) *model.FileRequest {
+func (mqttDevice *MqttDevice) generateUploadFileRequest(filename, filePath string) (*model.FileRequest, error) {
 	length, fromFile, err := iot.Sha256FromFile(filePath)
 	if err != nil {
-		glog.Warningf("sha256 file err. %s", err.Error())
-		return nil
+		mqttDevice.Client.GetLogger().Warn("sha256 file failed", logger.String("path", filePath), logger.Err(err))
+		return nil, iot.NewDeviceError("upload file", "", iot.ErrInvalidParams, err)
 	}
 	fileAttributes := make(map[string]interface{})
 	fileAttributes["hash_code"] = fromFile
@@ -256,10 +430,19 @@ func (mqttDevice *MqttDevice) generateUploadFileRequest(filename, filePath strin
 	request := &model.FileRequest{
 		Services: services,
 	}
-	return request
+	return request, nil
 }
 
 func (mqttDevice *MqttDevice) DownloadFile(filename, filePath string) bool {
+	return mqttDevice.logError("download file", mqttDevice.DownloadFileWithContext(context.Background(), filename, filePath))
+}
+
+// DownloadFileWithContext 下载文件，ctx未设置超时时间时等待平台下发下载URL的时间为RequestTimeOut
+func (mqttDevice *MqttDevice) DownloadFileWithContext(ctx context.Context, filename, filePath string) error {
+	httpClient, err := mqttDevice.createHttpClient("download file")
+	if err != nil {
+		return err
+	}
 	// 构造获取文件上传URL的请求
 	requestParas := model.FileRequestServiceEventParas{
 		FileName: filename,
@@ -277,47 +460,37 @@ func (mqttDevice *MqttDevice) DownloadFile(filename, filePath string) bool {
 	request := model.FileRequest{
 		Services: services,
 	}
-	if !mqttDevice.Client.PublishMessage(iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(request)) {
-		glog.Warningf("publish file download request url failed")
-		return false
-	}
-
-	ticker := time.Tick(time.Second)
-	for {
-		select {
-		case <-ticker:
-			_, ok := mqttDevice.Client.FileUrls[filename+constants.FileActionDownload]
-			if ok {
-				glog.Infof("platform send file upload url success")
-				goto BreakPoint
-			}
-
-		}
+	transferCtx, transfer := mqttDevice.Client.FileTransfers.Start(ctx, filename, constants.FileActionDownload, mqttDevice.FileTransfer.Timeout)
+	defer transfer.Finish()
+	if err := mqttDevice.Client.PublishObjectWithContext(transferCtx, iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, request); err != nil {
+		mqttDevice.Client.GetLogger().Warn("publish file download request url failed", logger.String("file", filename), logger.Err(err))
+		return err
 	}
-BreakPoint:
 
-	if len(mqttDevice.Client.FileUrls[filename+constants.FileActionDownload]) == 0 {
-		glog.Errorf("get file download url failed")
-		return false
+	downloadUrl, err := mqttDevice.waitFileUrl(transferCtx, transfer)
+	if err != nil {
+		return err
 	}
 
-	downloadFlag := file.CreateHttpClient().DownloadFile(filePath, mqttDevice.Client.FileUrls[filename+constants.FileActionDownload], "")
-	if !downloadFlag {
-		glog.Errorf("down load file { %s } failed", filename)
-		return false
+	downloadErr := httpClient.DownloadFileWithContext(transferCtx, filePath, downloadUrl, "", mqttDevice.FileTransfer)
+	response := file.CreateFileUploadDownLoadResultResponse(filename, constants.FileActionDownload, downloadErr == nil)
+	if err := mqttDevice.Client.PublishObjectWithContext(ctx, iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, response); err != nil {
+		mqttDevice.Client.GetLogger().Error("report file download result failed", logger.String("file", filename), logger.Err(err))
+		return err
 	}
-
-	response := file.CreateFileUploadDownLoadResultResponse(filename, constants.FileActionDownload, downloadFlag)
-
-	if !mqttDevice.Client.PublishMessage(iot.FormatTopic(constants.PlatformEventToDeviceTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(response)) {
-		glog.Error("report file download file result failed")
-		return false
+	if downloadErr != nil {
+		mqttDevice.Client.GetLogger().Error("download file failed", logger.String("file", filename), logger.Err(downloadErr))
+		return downloadErr
 	}
 
-	return true
+	return nil
 }
 
 func (mqttDevice *MqttDevice) ReportDeviceInfo(swVersion, fwVersion string) {
+	mqttDevice.logError("report device info", mqttDevice.ReportDeviceInfoWithContext(context.Background(), swVersion, fwVersion))
+}
+
+func (mqttDevice *MqttDevice) ReportDeviceInfoWithContext(ctx context.Context, swVersion, fwVersion string) error {
 	event := model.ReportDeviceInfoServiceEvent{
 		BaseServiceEvent: model.BaseServiceEvent{
 			ServiceId: "$sdk_info",
@@ -336,12 +509,14 @@ func (mqttDevice *MqttDevice) ReportDeviceInfo(swVersion, fwVersion string) {
 		Services:       []model.ReportDeviceInfoServiceEvent{event},
 	}
 
-	if !mqttDevice.Client.PublishMessage(iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(request)) {
-		glog.Error("report device info failed.")
-	}
+	return mqttDevice.Client.PublishObjectWithContext(ctx, iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id), mqttDevice.ConnectionAuthInfo.Qos, request)
 }
 
 func (mqttDevice *MqttDevice) ReportLogs(logs []model.DeviceLogEntry) bool {
+	return mqttDevice.logError("report logs", mqttDevice.ReportLogsWithContext(context.Background(), logs))
+}
+
+func (mqttDevice *MqttDevice) ReportLogsWithContext(ctx context.Context, logs []model.DeviceLogEntry) error {
 	var services []model.ReportDeviceLogServiceEvent
 
 	for _, logEntry := range logs {
@@ -361,26 +536,45 @@ func (mqttDevice *MqttDevice) ReportLogs(logs []model.DeviceLogEntry) bool {
 		Services: services,
 	}
 
-	glog.Infof("report log request is : %s", iot.Interface2JsonString(request))
+	mqttDevice.Client.GetLogger().Debug("report logs", logger.String("payload", iot.Interface2JsonString(request)))
 
 	topic := iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id)
+	return mqttDevice.Client.PublishObjectWithContext(ctx, topic, mqttDevice.ConnectionAuthInfo.Qos, request)
+}
+
+func (mqttDevice *MqttDevice) RequestTimeSync() bool {
+	return mqttDevice.logError("request time sync", mqttDevice.RequestTimeSyncWithContext(context.Background()))
+}
 
-	mqttDevice.Client.PublishMessage(topic, mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(request))
+// RequestTimeSyncWithContext 发送时间同步请求，平台的响应通过SyncTimeResponseHandler回调
+func (mqttDevice *MqttDevice) RequestTimeSyncWithContext(ctx context.Context) error {
+	return mqttDevice.requestTimeSync(ctx, currentTimeMillis())
+}
 
-	if !mqttDevice.Client.PublishMessage(topic, mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(request)) {
-		glog.Errorf("device %s report log failed", mqttDevice.ConnectionAuthInfo.Id)
-		return false
+// RequestTimeSyncSync 发送时间同步请求并等待平台响应，返回平台时间与设备本地时间的差值
+func (mqttDevice *MqttDevice) RequestTimeSyncSync(ctx context.Context) (time.Duration, error) {
+	deviceSendTime := currentTimeMillis()
+	request := mqttDevice.Client.PendingRequests.Register(client.TimeSyncKey(deviceSendTime))
+	defer request.Cancel()
+	if err := mqttDevice.requestTimeSync(ctx, deviceSendTime); err != nil {
+		return 0, err
 	}
-	return true
-
+	response, err := request.Wait(ctx, mqttDevice.ConnectionAuthInfo.RequestTimeOut)
+	if err != nil {
+		return 0, err
+	}
+	deviceRecvTime := currentTimeMillis()
+	timeSyncResponse := response.(model.TimeSyncResponse)
+	// 平台时间 = (平台接收时间 + 平台发送时间 + 设备接收时间 - 设备发送时间) / 2
+	offset := (timeSyncResponse.ServerRecvTime - deviceSendTime + timeSyncResponse.ServerSendTime - deviceRecvTime) / 2
+	return time.Duration(offset) * time.Millisecond, nil
 }
 
-func (mqttDevice *MqttDevice) RequestTimeSync() bool {
+func (mqttDevice *MqttDevice) requestTimeSync(ctx context.Context, deviceSendTime int64) error {
 	var services []model.TimeSyncRequestServiceEvent
 
-	timestamp := time.Now().Unix()
 	para := model.TimeSyncRequestServiceEventParas{
-		DeviceSendTime: timestamp,
+		DeviceSendTime: deviceSendTime,
 	}
 	timeEvent := model.TimeSyncRequestServiceEvent{
 		Paras: para,
@@ -395,12 +589,7 @@ func (mqttDevice *MqttDevice) RequestTimeSync() bool {
 	}
 
 	topic := iot.FormatTopic(constants.DeviceToPlatformTopic, mqttDevice.ConnectionAuthInfo.Id)
-
-	if !mqttDevice.Client.PublishMessage(topic, mqttDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(request)) {
-		glog.Errorf("device %s report log failed", mqttDevice.ConnectionAuthInfo.Id)
-		return false
-	}
-	return true
+	return mqttDevice.Client.PublishObjectWithContext(ctx, topic, mqttDevice.ConnectionAuthInfo.Qos, request)
 }
 
 func (mqttDevice *MqttDevice) DisConnect(timeout uint) {
@@ -410,3 +599,26 @@ func (mqttDevice *MqttDevice) DisConnect(timeout uint) {
 func (mqttDevice *MqttDevice) IsConnected() bool {
 	return mqttDevice.Client.IsConnect()
 }
+
+// State 当前的连接状态
+func (mqttDevice *MqttDevice) State() client.ConnectionState {
+	return mqttDevice.Client.State()
+}
+
+// SubscribeStateEvents 订阅连接状态变化事件，见MqttDeviceClient.SubscribeStateEvents
+func (mqttDevice *MqttDevice) SubscribeStateEvents(buffer int) (<-chan client.StateEvent, func()) {
+	return mqttDevice.Client.SubscribeStateEvents(buffer)
+}
+
+func currentTimeMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// logError 兼容返回bool的接口，记录错误原因
+func (mqttDevice *MqttDevice) logError(op string, err error) bool {
+	if err != nil {
+		mqttDevice.Client.GetLogger().Warn(op+" failed", logger.Err(err))
+		return false
+	}
+	return true
+}
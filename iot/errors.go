@@ -0,0 +1,100 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package iot
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 设备侧操作失败的错误类型，可通过errors.Is判断
+var (
+	// ErrNotConnected 设备未与平台建链
+	ErrNotConnected = errors.New("device is not connected")
+
+	// ErrPublishTimeout 发布消息或等待平台响应超时
+	ErrPublishTimeout = errors.New("publish timeout")
+
+	// ErrResponseTimeout 等待平台响应超时
+	ErrResponseTimeout = errors.New("wait for platform response timeout")
+
+	// ErrBrokerRejected 平台拒绝了设备的建链或发布请求
+	ErrBrokerRejected = errors.New("broker rejected")
+
+	// ErrSerialization 消息序列化或反序列化失败
+	ErrSerialization = errors.New("serialization failed")
+
+	// ErrTLSLoad 加载证书或TLS配置失败
+	ErrTLSLoad = errors.New("load tls config failed")
+
+	// ErrFileTransfer 文件上传或下载失败
+	ErrFileTransfer = errors.New("file transfer failed")
+
+	// ErrInvalidParams 调用参数不合法
+	ErrInvalidParams = errors.New("invalid params")
+
+	// ErrClosed 设备已关闭
+	ErrClosed = errors.New("device is closed")
+)
+
+// DeviceError 设备操作错误，Kind为上面定义的错误类型，Err为原始错误
+type DeviceError struct {
+	Op    string
+	Topic string
+	Kind  error
+	Err   error
+}
+
+func (e *DeviceError) Error() string {
+	msg := e.Op + ": " + e.Kind.Error()
+	if len(e.Topic) != 0 {
+		msg = fmt.Sprintf("%s, topic: %s", msg, e.Topic)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s, err: %s", msg, e.Err.Error())
+	}
+	return msg
+}
+
+func (e *DeviceError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DeviceError) Is(target error) bool {
+	return e.Kind == target
+}
+
+func NewDeviceError(op, topic string, kind, err error) error {
+	return &DeviceError{
+		Op:    op,
+		Topic: topic,
+		Kind:  kind,
+		Err:   err,
+	}
+}
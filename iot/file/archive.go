@@ -0,0 +1,306 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 归档格式
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// 轮转后的日志文件后缀，如app.log.1、app.log.2.gz、app.log-20240101
+var rotatedSuffix = regexp.MustCompile(`^(\.\d+|-\d{8,14})(\.gz|\.zip|\.bz2|\.xz|\.zst)?$`)
+
+// ArchiveOptions 打包上传目录或通配符匹配的文件
+type ArchiveOptions struct {
+	Format         string // ArchiveTarGz或ArchiveZip，默认ArchiveTarGz
+	MaxBytes       int64  // 打包文件的原始大小上限，超出时优先保留最近修改的文件，0表示不限制
+	IncludeRotated bool   // 同时打包匹配文件轮转后的文件，如app.log.1、app.log.2.gz
+	TempDir        string // 生成归档文件的临时目录，默认为系统临时目录
+}
+
+// ArchiveResult 打包结果
+type ArchiveResult struct {
+	Files   []string // 已打包的文件
+	Skipped []string // 超出MaxBytes未打包的文件
+	Bytes   int64    // 已打包文件的原始大小
+}
+
+// archiveEntry 待打包的文件
+type archiveEntry struct {
+	path string
+	info os.FileInfo
+}
+
+// CreateArchive 将patterns匹配的文件打包写入w，pattern可以是文件、目录或通配符，目录会递归打包。
+// 归档中的文件名为去掉根目录的完整路径，如var/log/app/app.log
+func CreateArchive(ctx context.Context, patterns []string, w io.Writer, options ArchiveOptions) (ArchiveResult, error) {
+	entries, err := collectFiles(patterns, options.IncludeRotated)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	if len(entries) == 0 {
+		return ArchiveResult{}, fmt.Errorf("no file matches %v", patterns)
+	}
+	result := ArchiveResult{}
+	// 超出大小上限时优先保留最近修改的文件
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].info.ModTime().After(entries[j].info.ModTime())
+	})
+	var selected []archiveEntry
+	for _, entry := range entries {
+		if options.MaxBytes > 0 && result.Bytes+entry.info.Size() > options.MaxBytes {
+			result.Skipped = append(result.Skipped, entry.path)
+			continue
+		}
+		result.Bytes += entry.info.Size()
+		selected = append(selected, entry)
+	}
+
+	var writer archiveWriter
+	switch options.Format {
+	case "", ArchiveTarGz:
+		writer = newTarGzWriter(w)
+	case ArchiveZip:
+		writer = &zipWriter{writer: zip.NewWriter(w)}
+	default:
+		return ArchiveResult{}, fmt.Errorf("unsupported archive format %s", options.Format)
+	}
+	for _, entry := range selected {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		added, err := addFile(writer, entry)
+		if err != nil {
+			return result, err
+		}
+		if added {
+			result.Files = append(result.Files, entry.path)
+		}
+	}
+	return result, writer.Close()
+}
+
+// addFile 先打开文件再获取大小，文件在打包期间被重命名轮转时仍然读取原文件；
+// 被截断时剩余部分补0，保证归档格式正确
+func addFile(writer archiveWriter, entry archiveEntry) (bool, error) {
+	file, err := os.Open(entry.path)
+	if err != nil {
+		// 打包前文件已经被轮转删除
+		if os.IsNotExist(err) {
+			logger.Default().Warn("file removed before archive", logger.String("file", entry.path))
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	target, err := writer.Create(archiveName(entry.path), info)
+	if err != nil {
+		return false, err
+	}
+	written, err := io.Copy(target, io.LimitReader(file, info.Size()))
+	if err != nil {
+		return false, err
+	}
+	if written < info.Size() {
+		logger.Default().Warn("file truncated during archive", logger.String("file", entry.path),
+			logger.Any("expect", info.Size()), logger.Any("actual", written))
+		if _, err := io.CopyN(target, zeroReader{}, info.Size()-written); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// collectFiles 展开通配符和目录，去重后返回普通文件
+func collectFiles(patterns []string, includeRotated bool) ([]archiveEntry, error) {
+	seen := make(map[string]bool)
+	var entries []archiveEntry
+	add := func(path string, info os.FileInfo) {
+		if !info.Mode().IsRegular() {
+			return
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		entries = append(entries, archiveEntry{path: path, info: info})
+	}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		if includeRotated {
+			matches = append(matches, rotatedFiles(matches)...)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				continue
+			}
+			if !info.IsDir() {
+				add(match, info)
+				continue
+			}
+			err = filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					// 遍历期间文件被删除
+					if os.IsNotExist(err) {
+						return nil
+					}
+					return err
+				}
+				add(path, info)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
+
+// rotatedFiles 查找文件轮转后生成的文件
+func rotatedFiles(files []string) []string {
+	var rotated []string
+	for _, file := range files {
+		candidates, err := filepath.Glob(globEscape(file) + "*")
+		if err != nil {
+			continue
+		}
+		for _, candidate := range candidates {
+			if rotatedSuffix.MatchString(strings.TrimPrefix(candidate, file)) {
+				rotated = append(rotated, candidate)
+			}
+		}
+	}
+	return rotated
+}
+
+func globEscape(path string) string {
+	replacer := strings.NewReplacer("*", `\*`, "?", `\?`, "[", `\[`)
+	if os.PathSeparator == '\\' {
+		// windows下反斜杠是路径分隔符，不能转义
+		replacer = strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]")
+	}
+	return replacer.Replace(path)
+}
+
+// archiveName 去掉盘符和根目录
+func archiveName(path string) string {
+	path = strings.TrimPrefix(path, filepath.VolumeName(path))
+	return strings.TrimLeft(filepath.ToSlash(path), "/")
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// archiveWriter 屏蔽tar.gz和zip的差异
+type archiveWriter interface {
+	Create(name string, info os.FileInfo) (io.Writer, error)
+	Close() error
+}
+
+type tarGzWriter struct {
+	gzip *gzip.Writer
+	tar  *tar.Writer
+}
+
+func newTarGzWriter(w io.Writer) *tarGzWriter {
+	gzipWriter := gzip.NewWriter(w)
+	return &tarGzWriter{gzip: gzipWriter, tar: tar.NewWriter(gzipWriter)}
+}
+
+func (w *tarGzWriter) Create(name string, info os.FileInfo) (io.Writer, error) {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	if err := w.tar.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	return w.tar, nil
+}
+
+func (w *tarGzWriter) Close() error {
+	if err := w.tar.Close(); err != nil {
+		w.gzip.Close()
+		return err
+	}
+	return w.gzip.Close()
+}
+
+type zipWriter struct {
+	writer *zip.Writer
+}
+
+func (w *zipWriter) Create(name string, info os.FileInfo) (io.Writer, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	return w.writer.CreateHeader(header)
+}
+
+func (w *zipWriter) Close() error {
+	return w.writer.Close()
+}
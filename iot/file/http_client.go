@@ -29,14 +29,14 @@
 package file
 
 import (
-	"bytes"
-	"crypto/tls"
-	"github.com/golang/glog"
+	"context"
+	"fmt"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
-	"io/fs"
-	"io/ioutil"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/tlsconfig"
+	"io"
 	"net/http"
-	"net/url"
+	"os"
 	"strings"
 )
 
@@ -45,6 +45,10 @@ type HttpClient interface {
 	UploadFile(filename, uri string) bool
 	DownloadFile(filename, uri, token string) bool
 	OTADownloadFile(upgradeType byte, filename, uri, token string) bool
+	// UploadFileWithContext 流式上传文件，失败时按options重试
+	UploadFileWithContext(ctx context.Context, filename, uri string, options TransferOptions) error
+	// DownloadFileWithContext 流式下载文件，先写入filename.part，中断后从已下载的位置通过Range续传，完成后重命名为filename
+	DownloadFileWithContext(ctx context.Context, filename, uri, token string, options TransferOptions) error
 }
 
 type httpClient struct {
@@ -52,100 +56,306 @@ type httpClient struct {
 }
 
 func (client *httpClient) OTADownloadFile(upgradeType byte, fileName, downloadUrl, token string) bool {
-	glog.Infof("begin to download file %s, url = %s", fileName, downloadUrl)
-	fileName = iot.SmartFileName(fileName)
+	logger.Default().Info("begin to download file", logger.String("file", fileName), logger.String("url", downloadUrl))
+	header := http.Header{}
+	if upgradeType < 2 {
+		header.Set("Content-Type", "text/plain")
+		if len(token) != 0 {
+			header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	if err := client.download(context.Background(), fileName, downloadUrl, header, TransferOptions{}); err != nil {
+		logger.Default().Error("download file failed", logger.String("file", fileName), logger.Err(err))
+		return false
+	}
+	return true
+}
 
-	originalUri, err := url.ParseRequestURI(downloadUrl)
-	if err != nil {
-		glog.Errorf("parse request uri failed %v", err)
+func (client *httpClient) DownloadFile(fileName, downloadUrl, token string) bool {
+	return client.OTADownloadFile(0, fileName, downloadUrl, token)
+}
+
+func (client *httpClient) DownloadFileWithContext(ctx context.Context, fileName, downloadUrl, token string, options TransferOptions) error {
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
+	if len(token) != 0 {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return client.download(ctx, fileName, downloadUrl, header, options)
+}
+
+func (client *httpClient) UploadFile(filename, uri string) bool {
+	if err := client.UploadFileWithContext(context.Background(), filename, uri, TransferOptions{}); err != nil {
+		logger.Default().Error("upload file failed", logger.String("file", filename), logger.Err(err))
 		return false
 	}
-	if strings.Contains(downloadUrl, "https") {
-		client.client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+	return true
+}
+
+func (client *httpClient) UploadFileWithContext(ctx context.Context, filename, uri string, options TransferOptions) error {
+	options = options.withDefaults()
+	filename = iot.SmartFileName(filename)
+	for attempt := 0; ; attempt++ {
+		err := client.uploadOnce(ctx, filename, uri, options)
+		if err == nil {
+			return nil
+		}
+		if attempt >= options.MaxRetries || !retryable(ctx, err) {
+			return iot.NewDeviceError("upload file", "", iot.ErrFileTransfer, err)
+		}
+		wait := options.backoff(attempt + 1)
+		logger.Default().Warn("upload file failed, retry later", logger.String("file", filename),
+			logger.Int("attempt", attempt+1), logger.Any("wait", wait), logger.Err(err))
+		if err := sleep(ctx, wait); err != nil {
+			return iot.NewDeviceError("upload file", "", iot.ErrFileTransfer, err)
 		}
 	}
-	request, err := http.NewRequest("GET", downloadUrl, nil)
+}
 
+// uploadOnce 签名URL只支持整体PUT，每次重试都从头上传
+func (client *httpClient) uploadOnce(ctx context.Context, filename, uri string, options TransferOptions) error {
+	file, err := os.Open(filename)
 	if err != nil {
-		glog.Errorf("download file request failed %v", err)
-		return false
+		return err
 	}
-	if upgradeType < 2 {
-		request.Header.Set("Content-Type", "text/plain")
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
 	}
-	request.Header.Set("Host", originalUri.Host)
-	if len(token) != 0 && upgradeType < 2 {
-		request.Header.Set("Authorization", "Bearer "+token)
+	var body io.Reader = http.NoBody
+	if info.Size() > 0 {
+		body = &transferReader{
+			ctx:      ctx,
+			reader:   file,
+			limiter:  newRateLimiter(options.RateLimit),
+			total:    info.Size(),
+			progress: options.Progress,
+		}
 	}
-	resp, err := client.client.Do(request)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, body)
 	if err != nil {
-		glog.Errorf("download file request failed %v", err)
-		return false
+		return err
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	request.ContentLength = info.Size()
+	request.Header.Set("Content-Type", "text/plain")
+	resp, err := client.client.Do(request)
 	if err != nil {
-		glog.Errorf("download file request failed %v", err)
-		return false
+		return err
 	}
-	err = ioutil.WriteFile(fileName, body, fs.ModePerm)
-	if err != nil {
-		glog.Errorf("write file failed")
-		return false
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{code: resp.StatusCode, status: resp.Status}
 	}
-
-	return true
+	return nil
 }
 
-func (client *httpClient) DownloadFile(fileName, downloadUrl, token string) bool {
-	return client.OTADownloadFile(0, fileName, downloadUrl, token)
+// downloadState 一次下载的进度，多次Range请求共用
+type downloadState struct {
+	file    *os.File
+	offset  int64
+	total   int64
+	limiter *rateLimiter
+	// validator 服务端返回的ETag或Last-Modified，续传时通过If-Range保证文件未变化
+	validator     string
+	validatorName string
 }
 
-func (client *httpClient) UploadFile(filename, uri string) bool {
-	filename = iot.SmartFileName(filename)
-	fileBytes, err := ioutil.ReadFile(filename)
-
+func (client *httpClient) download(ctx context.Context, fileName, downloadUrl string, header http.Header, options TransferOptions) error {
+	options = options.withDefaults()
+	fileName = iot.SmartFileName(fileName)
+	partName := fileName + ".part"
+	err := client.downloadToPart(ctx, partName, downloadUrl, header, options)
+	if err == nil {
+		err = os.Rename(partName, fileName)
+		_ = os.Remove(partName + ".validator")
+	} else if !retryable(ctx, err) && ctx.Err() == nil {
+		// 不可恢复的失败删除已下载的部分，网络错误和ctx取消时保留用于下次续传
+		_ = os.Remove(partName)
+		_ = os.Remove(partName + ".validator")
+	}
 	if err != nil {
-		glog.Errorf("read file failed %v", err)
-		return false
+		return iot.NewDeviceError("download file", "", iot.ErrFileTransfer, err)
 	}
+	return nil
+}
 
-	originalUri, err := url.ParseRequestURI(uri)
+func (client *httpClient) downloadToPart(ctx context.Context, partName, downloadUrl string, header http.Header, options TransferOptions) error {
+	file, err := os.OpenFile(partName, os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
-		glog.Errorf("parse request uri failed %v", err)
-		return false
+		return err
 	}
-
-	request, err := http.NewRequest("PUT", uri, bytes.NewBuffer(fileBytes))
-
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
 	if err != nil {
-		glog.Errorf("upload file request failed %v", err)
-		return false
+		return err
 	}
+	state := &downloadState{file: file, offset: offset, total: -1, limiter: newRateLimiter(options.RateLimit),
+		validatorName: partName + ".validator"}
+	if offset > 0 {
+		if validator, err := os.ReadFile(state.validatorName); err == nil {
+			state.validator = string(validator)
+		}
+		logger.Default().Info("resume download", logger.String("file", partName), logger.Any("offset", offset))
+	}
+	failures := 0
+	for {
+		before := state.offset
+		done, err := client.downloadRange(ctx, downloadUrl, header, state, options)
+		if err == nil {
+			failures = 0
+			if done {
+				return file.Close()
+			}
+			continue
+		}
+		// 有数据下载成功时重新计算重试次数
+		if state.offset > before {
+			failures = 0
+		}
+		if failures >= options.MaxRetries || !retryable(ctx, err) {
+			return err
+		}
+		failures++
+		wait := options.backoff(failures)
+		logger.Default().Warn("download file failed, retry later", logger.String("file", partName),
+			logger.Any("offset", state.offset), logger.Int("attempt", failures), logger.Any("wait", wait), logger.Err(err))
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
 
-	request.Header.Set("Content-Type", "text/plain")
-	request.Header.Set("Host", originalUri.Host)
-
+// downloadRange 从state.offset开始下载一段，返回是否已下载完成
+func (client *httpClient) downloadRange(ctx context.Context, downloadUrl string, header http.Header, state *downloadState, options TransferOptions) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadUrl, nil)
 	if err != nil {
-		glog.Errorf("upload request failed %v", err)
+		return false, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	end := int64(-1)
+	if options.ChunkSize > 0 {
+		end = state.offset + options.ChunkSize - 1
+		if state.total >= 0 && end >= state.total {
+			end = state.total - 1
+		}
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", state.offset, end))
+	} else if state.offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", state.offset))
+	}
+	if state.offset > 0 && state.validator != "" {
+		// 文件在服务端发生变化时返回200和完整文件
+		request.Header.Set("If-Range", state.validator)
 	}
 	resp, err := client.client.Do(request)
 	if err != nil {
-		glog.Errorf("download file request failed %v", err)
-		return false
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// 服务端不支持Range或文件已经变化，从头下载
+		if state.offset > 0 {
+			logger.Default().Info("server returned full content, restart download", logger.Any("offset", state.offset))
+			if err := state.file.Truncate(0); err != nil {
+				return false, err
+			}
+			if _, err := state.file.Seek(0, io.SeekStart); err != nil {
+				return false, err
+			}
+			state.offset = 0
+		}
+		state.total = resp.ContentLength
+		if err := state.saveValidator(resp.Header); err != nil {
+			return false, err
+		}
+		return true, state.copy(ctx, resp.Body, options)
+	case http.StatusPartialContent:
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return false, err
+		}
+		if start != state.offset {
+			return false, fmt.Errorf("unexpected range start %d, expect %d", start, state.offset)
+		}
+		state.total = total
+		if state.validator == "" {
+			if err := state.saveValidator(resp.Header); err != nil {
+				return false, err
+			}
+		}
+		if err := state.copy(ctx, resp.Body, options); err != nil {
+			return false, err
+		}
+		if state.total >= 0 {
+			return state.offset >= state.total, nil
+		}
+		// 文件大小未知时，返回的数据少于请求的长度说明已经下载完成
+		return end < 0 || state.offset <= end, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 之前已经下载完整
+		if _, total, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && total == state.offset {
+			return true, nil
+		}
 	}
-	return resp.StatusCode == 200
+	return false, &statusError{code: resp.StatusCode, status: resp.Status}
 }
 
-func CreateHttpClient() HttpClient {
-	client := &http.Client{}
+// saveValidator 保存响应的ETag，没有强ETag时使用Last-Modified，用于之后的If-Range
+func (state *downloadState) saveValidator(header http.Header) error {
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == state.validator {
+		return nil
+	}
+	state.validator = validator
+	if validator == "" {
+		if err := os.Remove(state.validatorName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(state.validatorName, []byte(validator), 0o644)
+}
 
-	httpClient := &httpClient{
-		client: client,
+func (state *downloadState) copy(ctx context.Context, body io.Reader, options TransferOptions) error {
+	reader := &transferReader{
+		ctx:         ctx,
+		reader:      body,
+		limiter:     state.limiter,
+		transferred: state.offset,
+		total:       state.total,
+		progress:    options.Progress,
 	}
+	n, err := io.Copy(state.file, reader)
+	state.offset += n
+	return err
+}
 
+// DownloadFileWithHeader 使用client流式下载文件，与DownloadFileWithContext一样支持Range续传和重试，
+// 每次请求携带header，用于OTA等需要自定义请求头的下载
+func DownloadFileWithHeader(ctx context.Context, client *http.Client, fileName, uri string, header http.Header, options TransferOptions) error {
+	return (&httpClient{client: client}).download(ctx, fileName, uri, header, options)
+}
+
+// CreateHttpClient 使用默认配置，按系统根证书校验服务端证书和主机名
+func CreateHttpClient() HttpClient {
+	// 默认配置不加载证书文件，不会失败
+	httpClient, _ := CreateHttpClientWithSettings(tlsconfig.Settings{})
 	return httpClient
+}
 
+// CreateHttpClientWithSettings 按settings校验服务端证书、使用代理
+func CreateHttpClientWithSettings(settings tlsconfig.Settings) (HttpClient, error) {
+	client, err := settings.HttpClient()
+	if err != nil {
+		return nil, err
+	}
+	return &httpClient{client: client}, nil
 }
@@ -0,0 +1,174 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/callback"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/tlsconfig"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ReceiverOptions 接收平台主动下发文件的配置
+type ReceiverOptions struct {
+	Dir        string                       // 文件保存目录，默认为系统临时目录下的iot-files
+	Handler    callback.FileReceivedHandler // 文件下载并校验完成后回调
+	HttpClient HttpClient                   // 下载文件的客户端，未设置时MqttDeviceClient按ConnectAuthConfig.TLS创建
+	Transfer   TransferOptions              // 分段、重试、限速等下载配置
+	Logger     logger.Logger
+}
+
+// Receiver 下载平台主动下发的文件，校验文件大小和sha256后保存到Dir
+type Receiver struct {
+	options       ReceiverOptions
+	lock          sync.Mutex
+	defaultClient bool // HttpClient未由用户设置，可以通过UseTLS替换
+}
+
+// fileAttributes 平台下发的文件属性，与上传时设备携带的属性一致
+type fileAttributes struct {
+	HashCode string `json:"hash_code"`
+	Size     int64  `json:"size"`
+}
+
+func NewReceiver(options ReceiverOptions) *Receiver {
+	if len(options.Dir) == 0 {
+		options.Dir = filepath.Join(os.TempDir(), "iot-files")
+	}
+	defaultClient := options.HttpClient == nil
+	if defaultClient {
+		options.HttpClient = CreateHttpClient()
+	}
+	if options.Logger == nil {
+		options.Logger = logger.Default()
+	}
+	return &Receiver{options: options, defaultClient: defaultClient}
+}
+
+// UseTLS 未设置HttpClient时按settings创建下载文件使用的客户端，只生效一次，
+// MqttDeviceClient在下载前使用ConnectAuthConfig.TLS调用
+func (receiver *Receiver) UseTLS(settings tlsconfig.Settings) error {
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+	if !receiver.defaultClient {
+		return nil
+	}
+	client, err := CreateHttpClientWithSettings(settings)
+	if err != nil {
+		return err
+	}
+	receiver.options.HttpClient = client
+	receiver.defaultClient = false
+	return nil
+}
+
+func (receiver *Receiver) httpClient() HttpClient {
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+	return receiver.options.HttpClient
+}
+
+// Receive 下载平台下发的文件，返回文件的保存路径，完成后调用Handler。
+// 文件先下载到临时文件，校验通过后才重命名为平台下发的文件名
+func (receiver *Receiver) Receive(ctx context.Context, paras model.FileResponseServiceEventParas) (string, error) {
+	fileName, filePath, err := receiver.receive(ctx, paras)
+	if err != nil {
+		receiver.options.Logger.Warn("receive file failed", logger.String("file", paras.ObjectName), logger.Err(err))
+	} else {
+		receiver.options.Logger.Info("receive file success", logger.String("file", fileName), logger.String("path", filePath))
+	}
+	if receiver.options.Handler != nil {
+		receiver.options.Handler(fileName, filePath, err)
+	}
+	return filePath, err
+}
+
+func (receiver *Receiver) receive(ctx context.Context, paras model.FileResponseServiceEventParas) (string, string, error) {
+	fileName := filepath.Base(filepath.FromSlash(strings.ReplaceAll(paras.ObjectName, "\\", "/")))
+	if len(paras.Url) == 0 || fileName == "." || fileName == ".." || fileName == string(filepath.Separator) {
+		return fileName, "", iot.NewDeviceError("receive file", "", iot.ErrInvalidParams,
+			fmt.Errorf("invalid file %q or empty url", paras.ObjectName))
+	}
+	attributes := fileAttributes{}
+	if paras.FileAttributes != nil {
+		if err := json.Unmarshal([]byte(iot.Interface2JsonString(paras.FileAttributes)), &attributes); err != nil {
+			return fileName, "", iot.NewDeviceError("receive file", "", iot.ErrInvalidParams, err)
+		}
+	}
+	if err := os.MkdirAll(receiver.options.Dir, 0755); err != nil {
+		return fileName, "", iot.NewDeviceError("receive file", "", iot.ErrFileTransfer, err)
+	}
+
+	filePath := filepath.Join(receiver.options.Dir, fileName)
+	downloadPath := filePath + ".download"
+	if err := receiver.httpClient().DownloadFileWithContext(ctx, downloadPath, paras.Url, "", receiver.options.Transfer); err != nil {
+		return fileName, "", err
+	}
+	if err := checkFile(downloadPath, attributes); err != nil {
+		os.Remove(downloadPath)
+		return fileName, "", iot.NewDeviceError("receive file", "", iot.ErrFileTransfer, err)
+	}
+	if err := os.Rename(downloadPath, filePath); err != nil {
+		return fileName, "", iot.NewDeviceError("receive file", "", iot.ErrFileTransfer, err)
+	}
+	return fileName, filePath, nil
+}
+
+// checkFile 校验平台下发的文件大小和sha256，平台未携带时不校验
+func checkFile(path string, attributes fileAttributes) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if attributes.Size > 0 && size != attributes.Size {
+		return fmt.Errorf("file size %d not match %d", size, attributes.Size)
+	}
+	if len(attributes.HashCode) != 0 && !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), attributes.HashCode) {
+		return errors.New("file sha256 not match")
+	}
+	return nil
+}
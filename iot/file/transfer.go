@@ -0,0 +1,220 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries      = 3
+	defaultRetryBackoff    = time.Second
+	defaultMaxRetryBackoff = 30 * time.Second
+	transferBufferSize     = 32 * 1024
+)
+
+// TransferOptions 文件上传下载配置，零值使用默认配置
+type TransferOptions struct {
+	// ChunkSize 下载时每个Range请求的字节数，为0时一次请求下载整个文件，只在中断后通过Range续传。
+	// 上传使用平台签名的URL，只能整体PUT，ChunkSize不生效
+	ChunkSize int64
+	// MaxRetries 失败后的最大重试次数，默认3次，小于0时不重试。下载时每成功传输一段会重新计数
+	MaxRetries int
+	// RetryBackoff 首次重试的等待时间，之后每次翻倍，默认1s
+	RetryBackoff time.Duration
+	// MaxRetryBackoff 重试等待时间的上限，默认30s
+	MaxRetryBackoff time.Duration
+	// RateLimit 每秒最多传输的字节数，为0时不限速
+	RateLimit int64
+	// Progress 传输进度回调，total未知时为-1
+	Progress func(transferred, total int64)
+	// Timeout 从获取URL到传输完成的总超时时间，为0时不限制
+	Timeout time.Duration
+}
+
+func (options TransferOptions) withDefaults() TransferOptions {
+	if options.MaxRetries == 0 {
+		options.MaxRetries = defaultMaxRetries
+	}
+	if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = defaultRetryBackoff
+	}
+	if options.MaxRetryBackoff <= 0 {
+		options.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	return options
+}
+
+// backoff 第attempt次重试前的等待时间
+func (options TransferOptions) backoff(attempt int) time.Duration {
+	wait := options.RetryBackoff
+	for i := 1; i < attempt && wait < options.MaxRetryBackoff; i++ {
+		wait *= 2
+	}
+	if wait > options.MaxRetryBackoff {
+		wait = options.MaxRetryBackoff
+	}
+	return wait
+}
+
+// statusError 服务端返回了非预期的状态码
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected http status: " + e.status
+}
+
+// HttpStatus 上传或下载失败时服务端返回的非预期状态码，其他错误返回0
+func HttpStatus(err error) int {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code
+	}
+	return 0
+}
+
+// retryable 网络错误、5xx、408和429可以重试，ctx取消、本地文件错误和其他状态码不重试
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500 || statusErr.code == http.StatusRequestTimeout || statusErr.code == http.StatusTooManyRequests
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// sleep 等待d，ctx取消时提前返回
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rateLimiter 按平均速率限速，同一次传输的多次请求共用
+type rateLimiter struct {
+	rate  int64
+	start time.Time
+	bytes int64
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, start: time.Now()}
+}
+
+func (limiter *rateLimiter) wait(ctx context.Context, n int) error {
+	if limiter == nil {
+		return nil
+	}
+	limiter.bytes += int64(n)
+	expected := time.Duration(float64(limiter.bytes) / float64(limiter.rate) * float64(time.Second))
+	if delay := expected - time.Since(limiter.start); delay > 0 {
+		return sleep(ctx, delay)
+	}
+	return nil
+}
+
+// transferReader 读取时限速并回调进度
+type transferReader struct {
+	ctx         context.Context
+	reader      io.Reader
+	limiter     *rateLimiter
+	transferred int64
+	total       int64
+	progress    func(transferred, total int64)
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	if len(p) > transferBufferSize {
+		p = p[:transferBufferSize]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.transferred += int64(n)
+		if r.progress != nil {
+			r.progress(r.transferred, r.total)
+		}
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// parseContentRange 解析Content-Range: bytes start-end/total，total未知时返回-1
+func parseContentRange(value string) (start, total int64, err error) {
+	value = strings.TrimSpace(strings.TrimPrefix(value, "bytes"))
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid content range %q", value)
+	}
+	total = -1
+	if parts[1] != "*" {
+		if total, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid content range %q", value)
+		}
+	}
+	if parts[0] == "*" {
+		return -1, total, nil
+	}
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if start, err = strconv.ParseInt(strings.TrimSpace(bounds[0]), 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid content range %q", value)
+	}
+	return start, total, nil
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package file
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeServer 支持Range和If-Range的文件服务，可以在返回一半数据后中断连接
+type rangeServer struct {
+	lock      sync.Mutex
+	content   []byte
+	etag      string
+	interrupt int      // 之后的多少个请求在返回一半数据后中断
+	requests  []string // 每个请求的Range和If-Range
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	content, etag := s.content, s.etag
+	interrupt := s.interrupt > 0
+	if interrupt {
+		s.interrupt--
+	}
+	s.requests = append(s.requests, r.Header.Get("Range")+"|"+r.Header.Get("If-Range"))
+	s.lock.Unlock()
+	if content == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if interrupt {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write(content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+func (s *rangeServer) set(content []byte, etag string, interrupt int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.content, s.etag, s.interrupt = content, etag, interrupt
+}
+
+func (s *rangeServer) takeRequests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func randomContent(size int, seed int64) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(content)
+	return content
+}
+
+func TestDownloadResume(t *testing.T) {
+	v1 := randomContent(256<<10, 1)
+	v2 := randomContent(200<<10, 2)
+	half := "bytes=" + strconv.Itoa(len(v1)/2) + "-"
+	retry := TransferOptions{RetryBackoff: time.Millisecond}
+	noRetry := TransferOptions{MaxRetries: -1}
+
+	type attempt struct {
+		content   []byte
+		etag      string
+		interrupt int
+		options   TransferOptions
+		ok        bool
+		requests  []string // 期望的Range|If-Range
+	}
+	tests := []struct {
+		name     string
+		attempts []attempt
+		want     []byte
+	}{
+		{
+			name: "retry after interruption",
+			attempts: []attempt{
+				{content: v1, etag: `"v1"`, interrupt: 1, options: retry, ok: true, requests: []string{"|", half + `|"v1"`}},
+			},
+			want: v1,
+		},
+		{
+			name: "resume in next download",
+			attempts: []attempt{
+				{content: v1, etag: `"v1"`, interrupt: 1, options: noRetry, requests: []string{"|"}},
+				{content: v1, etag: `"v1"`, options: noRetry, ok: true, requests: []string{half + `|"v1"`}},
+			},
+			want: v1,
+		},
+		{
+			name: "restart when file changed",
+			attempts: []attempt{
+				{content: v1, etag: `"v1"`, interrupt: 1, options: noRetry, requests: []string{"|"}},
+				{content: v2, etag: `"v2"`, options: noRetry, ok: true, requests: []string{half + `|"v1"`}},
+			},
+			want: v2,
+		},
+		{
+			name: "chunked",
+			attempts: []attempt{
+				{content: v1, etag: `"v1"`, options: TransferOptions{ChunkSize: 100 << 10}, ok: true, requests: []string{
+					"bytes=0-102399|",
+					`bytes=102400-204799|"v1"`,
+					`bytes=204800-262143|"v1"`,
+				}},
+			},
+			want: v1,
+		},
+		{
+			name: "not found removes part",
+			attempts: []attempt{
+				{content: v1, etag: `"v1"`, interrupt: 1, options: noRetry, requests: []string{"|"}},
+				{options: noRetry, requests: []string{half + `|"v1"`}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &rangeServer{}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			fileName := filepath.Join(t.TempDir(), "package.bin")
+			for i, a := range tt.attempts {
+				server.set(a.content, a.etag, a.interrupt)
+				err := DownloadFileWithHeader(context.Background(), httpServer.Client(), fileName, httpServer.URL, nil, a.options)
+				if (err == nil) != a.ok {
+					t.Fatalf("attempt %d: download error = %v, want ok %v", i, err, a.ok)
+				}
+				requests := server.takeRequests()
+				if len(requests) != len(a.requests) {
+					t.Fatalf("attempt %d: requests = %q, want %q", i, requests, a.requests)
+				}
+				for j := range requests {
+					if requests[j] != a.requests[j] {
+						t.Errorf("attempt %d: request %d = %q, want %q", i, j, requests[j], a.requests[j])
+					}
+				}
+			}
+			content, err := os.ReadFile(fileName)
+			if tt.want == nil {
+				if !os.IsNotExist(err) {
+					t.Errorf("file exists after failed download: %v", err)
+				}
+			} else if err != nil || !bytes.Equal(content, tt.want) {
+				t.Fatalf("downloaded %d bytes, err %v, want %d bytes", len(content), err, len(tt.want))
+			}
+			// 完成或不可恢复的失败后不保留续传文件
+			for _, name := range []string{fileName + ".part", fileName + ".part.validator"} {
+				if _, err := os.Stat(name); !os.IsNotExist(err) {
+					t.Errorf("%s is kept: %v", filepath.Base(name), err)
+				}
+			}
+		})
+	}
+}
@@ -29,6 +29,7 @@
 package gateway
 
 import (
+	"context"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
 )
 
@@ -49,3 +50,19 @@ type BaseGateway interface {
 	// SyncSubDevices 网关同步特定版本子设备列表
 	SyncSubDevices(version int)
 }
+
+// ContextGateway 在BaseGateway的基础上增加支持ctx取消、返回失败原因和等待平台响应的接口
+type ContextGateway interface {
+	BaseGateway
+
+	// 以下接口支持ctx取消，并返回失败原因
+	UpdateSubDeviceStateWithContext(ctx context.Context, subDevicesStatus model.SubDevicesStatus) error
+	DeleteSubDevicesWithContext(ctx context.Context, deviceIds []string) error
+	AddSubDevicesWithContext(ctx context.Context, deviceInfos []model.DeviceInfo) error
+	SyncAllVersionSubDevicesWithContext(ctx context.Context) error
+	SyncSubDevicesWithContext(ctx context.Context, version int) error
+
+	// 以下接口会等待平台响应
+	AddSubDevicesSync(ctx context.Context, deviceInfos []model.DeviceInfo) (model.SubDeviceAddResponse, error)
+	DeleteSubDevicesSync(ctx context.Context, deviceIds []string) (model.SubDeviceDeleteResponse, error)
+}
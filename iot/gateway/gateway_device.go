@@ -29,11 +29,13 @@
 package gateway
 
 import (
-	"github.com/golang/glog"
+	"context"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/client"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/constants"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/device"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
 )
 
@@ -44,9 +46,17 @@ type MqttGatewayDevice struct {
 
 func NewMqttGatewayDevice(authConfig *config.ConnectAuthConfig) *MqttGatewayDevice {
 	mqttDevice := device.NewMqttDevice(authConfig)
-	return &MqttGatewayDevice{
+	gatewayDevice := &MqttGatewayDevice{
 		MqttDevice: *mqttDevice,
 	}
+	// 端侧规则中子设备的命令和平台下发给子设备的命令一样交给CommandHandler处理
+	gatewayDevice.Client.SubDeviceCommandHandler = func(command model.Command) (bool, interface{}) {
+		if gatewayDevice.Client.CommandHandler == nil {
+			return false, nil
+		}
+		return gatewayDevice.Client.CommandHandler(command)
+	}
+	return gatewayDevice
 }
 
 func (gatewayDevice *MqttGatewayDevice) Connect() bool {
@@ -58,7 +68,16 @@ func (gatewayDevice *MqttGatewayDevice) Connect() bool {
 }
 
 func (gatewayDevice *MqttGatewayDevice) UpdateSubDeviceState(subDevicesStatus model.SubDevicesStatus) bool {
-	glog.Infof("begin to update sub-devices status")
+	err := gatewayDevice.UpdateSubDeviceStateWithContext(context.Background(), subDevicesStatus)
+	if err != nil {
+		gatewayDevice.Client.GetLogger().Warn("update sub devices status failed", logger.Err(err))
+		return false
+	}
+	return true
+}
+
+func (gatewayDevice *MqttGatewayDevice) UpdateSubDeviceStateWithContext(ctx context.Context, subDevicesStatus model.SubDevicesStatus) error {
+	gatewayDevice.Client.GetLogger().Info("begin to update sub devices status", logger.Int("count", len(subDevicesStatus.DeviceStatuses)))
 	subDeviceCounts := len(subDevicesStatus.DeviceStatuses)
 
 	batchUpdateSubDeviceState := 0
@@ -90,18 +109,26 @@ func (gatewayDevice *MqttGatewayDevice) UpdateSubDeviceState(subDevicesStatus mo
 			ObjectDeviceId: gatewayDevice.ConnectionAuthInfo.Id,
 			Services:       []model.DataEntry{requestEventService},
 		}
-		if !gatewayDevice.Client.PublishMessage(iot.FormatTopic(constants.DeviceToPlatformTopic, gatewayDevice.ConnectionAuthInfo.Id), gatewayDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(request)) {
-			glog.Warningf("gateway %s update sub devices status failed", gatewayDevice.ConnectionAuthInfo.Id)
-			return false
+		if err := gatewayDevice.publishEvent(ctx, request); err != nil {
+			return err
 		}
 	}
 
-	glog.Info("gateway  update sub devices status failed", gatewayDevice.ConnectionAuthInfo.Id)
-	return true
+	gatewayDevice.Client.GetLogger().Info("update sub devices status success")
+	return nil
 }
 
 func (gatewayDevice *MqttGatewayDevice) DeleteSubDevices(deviceIds []string) bool {
-	glog.Infof("begin to delete sub-devices %s", deviceIds)
+	err := gatewayDevice.DeleteSubDevicesWithContext(context.Background(), deviceIds)
+	if err != nil {
+		gatewayDevice.Client.GetLogger().Warn("send delete sub devices request failed", logger.Err(err))
+		return false
+	}
+	return true
+}
+
+func (gatewayDevice *MqttGatewayDevice) DeleteSubDevicesWithContext(ctx context.Context, deviceIds []string) error {
+	gatewayDevice.Client.GetLogger().Info("begin to delete sub devices", logger.Any("sub_device_ids", deviceIds))
 
 	subDevices := struct {
 		Devices []string `json:"devices"`
@@ -121,16 +148,24 @@ func (gatewayDevice *MqttGatewayDevice) DeleteSubDevices(deviceIds []string) boo
 		Services:       []model.DataEntry{requestEventService},
 	}
 
-	if !gatewayDevice.Client.PublishMessage(iot.FormatTopic(constants.DeviceToPlatformTopic, gatewayDevice.ConnectionAuthInfo.Id), gatewayDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(request)) {
-		glog.Warningf("gateway %s delete sub devices request send failed", gatewayDevice.ConnectionAuthInfo.Id)
-		return false
+	if err := gatewayDevice.publishEvent(ctx, request); err != nil {
+		return err
 	}
 
-	glog.Warningf("gateway %s delete sub devices request send success", gatewayDevice.ConnectionAuthInfo.Id)
-	return true
+	gatewayDevice.Client.GetLogger().Info("send delete sub devices request success")
+	return nil
 }
 
 func (gatewayDevice *MqttGatewayDevice) AddSubDevices(deviceInfos []model.DeviceInfo) bool {
+	err := gatewayDevice.AddSubDevicesWithContext(context.Background(), deviceInfos)
+	if err != nil {
+		gatewayDevice.Client.GetLogger().Warn("send add sub devices request failed", logger.Err(err))
+		return false
+	}
+	return true
+}
+
+func (gatewayDevice *MqttGatewayDevice) AddSubDevicesWithContext(ctx context.Context, deviceInfos []model.DeviceInfo) error {
 	devices := struct {
 		Devices []model.DeviceInfo `json:"devices"`
 	}{
@@ -149,16 +184,49 @@ func (gatewayDevice *MqttGatewayDevice) AddSubDevices(deviceInfos []model.Device
 		Services:       []model.DataEntry{requestEventService},
 	}
 
-	if !gatewayDevice.Client.PublishMessage(iot.FormatTopic(constants.DeviceToPlatformTopic, gatewayDevice.ConnectionAuthInfo.Id), gatewayDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(request)) {
-		glog.Warningf("gateway %s add sub devices request send failed", gatewayDevice.ConnectionAuthInfo.Id)
-		return false
+	if err := gatewayDevice.publishEvent(ctx, request); err != nil {
+		return err
 	}
 
-	glog.Warningf("gateway %s add sub devices request send success", gatewayDevice.ConnectionAuthInfo.Id)
-	return true
+	gatewayDevice.Client.GetLogger().Info("send add sub devices request success")
+	return nil
+}
+
+// AddSubDevicesSync 添加子设备并等待平台响应，ctx未设置超时时间时使用RequestTimeOut
+func (gatewayDevice *MqttGatewayDevice) AddSubDevicesSync(ctx context.Context, deviceInfos []model.DeviceInfo) (model.SubDeviceAddResponse, error) {
+	request := gatewayDevice.Client.PendingRequests.Register(client.EventKey("$sub_device_manager", "add_sub_device_response"))
+	defer request.Cancel()
+	if err := gatewayDevice.AddSubDevicesWithContext(ctx, deviceInfos); err != nil {
+		return model.SubDeviceAddResponse{}, err
+	}
+	response, err := request.Wait(ctx, gatewayDevice.ConnectionAuthInfo.RequestTimeOut)
+	if err != nil {
+		return model.SubDeviceAddResponse{}, err
+	}
+	return response.(model.SubDeviceAddResponse), nil
+}
+
+// DeleteSubDevicesSync 删除子设备并等待平台响应，ctx未设置超时时间时使用RequestTimeOut
+func (gatewayDevice *MqttGatewayDevice) DeleteSubDevicesSync(ctx context.Context, deviceIds []string) (model.SubDeviceDeleteResponse, error) {
+	request := gatewayDevice.Client.PendingRequests.Register(client.EventKey("$sub_device_manager", "delete_sub_device_response"))
+	defer request.Cancel()
+	if err := gatewayDevice.DeleteSubDevicesWithContext(ctx, deviceIds); err != nil {
+		return model.SubDeviceDeleteResponse{}, err
+	}
+	response, err := request.Wait(ctx, gatewayDevice.ConnectionAuthInfo.RequestTimeOut)
+	if err != nil {
+		return model.SubDeviceDeleteResponse{}, err
+	}
+	return response.(model.SubDeviceDeleteResponse), nil
 }
 
 func (gatewayDevice *MqttGatewayDevice) SyncAllVersionSubDevices() {
+	if err := gatewayDevice.SyncAllVersionSubDevicesWithContext(context.Background()); err != nil {
+		gatewayDevice.Client.GetLogger().Error("send sub device sync request failed", logger.Err(err))
+	}
+}
+
+func (gatewayDevice *MqttGatewayDevice) SyncAllVersionSubDevicesWithContext(ctx context.Context) error {
 	dataEntry := model.DataEntry{
 		ServiceId: "$sub_device_manager",
 		EventType: "sub_device_sync_request",
@@ -174,12 +242,16 @@ func (gatewayDevice *MqttGatewayDevice) SyncAllVersionSubDevices() {
 		Services: dataEntries,
 	}
 
-	if !gatewayDevice.Client.PublishMessage(iot.FormatTopic(constants.DeviceToPlatformTopic, gatewayDevice.ConnectionAuthInfo.Id), gatewayDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(data)) {
-		glog.Errorf("send sub device sync request failed")
-	}
+	return gatewayDevice.publishEvent(ctx, data)
 }
 
 func (gatewayDevice *MqttGatewayDevice) SyncSubDevices(version int) {
+	if err := gatewayDevice.SyncSubDevicesWithContext(context.Background(), version); err != nil {
+		gatewayDevice.Client.GetLogger().Error("send sync sub device request failed", logger.Err(err))
+	}
+}
+
+func (gatewayDevice *MqttGatewayDevice) SyncSubDevicesWithContext(ctx context.Context, version int) error {
 	syncParas := struct {
 		Version int `json:"version"`
 	}{
@@ -200,7 +272,10 @@ func (gatewayDevice *MqttGatewayDevice) SyncSubDevices(version int) {
 		Services: dataEntries,
 	}
 
-	if !gatewayDevice.Client.PublishMessage(iot.FormatTopic(constants.DeviceToPlatformTopic, gatewayDevice.ConnectionAuthInfo.Id), gatewayDevice.ConnectionAuthInfo.Qos, iot.Interface2JsonString(data)) {
-		glog.Errorf("send sync sub device request failed")
-	}
+	return gatewayDevice.publishEvent(ctx, data)
+}
+
+func (gatewayDevice *MqttGatewayDevice) publishEvent(ctx context.Context, data interface{}) error {
+	topic := iot.FormatTopic(constants.DeviceToPlatformTopic, gatewayDevice.ConnectionAuthInfo.Id)
+	return gatewayDevice.Client.PublishObjectWithContext(ctx, topic, gatewayDevice.ConnectionAuthInfo.Qos, data)
 }
@@ -0,0 +1,81 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package logger
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"strings"
+)
+
+type glogLogger struct {
+	fields []Field
+}
+
+// NewGlogLogger 使用glog输出日志，与旧版本SDK的日志输出保持一致，Debug级别的日志在-v=1时输出
+func NewGlogLogger() Logger {
+	return glogLogger{}
+}
+
+func (l glogLogger) Debug(msg string, fields ...Field) {
+	if glog.V(1) {
+		glog.InfoDepth(1, l.format(msg, fields))
+	}
+}
+
+func (l glogLogger) Info(msg string, fields ...Field) {
+	glog.InfoDepth(1, l.format(msg, fields))
+}
+
+func (l glogLogger) Warn(msg string, fields ...Field) {
+	glog.WarningDepth(1, l.format(msg, fields))
+}
+
+func (l glogLogger) Error(msg string, fields ...Field) {
+	glog.ErrorDepth(1, l.format(msg, fields))
+}
+
+func (l glogLogger) With(fields ...Field) Logger {
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+	return glogLogger{fields: merged}
+}
+
+func (l glogLogger) format(msg string, fields []Field) string {
+	var builder strings.Builder
+	builder.WriteString(msg)
+	for _, field := range l.fields {
+		fmt.Fprintf(&builder, " %s=%v", field.Key, field.Value)
+	}
+	for _, field := range fields {
+		fmt.Fprintf(&builder, " %s=%v", field.Key, field.Value)
+	}
+	return builder.String()
+}
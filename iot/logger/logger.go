@@ -0,0 +1,130 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package logger SDK的日志接口，默认不输出任何日志，可以通过ConnectAuthConfig.Logger或SetDefault接入业务使用的日志框架。
+package logger
+
+import (
+	"sync"
+)
+
+// Field 结构化日志的字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int(key string, value int) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err 记录错误，err为nil时值为nil
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Value: nil}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+// 常用的字段名称
+const (
+	KeyDeviceId  = "device_id"
+	KeyTopic     = "topic"
+	KeyRequestId = "request_id"
+)
+
+func DeviceId(deviceId string) Field {
+	return String(KeyDeviceId, deviceId)
+}
+
+func Topic(topic string) Field {
+	return String(KeyTopic, topic)
+}
+
+func RequestId(requestId string) Field {
+	return String(KeyRequestId, requestId)
+}
+
+// Logger 结构化日志接口
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	// With 返回携带固定字段的Logger
+	With(fields ...Field) Logger
+}
+
+type nopLogger struct{}
+
+// NewNopLogger 不输出任何日志的Logger
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(string, ...Field) {}
+
+func (nopLogger) Info(string, ...Field) {}
+
+func (nopLogger) Warn(string, ...Field) {}
+
+func (nopLogger) Error(string, ...Field) {}
+
+func (l nopLogger) With(...Field) Logger {
+	return l
+}
+
+var (
+	defaultLock   sync.RWMutex
+	defaultLogger Logger = nopLogger{}
+)
+
+// Default 返回全局Logger，未配置ConnectAuthConfig.Logger的设备以及不属于某个设备的日志使用该Logger
+func Default() Logger {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault 设置全局Logger，logger为nil时恢复为不输出日志
+func SetDefault(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultLogger = logger
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package metrics SDK的指标接口，默认不记录任何指标，prometheus和otel子包提供了现成的实现
+package metrics
+
+import (
+	"strings"
+	"time"
+)
+
+// topic分类，用作指标的topic_class标签，避免按完整topic产生过多的时间序列
+const (
+	TopicClassProperties = "properties"
+	TopicClassMessages   = "messages"
+	TopicClassEvents     = "events"
+	TopicClassCommands   = "commands"
+	TopicClassShadow     = "shadow"
+	TopicClassCustom     = "custom"
+)
+
+// TopicClass 根据topic判断分类，非平台系统topic归为custom
+func TopicClass(topic string) string {
+	switch {
+	case strings.Contains(topic, "/sys/properties/") || strings.Contains(topic, "/sys/gateway/sub_devices/properties/"):
+		return TopicClassProperties
+	case strings.Contains(topic, "/sys/messages/"):
+		return TopicClassMessages
+	case strings.Contains(topic, "/sys/events/"):
+		return TopicClassEvents
+	case strings.Contains(topic, "/sys/commands/"):
+		return TopicClassCommands
+	case strings.Contains(topic, "/sys/shadow/"):
+		return TopicClassShadow
+	}
+	return TopicClassCustom
+}
+
+// Metrics 指标记录接口，实现需要保证并发安全且不能阻塞调用方
+type Metrics interface {
+	// PublishDone 一次发布完成，err不为nil表示发布失败
+	PublishDone(deviceId, topicClass string, duration time.Duration, err error)
+
+	// MessageReceived 收到平台下发的消息
+	MessageReceived(deviceId, topicClass string)
+
+	// HandlerDone 协程池中处理一条平台消息完成
+	HandlerDone(deviceId, topicClass string, duration time.Duration)
+
+	// BufferedMessages 断链缓存中的消息数量
+	BufferedMessages(deviceId string, count int)
+
+	// ReconnectAttempt 一次建链重试，attempt为本轮建链的第几次尝试
+	ReconnectAttempt(deviceId string, attempt int64)
+
+	// RuleFired 端侧规则被触发
+	RuleFired(deviceId string, actions int)
+}
+
+type nopMetrics struct{}
+
+// NewNopMetrics 不记录任何指标
+func NewNopMetrics() Metrics {
+	return nopMetrics{}
+}
+
+func (nopMetrics) PublishDone(string, string, time.Duration, error) {}
+
+func (nopMetrics) MessageReceived(string, string) {}
+
+func (nopMetrics) HandlerDone(string, string, time.Duration) {}
+
+func (nopMetrics) BufferedMessages(string, int) {}
+
+func (nopMetrics) ReconnectAttempt(string, int64) {}
+
+func (nopMetrics) RuleFired(string, int) {}
+
+// Result 发布结果标签，success或failure
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package otel 基于OpenTelemetry Meter的指标实现
+package otel
+
+import (
+	"context"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/unit"
+	"sync"
+	"time"
+)
+
+const (
+	keyDeviceId   = attribute.Key("device_id")
+	keyTopicClass = attribute.Key("topic_class")
+	keyResult     = attribute.Key("result")
+)
+
+// Metrics 使用OpenTelemetry Meter记录指标
+type Metrics struct {
+	publishDuration  instrument.Float64Histogram
+	receivedMessages instrument.Int64Counter
+	handlerDuration  instrument.Float64Histogram
+	reconnects       instrument.Int64Counter
+	ruleFirings      instrument.Int64Counter
+	ruleActions      instrument.Int64Counter
+
+	// 缓存消息数量通过异步Gauge上报，记录每个设备最近一次的值
+	lock     sync.Mutex
+	buffered map[string]int64
+}
+
+// NewMetrics 使用meter创建指标，如otel.Meter("github.com/huaweicloud/huaweicloud-iot-device-sdk-go")
+func NewMetrics(meter metric.Meter) (*Metrics, error) {
+	m := &Metrics{
+		buffered: make(map[string]int64),
+	}
+	var err error
+	if m.publishDuration, err = meter.Float64Histogram("iot_device.publish.duration",
+		instrument.WithDescription("Duration of publishing a message to the platform."), instrument.WithUnit(unit.Unit("s"))); err != nil {
+		return nil, err
+	}
+	if m.receivedMessages, err = meter.Int64Counter("iot_device.received_messages",
+		instrument.WithDescription("Number of messages received from the platform.")); err != nil {
+		return nil, err
+	}
+	if m.handlerDuration, err = meter.Float64Histogram("iot_device.handler.duration",
+		instrument.WithDescription("Duration of handling a platform message in the worker pool."), instrument.WithUnit(unit.Unit("s"))); err != nil {
+		return nil, err
+	}
+	if m.reconnects, err = meter.Int64Counter("iot_device.reconnect_attempts",
+		instrument.WithDescription("Number of connect retries.")); err != nil {
+		return nil, err
+	}
+	if m.ruleFirings, err = meter.Int64Counter("iot_device.rule_firings",
+		instrument.WithDescription("Number of device rule firings.")); err != nil {
+		return nil, err
+	}
+	if m.ruleActions, err = meter.Int64Counter("iot_device.rule_actions",
+		instrument.WithDescription("Number of actions executed by device rules.")); err != nil {
+		return nil, err
+	}
+	if _, err = meter.Int64ObservableGauge("iot_device.buffered_messages",
+		instrument.WithDescription("Number of messages buffered while disconnected."),
+		instrument.WithInt64Callback(m.observeBuffered)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Metrics) observeBuffered(_ context.Context, observer instrument.Int64Observer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for deviceId, count := range m.buffered {
+		observer.Observe(count, keyDeviceId.String(deviceId))
+	}
+	return nil
+}
+
+func (m *Metrics) PublishDone(deviceId, topicClass string, duration time.Duration, err error) {
+	m.publishDuration.Record(context.Background(), duration.Seconds(),
+		keyDeviceId.String(deviceId), keyTopicClass.String(topicClass), keyResult.String(metrics.Result(err)))
+}
+
+func (m *Metrics) MessageReceived(deviceId, topicClass string) {
+	m.receivedMessages.Add(context.Background(), 1, keyDeviceId.String(deviceId), keyTopicClass.String(topicClass))
+}
+
+func (m *Metrics) HandlerDone(deviceId, topicClass string, duration time.Duration) {
+	m.handlerDuration.Record(context.Background(), duration.Seconds(), keyDeviceId.String(deviceId), keyTopicClass.String(topicClass))
+}
+
+func (m *Metrics) BufferedMessages(deviceId string, count int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.buffered[deviceId] = int64(count)
+}
+
+func (m *Metrics) ReconnectAttempt(deviceId string, _ int64) {
+	m.reconnects.Add(context.Background(), 1, keyDeviceId.String(deviceId))
+}
+
+func (m *Metrics) RuleFired(deviceId string, actions int) {
+	m.ruleFirings.Add(context.Background(), 1, keyDeviceId.String(deviceId))
+	m.ruleActions.Add(context.Background(), int64(actions), keyDeviceId.String(deviceId))
+}
+
+var _ metrics.Metrics = (*Metrics)(nil)
@@ -0,0 +1,135 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package prometheus 基于Prometheus的指标实现
+package prometheus
+
+import (
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+const namespace = "iot_device"
+
+// Collector 同时实现了metrics.Metrics和prometheus.Collector，注册到Registry后配置到ConnectAuthConfig.Metrics即可
+type Collector struct {
+	publishDuration  *prometheus.HistogramVec
+	receivedMessages *prometheus.CounterVec
+	handlerDuration  *prometheus.HistogramVec
+	bufferedMessages *prometheus.GaugeVec
+	reconnects       *prometheus.CounterVec
+	ruleFirings      *prometheus.CounterVec
+	ruleActions      *prometheus.CounterVec
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		publishDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "publish_duration_seconds",
+			Help:      "Duration of publishing a message to the platform.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"device_id", "topic_class", "result"}),
+		receivedMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "received_messages_total",
+			Help:      "Number of messages received from the platform.",
+		}, []string{"device_id", "topic_class"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Duration of handling a platform message in the worker pool.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"device_id", "topic_class"}),
+		bufferedMessages: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "buffered_messages",
+			Help:      "Number of messages buffered while disconnected.",
+		}, []string{"device_id"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnect_attempts_total",
+			Help:      "Number of connect retries.",
+		}, []string{"device_id"}),
+		ruleFirings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rule_firings_total",
+			Help:      "Number of device rule firings.",
+		}, []string{"device_id"}),
+		ruleActions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rule_actions_total",
+			Help:      "Number of actions executed by device rules.",
+		}, []string{"device_id"}),
+	}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.publishDuration, c.receivedMessages, c.handlerDuration, c.bufferedMessages, c.reconnects, c.ruleFirings, c.ruleActions}
+}
+
+func (c *Collector) Describe(descs chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(descs)
+	}
+}
+
+func (c *Collector) Collect(values chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(values)
+	}
+}
+
+func (c *Collector) PublishDone(deviceId, topicClass string, duration time.Duration, err error) {
+	c.publishDuration.WithLabelValues(deviceId, topicClass, metrics.Result(err)).Observe(duration.Seconds())
+}
+
+func (c *Collector) MessageReceived(deviceId, topicClass string) {
+	c.receivedMessages.WithLabelValues(deviceId, topicClass).Inc()
+}
+
+func (c *Collector) HandlerDone(deviceId, topicClass string, duration time.Duration) {
+	c.handlerDuration.WithLabelValues(deviceId, topicClass).Observe(duration.Seconds())
+}
+
+func (c *Collector) BufferedMessages(deviceId string, count int) {
+	c.bufferedMessages.WithLabelValues(deviceId).Set(float64(count))
+}
+
+func (c *Collector) ReconnectAttempt(deviceId string, attempt int64) {
+	c.reconnects.WithLabelValues(deviceId).Inc()
+}
+
+func (c *Collector) RuleFired(deviceId string, actions int) {
+	c.ruleFirings.WithLabelValues(deviceId).Inc()
+	c.ruleActions.WithLabelValues(deviceId).Add(float64(actions))
+}
+
+var _ metrics.Metrics = (*Collector)(nil)
+var _ prometheus.Collector = (*Collector)(nil)
@@ -28,16 +28,23 @@
 
 package model
 
-import "net"
+import (
+	"net"
+	"time"
+)
 
 // UpgradeInfo 平台下发的升级信息
 type UpgradeInfo struct {
-	Version     string `json:"version"`      // 软固件包版本号
-	Url         string `json:"url"`          // 软固件包下载地址
-	FileSize    int    `json:"file_size"`    // 软固件包文件大小
-	AccessToken string `json:"access_token"` // 软固件包url下载地址的临时token
-	Expires     int64  `json:"expires"`      // access_token的超期时间
-	Sign        string `json:"sign"`         // 软固件包MD5值
+	Version     string      `json:"version"`       // 软固件包版本号
+	Url         string      `json:"url"`           // 软固件包下载地址
+	FileSize    int         `json:"file_size"`     // 软固件包文件大小
+	AccessToken string      `json:"access_token"`  // 软固件包url下载地址的临时token
+	Expires     int64       `json:"expires"`       // access_token的超期时间
+	Sign        string      `json:"sign"`          // 软固件包MD5值
+	TaskId      string      `json:"task_id"`       // 升级任务ID
+	FileName    string      `json:"file_name"`     // 软固件包文件名
+	CustomInfo  string      `json:"custom_info"`   // 创建升级包时填写的自定义信息，如差分包描述
+	TaskExtInfo interface{} `json:"task_ext_info"` // 创建升级任务时填写的扩展信息
 }
 
 // UpgradeProgress 设备升级状态响应，用于设备向平台反馈进度，错误信息等
@@ -375,14 +382,22 @@ type RuleParas struct {
 }
 
 type RuleInfo struct {
-	RuleId              string      `json:"ruleId"`
-	RuleName            string      `json:"ruleName"`
-	Logic               string      `json:"logic"`
-	TimeRange           TimeRange   `json:"timeRange"`
-	Status              string      `json:"status"`
-	Conditions          []Condition `json:"conditions"`
-	Actions             []Action    `json:"actions"`
-	RuleVersionInShadow int         `json:"ruleVersionInShadow"`
+	RuleId              string       `json:"ruleId"`
+	RuleName            string       `json:"ruleName"`
+	Logic               string       `json:"logic"`
+	TimeRange           TimeRange    `json:"timeRange"`
+	Status              string       `json:"status"`
+	Conditions          []Condition  `json:"conditions"`
+	Actions             []Action     `json:"actions"`
+	RuleVersionInShadow int          `json:"ruleVersionInShadow"`
+	Trigger             *RuleTrigger `json:"trigger,omitempty"` // 属性触发的规则的触发方式，为空时使用设备的默认配置
+}
+
+// RuleTrigger 属性触发的规则何时执行动作，零值表示每次上报的属性满足条件时都执行
+type RuleTrigger struct {
+	Mode     string `json:"mode,omitempty"`     // LEVEL：每次满足条件都执行，EDGE：条件从不满足变为满足时执行一次
+	Cooldown int    `json:"cooldown,omitempty"` // 两次执行之间的最小间隔，单位秒
+	Duration int    `json:"duration,omitempty"` // 条件需要持续满足的时间，单位秒，到时间后即使没有新的上报也会执行
 }
 
 type TimeRange struct {
@@ -402,6 +417,7 @@ type Condition struct {
 	DeviceInfo     RuleDeviceInfo `json:"deviceInfo"`
 	Value          string         `json:"value"`
 	InValue        []string       `json:"inValues"`
+	Expression     string         `json:"expression,omitempty"` // 设置后忽略DeviceInfo.Path、Operator和Value，如 sensor.temp * 1.8 + 32 > 100
 }
 
 type RuleDeviceInfo struct {
@@ -410,10 +426,39 @@ type RuleDeviceInfo struct {
 }
 
 type Action struct {
-	Type     string      `json:"type"`
-	Status   string      `json:"status"`
-	DeviceId string      `json:"deviceId"`
-	Command  RuleCommand `json:"command"`
+	Type        string          `json:"type"`
+	Status      string          `json:"status"`
+	DeviceId    string          `json:"deviceId"`
+	Command     RuleCommand     `json:"command"`
+	Properties  *RuleProperties `json:"properties,omitempty"` // Type为DEVICE_PROPERTY时上报的属性
+	Message     *RuleMessage    `json:"message,omitempty"`    // Type为DEVICE_MESSAGE时上报的消息
+	Function    *RuleFunction   `json:"function,omitempty"`   // Type为FUNCTION时调用的本地函数
+	Http        *RuleHttp       `json:"http,omitempty"`       // Type为HTTP时调用的本地接口
+	RuleId      string          `json:"-"`                    // 触发动作的规则，执行时由sdk填写
+	ExecutionId string          `json:"-"`                    // 执行记录的id，执行时由sdk填写
+}
+
+// RuleActionResult 单个规则动作的执行结果
+type RuleActionResult struct {
+	RuleId      string `json:"ruleId"`
+	Type        string `json:"type"`
+	DeviceId    string `json:"deviceId"`
+	Success     bool   `json:"success"`
+	Error       string `json:"error,omitempty"`
+	Duration    int64  `json:"duration"`              // 执行耗时，单位毫秒
+	ExecutionId string `json:"executionId,omitempty"` // 所属的执行记录
+}
+
+// RuleExecution 一次端侧规则的执行记录
+type RuleExecution struct {
+	Id              string                 `json:"id"`
+	RuleId          string                 `json:"ruleId"`
+	Trigger         string                 `json:"trigger"` // 触发方式：DEVICE_DATA属性上报触发，TIMER定时触发
+	Time            time.Time              `json:"time"`
+	ConditionValues map[string]interface{} `json:"conditionValues,omitempty"` // 触发时条件引用的属性值，key为属性路径
+	Actions         []Action               `json:"actions"`
+	Results         []RuleActionResult     `json:"results,omitempty"` // 每个动作的结果，自定义RuleActionHandler未记录时为空
+	Success         bool                   `json:"success"`
 }
 
 type RuleVersion struct {
@@ -445,6 +490,31 @@ type RuleCommand struct {
 	CommandBody interface{} `json:"commandBody"`
 }
 
+type RuleProperties struct {
+	ServiceId  string      `json:"serviceId"`
+	Properties interface{} `json:"properties"`
+}
+
+// RuleMessage Topic为空时上报到系统消息topic，支持{device_id}占位符
+type RuleMessage struct {
+	Topic   string      `json:"topic,omitempty"`
+	Payload interface{} `json:"payload"`
+}
+
+// RuleFunction Name为通过ActionExecutors.RegisterFunction注册的函数名
+type RuleFunction struct {
+	Name   string      `json:"name"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+// RuleHttp 默认只允许调用本机地址，Method为空时使用POST
+type RuleHttp struct {
+	Url     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
 type BufferMessage struct {
 	Topic   string
 	Qos     byte
@@ -0,0 +1,132 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ota
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const bsdiffMagic = "BSDIFF40"
+
+// bspatch 应用BSDIFF40格式的差分包。旧镜像按需随机读取，新镜像顺序写入，不需要把整个镜像读入内存
+func bspatch(old io.ReaderAt, oldSize int64, patch io.ReaderAt, patchSize int64, target io.Writer) error {
+	header := make([]byte, 32)
+	if _, err := patch.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("read bsdiff header failed: %w", err)
+	}
+	if string(header[:8]) != bsdiffMagic {
+		return errors.New("invalid bsdiff magic")
+	}
+	ctrlLen := offtin(header[8:16])
+	diffLen := offtin(header[16:24])
+	newSize := offtin(header[24:32])
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || 32+ctrlLen+diffLen > patchSize {
+		return errors.New("corrupt bsdiff header")
+	}
+	ctrl := bzip2.NewReader(io.NewSectionReader(patch, 32, ctrlLen))
+	diff := bufio.NewReader(bzip2.NewReader(io.NewSectionReader(patch, 32+ctrlLen, diffLen)))
+	extra := bufio.NewReader(bzip2.NewReader(io.NewSectionReader(patch, 32+ctrlLen+diffLen, patchSize-32-ctrlLen-diffLen)))
+
+	var newPos, oldPos int64
+	buf := make([]byte, 24)
+	diffBuf := make([]byte, 32*1024)
+	oldBuf := make([]byte, 32*1024)
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, buf); err != nil {
+			return fmt.Errorf("read bsdiff control block failed: %w", err)
+		}
+		addLen, copyLen, seek := offtin(buf[0:8]), offtin(buf[8:16]), offtin(buf[16:24])
+		if addLen < 0 || copyLen < 0 || newPos+addLen+copyLen > newSize {
+			return errors.New("corrupt bsdiff control block")
+		}
+		// 差异数据与旧镜像对应位置的字节相加
+		for remain := addLen; remain > 0; {
+			n := int64(len(diffBuf))
+			if remain < n {
+				n = remain
+			}
+			if _, err := io.ReadFull(diff, diffBuf[:n]); err != nil {
+				return fmt.Errorf("read bsdiff diff block failed: %w", err)
+			}
+			if err := readOld(old, oldSize, oldPos, oldBuf[:n]); err != nil {
+				return err
+			}
+			for i := int64(0); i < n; i++ {
+				diffBuf[i] += oldBuf[i]
+			}
+			if _, err := target.Write(diffBuf[:n]); err != nil {
+				return err
+			}
+			remain -= n
+			oldPos += n
+		}
+		// 新增数据直接写入
+		if _, err := io.CopyN(target, extra, copyLen); err != nil {
+			return fmt.Errorf("read bsdiff extra block failed: %w", err)
+		}
+		newPos += addLen + copyLen
+		oldPos += seek
+	}
+	return nil
+}
+
+// readOld 读取旧镜像从pos开始的数据，超出旧镜像范围的部分按0处理
+func readOld(old io.ReaderAt, oldSize, pos int64, p []byte) error {
+	for i := range p {
+		p[i] = 0
+	}
+	start, end := pos, pos+int64(len(p))
+	if start < 0 {
+		start = 0
+	}
+	if end > oldSize {
+		end = oldSize
+	}
+	if start >= end {
+		return nil
+	}
+	if _, err := old.ReadAt(p[start-pos:end-pos], start); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read base image failed: %w", err)
+	}
+	return nil
+}
+
+// offtin bsdiff使用的符号位加小端序的64位整数
+func offtin(buf []byte) int64 {
+	value := int64(binary.LittleEndian.Uint64(buf) &^ (1 << 63))
+	if buf[7]&0x80 != 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ota
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+// 差分包由bsdiff格式的控制块(add, copy, seek)、差异数据和新增数据经bzip2压缩生成
+func TestBspatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		old    string
+		patch  string
+		target string
+	}{
+		{
+			// (38, 4, 0)：修改旧镜像的最后一个字节并追加新数据
+			name:   "modify",
+			old:    "hello world, this is the base image v1",
+			patch:  "QlNESUZGNDArAAAAAAAAACkAAAAAAAAAKgAAAAAAAABCWmg5MUFZJlNZs9RPoAAABdAATAgBACAAIYaBmgxWybi7kinChIWeon0AQlpoOTFBWSZTWckYgq4AAABQAGAAIAAgADDMDPUFzi7kinChIZIxBVxCWmg5MUFZJlNZnRGtggAAABGAQAACAQCAIAAhmmgzTRc8XckU4UJCdEa2CA==",
+			target: "hello world, this is the base image v2 new",
+		},
+		{
+			// (4, 0, 4) (4, 2, -12) (4, 0, 0)：旧镜像中向前和向后跳转
+			name:   "seek",
+			old:    "AAAABBBBCCCC",
+			patch:  "QlNESUZGNDA1AAAAAAAAACUAAAAAAAAADgAAAAAAAABCWmg5MUFZJlNZOlUvSwAAD+BAXAwIAEAAIAAhKaMgQwIjMRhQNApvNrfF3JFOFCQOlUvSwEJaaDkxQVkmU1n2Y6veAAAAQABAQCAAIQCCgxdyRThQkPZjq95CWmg5MUFZJlNZxsn0QQAAAACAAGAgACEAgrF3JFOFCQxsn0QQ",
+			target: "AAAACCCCxyAAAA",
+		},
+		{
+			// (6, 0, 0)：超出旧镜像的部分按0计算
+			name:   "beyond base",
+			old:    "abc",
+			patch:  "QlNESUZGNDApAAAAAAAAACsAAAAAAAAABgAAAAAAAABCWmg5MUFZJlNZ93PT3gAAAmAAQQAIACAAMMwM9QXOLuSKcKEh7uenvEJaaDkxQVkmU1nPInGLAAAAQQBAAAcAIAAhmmgzTRLni7kinChIZ5E4xYBCWmg5F3JFOFCQAAAAAA==",
+			target: "abcdef",
+		},
+		{
+			name:   "empty target",
+			old:    "abc",
+			patch:  "QlNESUZGNDAOAAAAAAAAAA4AAAAAAAAAAAAAAAAAAABCWmg5F3JFOFCQAAAAAEJaaDkXckU4UJAAAAAAQlpoORdyRThQkAAAAAA=",
+			target: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := decodePatch(t, test.patch)
+			var target bytes.Buffer
+			err := bspatch(bytes.NewReader([]byte(test.old)), int64(len(test.old)), bytes.NewReader(patch), int64(len(patch)), &target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target.String() != test.target {
+				t.Fatalf("target = %q, want %q", target.String(), test.target)
+			}
+		})
+	}
+}
+
+func TestBspatchCorrupt(t *testing.T) {
+	valid := decodePatch(t, "QlNESUZGNDArAAAAAAAAACkAAAAAAAAAKgAAAAAAAABCWmg5MUFZJlNZs9RPoAAABdAATAgBACAAIYaBmgxWybi7kinChIWeon0AQlpoOTFBWSZTWckYgq4AAABQAGAAIAAgADDMDPUFzi7kinChIZIxBVxCWmg5MUFZJlNZnRGtggAAABGAQAACAQCAIAAhmmgzTRc8XckU4UJCdEa2CA==")
+	tests := []struct {
+		name    string
+		corrupt func(patch []byte) []byte
+	}{
+		{"short header", func(patch []byte) []byte { return patch[:20] }},
+		{"bad magic", func(patch []byte) []byte { patch[0] = 'X'; return patch }},
+		{"negative length", func(patch []byte) []byte { patch[15] |= 0x80; return patch }},
+		{"length exceeds patch", func(patch []byte) []byte { patch[16] = 0xff; return patch }},
+		{"target larger than control", func(patch []byte) []byte { patch[24] = 0x7f; return patch }},
+		{"truncated extra block", func(patch []byte) []byte { return patch[:len(patch)-30] }},
+	}
+	old := []byte("hello world, this is the base image v1")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := test.corrupt(append([]byte(nil), valid...))
+			var target bytes.Buffer
+			if err := bspatch(bytes.NewReader(old), int64(len(old)), bytes.NewReader(patch), int64(len(patch)), &target); err == nil {
+				t.Fatal("expect error for corrupt patch")
+			}
+		})
+	}
+}
+
+func decodePatch(t *testing.T, encoded string) []byte {
+	t.Helper()
+	patch, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return patch
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 安装完成等待确认时上报的进度
+const progressConfirm = 95
+
+// ErrNoPendingUpgrade 没有等待确认的升级
+var ErrNoPendingUpgrade = errors.New("no upgrade is waiting for confirmation")
+
+// pendingUpgrade 持久化的升级状态，安装前写入，确认结果上报成功后删除
+type pendingUpgrade struct {
+	Task            Task                   `json:"task"`
+	PreviousVersion string                 `json:"previous_version"`
+	Deadline        time.Time              `json:"deadline"`
+	Result          *model.UpgradeProgress `json:"result,omitempty"` // 已经确认或回滚，但还没有上报成功的结果
+}
+
+// Pending 返回等待确认的升级任务
+func (m *Manager) Pending() (Task, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.load()
+	if m.pending == nil || m.pending.Result != nil {
+		return Task{}, false
+	}
+	return m.pending.Task, true
+}
+
+// Persisted 是否存在持久化的升级记录，包括等待确认和已经确认但结果还没有上报成功的升级
+func (m *Manager) Persisted() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.load()
+	return m.pending != nil
+}
+
+// Recover 设备与平台建链后调用：上报之前未上报成功的确认结果。
+// 确认超时定时器在读取升级状态时已经启动，与是否建链无关
+func (m *Manager) Recover(report Reporter) {
+	m.lock.Lock()
+	m.load()
+	if report != nil {
+		m.reporter = report
+	}
+	if m.pending == nil || m.finalizing {
+		m.lock.Unlock()
+		return
+	}
+	if m.pending.Result == nil {
+		m.startTimer()
+		m.lock.Unlock()
+		return
+	}
+	m.finalizing = true
+	m.lock.Unlock()
+	m.deliver()
+}
+
+// startTimer 启动确认超时定时器，超时后回滚，调用时需要持有lock
+func (m *Manager) startTimer() {
+	if m.timer != nil {
+		return
+	}
+	wait := time.Until(m.pending.Deadline)
+	if wait < 0 {
+		wait = 0
+	}
+	m.logger().Info("wait for upgrade confirmation", logger.String("version", m.pending.Task.Info.Version), logger.Any("wait", wait))
+	m.timer = time.AfterFunc(wait, func() {
+		if err := m.Reject(errors.New("health confirmation timeout")); err != nil && !errors.Is(err, ErrNoPendingUpgrade) {
+			m.logger().Warn("reject upgrade failed", logger.Err(err))
+		}
+	})
+}
+
+// Confirm 新版本运行正常，上报升级成功
+func (m *Manager) Confirm() error {
+	m.lock.Lock()
+	m.load()
+	if m.pending == nil || m.pending.Result != nil {
+		m.lock.Unlock()
+		return ErrNoPendingUpgrade
+	}
+	m.stopTimer()
+	m.pending.Result = &model.UpgradeProgress{
+		ResultCode:  ResultSuccess,
+		Progress:    progressDone,
+		Version:     m.pending.Task.Info.Version,
+		Description: "upgrade success",
+	}
+	if err := m.save(); err != nil {
+		m.lock.Unlock()
+		return err
+	}
+	m.finalizing = true
+	version := m.pending.Task.Info.Version
+	m.lock.Unlock()
+
+	m.logger().Info("upgrade confirmed", logger.String("version", version))
+	m.deliver()
+	return nil
+}
+
+// Reject 新版本运行异常，执行Rollback并上报升级失败
+func (m *Manager) Reject(reason error) error {
+	m.lock.Lock()
+	m.load()
+	if m.pending == nil || m.pending.Result != nil {
+		m.lock.Unlock()
+		return ErrNoPendingUpgrade
+	}
+	m.stopTimer()
+	// 回滚过程中设备可能重启，先保存结果
+	m.pending.Result = &model.UpgradeProgress{
+		ResultCode:  ResultInstallFailed,
+		Version:     m.pending.PreviousVersion,
+		Description: reason.Error(),
+	}
+	if err := m.save(); err != nil {
+		m.lock.Unlock()
+		return err
+	}
+	// 回滚和上报时不持有lock，Rollback中可以调用Pending等方法
+	m.finalizing = true
+	pending := m.pending
+	task := pending.Task
+	m.lock.Unlock()
+
+	log := m.logger().With(logger.String("version", task.Info.Version))
+	log.Warn("upgrade rejected, begin to rollback", logger.Err(reason))
+	if m.options.Rollback != nil {
+		if err := m.options.Rollback(context.Background(), task); err != nil {
+			log.Error("rollback failed", logger.Err(err))
+			m.lock.Lock()
+			pending.Result.Description += ", rollback failed: " + err.Error()
+			err = m.save()
+			if err != nil {
+				m.finalizing = false
+			}
+			m.lock.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	m.deliver()
+	return nil
+}
+
+// awaitConfirm 安装完成，上报等待确认并开始计时
+func (m *Manager) awaitConfirm(log logger.Logger, task Task, report Reporter) model.UpgradeProgress {
+	progress := model.UpgradeProgress{
+		ResultCode:  ResultSuccess,
+		Progress:    progressConfirm,
+		Version:     m.currentVersion(task),
+		Description: "waiting for confirmation",
+	}
+	log.Info("upgrade installed, waiting for confirmation")
+	m.send(log, report, progress)
+	m.Recover(report)
+	return progress
+}
+
+func (m *Manager) savePending(task Task) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stopTimer()
+	m.loaded = true
+	m.pending = &pendingUpgrade{
+		Task:            task,
+		PreviousVersion: m.currentVersion(task),
+		Deadline:        time.Now().Add(m.options.ConfirmWindow),
+	}
+	return m.save()
+}
+
+func (m *Manager) clearPending(log logger.Logger) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stopTimer()
+	m.pending = nil
+	if err := os.Remove(m.options.StateFile); err != nil && !os.IsNotExist(err) {
+		log.Warn("remove upgrade state failed", logger.Err(err))
+	}
+}
+
+// deliver 上报确认结果，上报成功后删除升级状态，失败时等待下次Recover重新上报。
+// 调用方持有lock时将finalizing置为true，deliver在不持有lock的情况下上报，结束后重置finalizing
+func (m *Manager) deliver() {
+	m.lock.Lock()
+	pending, reporter := m.pending, m.reporter
+	if pending == nil || pending.Result == nil || reporter == nil {
+		m.finalizing = false
+		m.lock.Unlock()
+		return
+	}
+	result := *pending.Result
+	m.lock.Unlock()
+
+	err := reporter(result)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.finalizing = false
+	if err != nil {
+		m.logger().Warn("report upgrade result failed, retry after reconnect", logger.Err(err))
+		return
+	}
+	if m.pending != pending {
+		return
+	}
+	m.pending = nil
+	if err := os.Remove(m.options.StateFile); err != nil && !os.IsNotExist(err) {
+		m.logger().Warn("remove upgrade state failed", logger.Err(err))
+	}
+}
+
+func (m *Manager) stopTimer() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+}
+
+func (m *Manager) load() {
+	if m.loaded {
+		return
+	}
+	m.loaded = true
+	content, err := ioutil.ReadFile(m.options.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			m.logger().Warn("read upgrade state failed", logger.Err(err))
+		}
+		return
+	}
+	pending := &pendingUpgrade{}
+	if err := json.Unmarshal(content, pending); err != nil {
+		m.logger().Warn("unmarshal upgrade state failed", logger.Err(err))
+		return
+	}
+	m.pending = pending
+	if pending.Result == nil {
+		m.startTimer()
+	}
+}
+
+// save 先写临时文件再重命名，避免掉电导致状态文件损坏
+func (m *Manager) save() error {
+	content, err := json.Marshal(m.pending)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.options.StateFile), 0o755); err != nil {
+		return err
+	}
+	tmp := m.options.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.options.StateFile)
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ota
+
+import (
+	"context"
+	"errors"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newPendingManager 创建已经安装了v2、等待确认的Manager
+func newPendingManager(t *testing.T, rollback func(m *Manager) error) *Manager {
+	t.Helper()
+	var m *Manager
+	options := Options{
+		Dir:           t.TempDir(),
+		ConfirmWindow: time.Hour,
+		CurrentVersion: func(task Task) string {
+			return "v1"
+		},
+	}
+	if rollback != nil {
+		options.Rollback = func(ctx context.Context, task Task) error {
+			return rollback(m)
+		}
+	}
+	m = NewManager(options)
+	if err := m.savePending(Task{Info: model.UpgradeInfo{Version: "v2"}}); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestConfirmResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		rollback    func(m *Manager) error
+		reject      bool
+		resultCode  int
+		version     string
+		description string
+	}{
+		{name: "confirm", resultCode: ResultSuccess, version: "v2"},
+		{name: "reject", reject: true, resultCode: ResultInstallFailed, version: "v1", description: "unhealthy"},
+		{
+			name: "rollback calls manager",
+			rollback: func(m *Manager) error {
+				// 回滚时不持有lock，回调中可以查询和确认
+				if _, ok := m.Pending(); ok {
+					return errors.New("upgrade still pending during rollback")
+				}
+				if err := m.Confirm(); !errors.Is(err, ErrNoPendingUpgrade) {
+					return errors.New("confirm during rollback should fail")
+				}
+				return nil
+			},
+			reject: true, resultCode: ResultInstallFailed, version: "v1", description: "unhealthy",
+		},
+		{
+			name: "rollback failed",
+			rollback: func(m *Manager) error {
+				return errors.New("partition broken")
+			},
+			reject: true, resultCode: ResultInstallFailed, version: "v1", description: "rollback failed: partition broken",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newPendingManager(t, tt.rollback)
+			var reported []model.UpgradeProgress
+			m.Recover(func(progress model.UpgradeProgress) error {
+				reported = append(reported, progress)
+				return nil
+			})
+			done := make(chan error, 1)
+			go func() {
+				if tt.reject {
+					done <- m.Reject(errors.New("unhealthy"))
+				} else {
+					done <- m.Confirm()
+				}
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("confirm or reject blocked")
+			}
+			if len(reported) != 1 {
+				t.Fatalf("reported %d results, want 1", len(reported))
+			}
+			result := reported[0]
+			if result.ResultCode != tt.resultCode || result.Version != tt.version || !strings.Contains(result.Description, tt.description) {
+				t.Errorf("result = %+v, want code %d version %s description %q", result, tt.resultCode, tt.version, tt.description)
+			}
+			if m.Persisted() {
+				t.Error("upgrade state is kept after the result was reported")
+			}
+			if _, err := os.Stat(m.options.StateFile); !os.IsNotExist(err) {
+				t.Errorf("state file is not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfirmSlowReporter(t *testing.T) {
+	m := newPendingManager(t, nil)
+	release := make(chan struct{})
+	var lock sync.Mutex
+	var reported int
+	fail := true
+	m.Recover(func(progress model.UpgradeProgress) error {
+		<-release
+		lock.Lock()
+		defer lock.Unlock()
+		reported++
+		if fail {
+			return errors.New("not connected")
+		}
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Confirm()
+	}()
+	// 上报阻塞时其他调用不被阻塞，也不会重复上报
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := m.Pending(); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("confirm did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m.Recover(nil)
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if reported != 1 || !m.Persisted() {
+		t.Fatalf("reported %d times, persisted %v, want 1 and true", reported, m.Persisted())
+	}
+	// 上报失败后在重新建链时再次上报
+	lock.Lock()
+	fail = false
+	lock.Unlock()
+	m.Recover(nil)
+	if reported != 2 || m.Persisted() {
+		t.Fatalf("reported %d times, persisted %v, want 2 and false", reported, m.Persisted())
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ota
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"strings"
+)
+
+// 升级包类型
+const (
+	PackageTypeFull      = "full"       // 完整包
+	PackageTypeBsdiff    = "bsdiff"     // bsdiff生成的BSDIFF40格式差分包
+	PackageTypeZstdPatch = "zstd-patch" // zstd --patch-from生成的差分包
+)
+
+// DeltaInfo 差分包描述，创建升级包时以JSON格式填写在custom_info或task_ext_info中，如：
+// {"package_type":"bsdiff","base_version":"1.0","target_sha256":"...","target_size":83886080}
+type DeltaInfo struct {
+	PackageType  string `json:"package_type"`
+	BaseVersion  string `json:"base_version"`  // 差分包基于的版本，不为空时需要与当前版本一致
+	BaseSha256   string `json:"base_sha256"`   // 当前镜像的SHA-256，不为空时校验
+	TargetSha256 string `json:"target_sha256"` // 还原后镜像的SHA-256，必填
+	TargetSize   int64  `json:"target_size"`   // 还原后镜像的大小，不为0时校验
+}
+
+// ParseDeltaInfo 从升级信息中解析差分包描述，不是差分包时返回nil
+func ParseDeltaInfo(info model.UpgradeInfo) (*DeltaInfo, error) {
+	for _, raw := range []interface{}{info.CustomInfo, info.TaskExtInfo} {
+		var content []byte
+		switch value := raw.(type) {
+		case nil:
+			continue
+		case string:
+			content = []byte(value)
+		default:
+			marshaled, err := json.Marshal(value)
+			if err != nil {
+				continue
+			}
+			content = marshaled
+		}
+		delta := &DeltaInfo{}
+		// 自定义信息不一定是JSON
+		if json.Unmarshal(content, delta) != nil || len(delta.PackageType) == 0 || delta.PackageType == PackageTypeFull {
+			continue
+		}
+		if delta.PackageType != PackageTypeBsdiff && delta.PackageType != PackageTypeZstdPatch {
+			return nil, NewError(ResultNotSupported, fmt.Errorf("unsupported package type %s", delta.PackageType))
+		}
+		if len(delta.TargetSha256) != sha256.Size*2 {
+			return nil, NewError(ResultNotSupported, errors.New("target_sha256 of delta package is invalid"))
+		}
+		return delta, nil
+	}
+	return nil, nil
+}
+
+// applyDelta 将差分包应用到当前镜像，生成的新镜像写入targetPath并校验，maxZstdBase为zstd差分包允许读入内存的镜像大小
+func applyDelta(delta *DeltaInfo, basePath, patchPath, targetPath string, maxZstdBase int64) error {
+	base, err := os.Open(basePath)
+	if err != nil {
+		return NewError(ResultInternalError, fmt.Errorf("open base image failed: %w", err))
+	}
+	defer base.Close()
+	baseInfo, err := base.Stat()
+	if err != nil {
+		return NewError(ResultInternalError, err)
+	}
+	if delta.PackageType == PackageTypeZstdPatch && baseInfo.Size() > maxZstdBase {
+		return NewError(ResultNoMemory, fmt.Errorf("base image size %d exceeds zstd patch limit %d", baseInfo.Size(), maxZstdBase))
+	}
+	if len(delta.BaseSha256) != 0 {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, base); err != nil {
+			return NewError(ResultInternalError, fmt.Errorf("read base image failed: %w", err))
+		}
+		if actual := hex.EncodeToString(hash.Sum(nil)); actual != strings.ToLower(delta.BaseSha256) {
+			return NewError(ResultNotSupported, fmt.Errorf("base image mismatch, expect %s, actual %s", delta.BaseSha256, actual))
+		}
+	}
+	patch, err := os.Open(patchPath)
+	if err != nil {
+		return NewError(ResultInternalError, err)
+	}
+	defer patch.Close()
+	patchInfo, err := patch.Stat()
+	if err != nil {
+		return NewError(ResultInternalError, err)
+	}
+
+	target, err := os.Create(targetPath)
+	if err != nil {
+		return writeError(err)
+	}
+	hash := sha256.New()
+	counter := &progressWriter{}
+	writer := io.MultiWriter(target, hash, counter)
+	switch delta.PackageType {
+	case PackageTypeBsdiff:
+		err = bspatch(base, baseInfo.Size(), patch, patchInfo.Size(), writer)
+	case PackageTypeZstdPatch:
+		err = zstdPatch(base, baseInfo.Size(), maxZstdBase, patch, writer)
+	}
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(targetPath)
+		if isWriteError(err) {
+			return writeError(err)
+		}
+		return NewError(ResultVerifyFailed, fmt.Errorf("apply %s patch failed: %w", delta.PackageType, err))
+	}
+
+	if delta.TargetSize > 0 && counter.written != delta.TargetSize {
+		_ = os.Remove(targetPath)
+		return NewError(ResultVerifyFailed, fmt.Errorf("patched image size mismatch, expect %d, actual %d", delta.TargetSize, counter.written))
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != strings.ToLower(delta.TargetSha256) {
+		_ = os.Remove(targetPath)
+		return NewError(ResultVerifyFailed, fmt.Errorf("patched image sign mismatch, expect %s, actual %s", delta.TargetSha256, actual))
+	}
+	return nil
+}
+
+// zstdPatch 应用zstd --patch-from生成的差分包，当前镜像作为字典需要完整读入内存，调用方已经检查过大小。
+// 窗口大小按2的幂向上取整，解码窗口限制为maxBase的两倍，差分包声明更大的窗口时解码失败，不会分配更多内存
+func zstdPatch(baseFile io.ReaderAt, baseSize, maxBase int64, patch io.Reader, target io.Writer) error {
+	base := make([]byte, baseSize)
+	if _, err := io.ReadFull(io.NewSectionReader(baseFile, 0, baseSize), base); err != nil {
+		return fmt.Errorf("read base image failed: %w", err)
+	}
+	window := uint64(maxBase) * 2
+	if window < zstd.MinWindowSize {
+		window = zstd.MinWindowSize
+	}
+	decoder, err := zstd.NewReader(patch, zstd.WithDecoderDictRaw(0, base), zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(window))
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+	_, err = io.Copy(target, decoder)
+	return err
+}
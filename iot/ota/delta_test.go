@@ -0,0 +1,84 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ota
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/klauspost/compress/zstd"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyDeltaZstd(t *testing.T) {
+	base := []byte(strings.Repeat("base image content ", 512))
+	target := append(append([]byte(nil), base...), "new version"...)
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDictRaw(0, base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := encoder.EncodeAll(target, nil)
+	sum := sha256.Sum256(target)
+
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "base.img")
+	patchPath := filepath.Join(dir, "patch.zst")
+	if err := os.WriteFile(basePath, base, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(patchPath, patch, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		maxBase  int64
+		sha256   string
+		wantCode int
+	}{
+		{name: "success", maxBase: 64 << 20, sha256: hex.EncodeToString(sum[:]), wantCode: ResultSuccess},
+		{name: "base too large", maxBase: int64(len(base)) - 1, sha256: hex.EncodeToString(sum[:]), wantCode: ResultNoMemory},
+		{name: "sign mismatch", maxBase: 64 << 20, sha256: strings.Repeat("0", 64), wantCode: ResultVerifyFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targetPath := filepath.Join(dir, test.name+".img")
+			delta := &DeltaInfo{PackageType: PackageTypeZstdPatch, TargetSha256: test.sha256, TargetSize: int64(len(target))}
+			err := applyDelta(delta, basePath, patchPath, targetPath, test.maxBase)
+			if code := ResultCode(err, ResultInternalError); code != test.wantCode {
+				t.Fatalf("result code %d, want %d, err: %v", code, test.wantCode, err)
+			}
+			_, statErr := os.Stat(targetPath)
+			if (test.wantCode == ResultSuccess) != (statErr == nil) {
+				t.Fatalf("target exists: %v, want %v", statErr == nil, test.wantCode == ResultSuccess)
+			}
+		})
+	}
+}
@@ -0,0 +1,460 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ota
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/file"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/tlsconfig"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 各阶段对应的升级进度，下载阶段占0到80
+const (
+	progressDownloadEnd = 80
+	progressVerify      = 85
+	progressPatch       = 87
+	progressInstall     = 90
+	progressDone        = 100
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// Options 升级管理的配置
+type Options struct {
+	Dir             string                 // 升级包下载目录，默认为系统临时目录下的iot-ota
+	Installer       Installer              // 安装升级包，必须设置
+	HttpClient      *http.Client           // 下载升级包使用的http客户端，未设置时MqttDeviceClient按ConnectAuthConfig.TLS创建
+	DownloadTimeout time.Duration          // 下载超时时间，默认10分钟
+	ProgressStep    int                    // 下载进度每增加多少上报一次，默认10
+	CurrentVersion  func(task Task) string // 升级过程中和升级失败时上报的当前版本号
+	KeepPackage     bool                   // 安装完成后保留升级包，默认删除
+	Logger          logger.Logger          // 默认使用logger.Default()
+	// ConfirmWindow 大于0时启用升级确认：安装前持久化升级状态，设备需要在该时间内(包含安装和重启)调用Confirm，
+	// 超时或调用Reject时执行Rollback并上报升级失败
+	ConfirmWindow time.Duration
+	Rollback      func(ctx context.Context, task Task) error // 回滚到升级前的版本，如切换回原来的A/B分区
+	StateFile     string                                     // 升级状态文件，默认为Dir下的upgrade_state.json
+	// BaseImage 返回当前镜像文件的路径，差分升级时将差分包应用到该文件，未设置时不支持差分包
+	BaseImage func(task Task) (string, error)
+	// MaxZstdBaseSize zstd差分包需要将当前镜像完整读入内存，镜像超过该大小时上报内存不足，默认64MB
+	MaxZstdBaseSize int64
+	// AllowUnsigned 允许安装平台没有下发Sign的升级包，默认上报校验失败
+	AllowUnsigned bool
+}
+
+// Manager 执行升级任务，同一时间只执行一个升级任务
+type Manager struct {
+	options Options
+	running int32
+
+	lock          sync.Mutex
+	defaultClient bool            // HttpClient未由用户设置，可以通过UseTLS替换
+	pending       *pendingUpgrade // 等待确认的升级
+	loaded        bool            // 是否已经读取过升级状态文件
+	reporter      Reporter        // 上报确认结果
+	timer         *time.Timer     // 确认超时定时器
+	finalizing    bool            // 正在回滚或上报确认结果，期间不重复上报
+}
+
+func NewManager(options Options) *Manager {
+	if len(options.Dir) == 0 {
+		options.Dir = filepath.Join(os.TempDir(), "iot-ota")
+	}
+	defaultClient := options.HttpClient == nil
+	if defaultClient {
+		// 默认配置不加载证书文件，不会失败
+		options.HttpClient, _ = tlsconfig.Settings{}.HttpClient()
+	}
+	if options.DownloadTimeout <= 0 {
+		options.DownloadTimeout = 10 * time.Minute
+	}
+	if options.ProgressStep <= 0 {
+		options.ProgressStep = 10
+	}
+	if len(options.StateFile) == 0 {
+		options.StateFile = filepath.Join(options.Dir, "upgrade_state.json")
+	}
+	if options.MaxZstdBaseSize <= 0 {
+		options.MaxZstdBaseSize = 64 << 20
+	}
+	m := &Manager{options: options, defaultClient: defaultClient}
+	// 重启后立即读取升级状态并开始确认计时，设备无法建链时也能按时回滚
+	m.lock.Lock()
+	m.load()
+	m.lock.Unlock()
+	return m
+}
+
+// UseTLS 未设置Options.HttpClient时按settings创建下载升级包使用的http客户端，只生效一次，
+// MqttDeviceClient在升级前使用ConnectAuthConfig.TLS调用
+func (m *Manager) UseTLS(settings tlsconfig.Settings) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.defaultClient {
+		return nil
+	}
+	client, err := settings.HttpClient()
+	if err != nil {
+		return err
+	}
+	m.options.HttpClient = client
+	m.defaultClient = false
+	return nil
+}
+
+func (m *Manager) httpClient() *http.Client {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.options.HttpClient
+}
+
+// Upgrade 下载、校验并安装升级包，每个阶段都会通过report上报进度，返回最终上报的结果
+func (m *Manager) Upgrade(ctx context.Context, task Task, report Reporter) model.UpgradeProgress {
+	log := m.logger().With(logger.String("version", task.Info.Version), logger.Any("upgrade_type", task.UpgradeType))
+	if !atomic.CompareAndSwapInt32(&m.running, 0, 1) {
+		return m.finish(log, task, report, NewError(ResultDeviceBusy, errors.New("another upgrade is running")))
+	}
+	defer atomic.StoreInt32(&m.running, 0)
+	if _, ok := m.Pending(); ok {
+		return m.finish(log, task, report, NewError(ResultDeviceBusy, errors.New("previous upgrade is waiting for confirmation")))
+	}
+	if m.options.Installer == nil {
+		return m.finish(log, task, report, NewError(ResultInternalError, errors.New("installer is not set")))
+	}
+	// 下载前检查差分包能否应用到当前版本
+	delta, err := ParseDeltaInfo(task.Info)
+	if err == nil && delta != nil {
+		err = m.checkDelta(task, delta)
+	}
+	if err != nil {
+		return m.finish(log, task, report, err)
+	}
+
+	log.Info("begin to download upgrade package")
+	pkg, err := m.download(ctx, task, func(percent int) {
+		m.report(log, task, report, percent, "downloading")
+	})
+	if err != nil {
+		return m.finish(log, task, report, err)
+	}
+	if !m.options.KeepPackage {
+		defer func() {
+			if err := os.Remove(pkg.path); err != nil && !os.IsNotExist(err) {
+				log.Warn("remove upgrade package failed", logger.Err(err))
+			}
+		}()
+	}
+
+	m.report(log, task, report, progressVerify, "verifying")
+	if err := verify(task.Info, pkg, m.options.AllowUnsigned); err != nil {
+		return m.finish(log, task, report, err)
+	}
+
+	packagePath := pkg.path
+	if delta != nil {
+		m.report(log, task, report, progressPatch, "patching")
+		if packagePath, err = m.patch(task, delta, pkg.path); err != nil {
+			return m.finish(log, task, report, err)
+		}
+		if !m.options.KeepPackage {
+			defer func() {
+				if err := os.Remove(packagePath); err != nil && !os.IsNotExist(err) {
+					log.Warn("remove patched image failed", logger.Err(err))
+				}
+			}()
+		}
+	}
+
+	m.report(log, task, report, progressInstall, "installing")
+	// 安装过程中设备可能重启，需要先保存升级状态
+	if m.options.ConfirmWindow > 0 {
+		if err := m.savePending(task); err != nil {
+			return m.finish(log, task, report, NewError(ResultInternalError, err))
+		}
+	}
+	if err := m.options.Installer.Install(ctx, task, packagePath); err != nil {
+		m.clearPending(log)
+		return m.finish(log, task, report, NewError(ResultCode(err, ResultInstallFailed), err))
+	}
+	if m.options.ConfirmWindow > 0 {
+		return m.awaitConfirm(log, task, report)
+	}
+	return m.finish(log, task, report, nil)
+}
+
+func (m *Manager) checkDelta(task Task, delta *DeltaInfo) error {
+	if m.options.BaseImage == nil {
+		return NewError(ResultNotSupported, errors.New("delta package is not supported, base image is not set"))
+	}
+	if current := m.currentVersion(task); len(delta.BaseVersion) != 0 && len(current) != 0 && current != delta.BaseVersion {
+		return NewError(ResultNotSupported, fmt.Errorf("delta package is based on version %s, current version is %s", delta.BaseVersion, current))
+	}
+	return nil
+}
+
+// patch 将差分包应用到当前镜像，返回新镜像的路径
+func (m *Manager) patch(task Task, delta *DeltaInfo, patchPath string) (string, error) {
+	basePath, err := m.options.BaseImage(task)
+	if err != nil {
+		return "", NewError(ResultInternalError, fmt.Errorf("get base image failed: %w", err))
+	}
+	targetPath := patchPath + ".img"
+	if err := applyDelta(delta, basePath, patchPath, targetPath, m.options.MaxZstdBaseSize); err != nil {
+		return "", err
+	}
+	return targetPath, nil
+}
+
+// downloaded 下载完成的升级包
+type downloaded struct {
+	path   string
+	size   int64
+	md5    string
+	sha256 string
+}
+
+func (m *Manager) download(ctx context.Context, task Task, onProgress func(percent int)) (downloaded, error) {
+	if err := os.MkdirAll(m.options.Dir, 0o755); err != nil {
+		return downloaded{}, writeError(err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.options.DownloadTimeout)
+	defer cancel()
+	header := http.Header{}
+	// 升级包存放在平台时需要携带token，存放在OBS时url已经签名
+	if task.UpgradeType == UpgradeTypeSoftware || task.UpgradeType == UpgradeTypeFirmware {
+		header.Set("Content-Type", "text/plain")
+		if len(task.Info.AccessToken) != 0 {
+			header.Set("Authorization", "Bearer "+task.Info.AccessToken)
+		}
+	}
+	path := filepath.Join(m.options.Dir, packageName(task))
+	progress := &progressWriter{total: int64(task.Info.FileSize), step: m.options.ProgressStep, onProgress: onProgress}
+	// 与文件下载共用分段续传和重试，中断的下载保留在path.part，同一升级任务再次下发时继续下载
+	err := file.DownloadFileWithHeader(ctx, m.httpClient(), path, task.Info.Url, header, file.TransferOptions{
+		Progress: progress.update,
+	})
+	if err != nil {
+		return downloaded{}, downloadError(err)
+	}
+	if progress.last < progressDownloadEnd {
+		onProgress(progressDownloadEnd)
+	}
+	return digest(path)
+}
+
+// downloadError 区分写文件失败、服务端拒绝下载和网络超时
+func downloadError(err error) error {
+	if isWriteError(err) {
+		return writeError(err)
+	}
+	// 平台没有对应的结果码，401、403、404等不可重试的状态码按内部错误上报，避免被当作下载超时
+	if status := file.HttpStatus(err); status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+		return NewError(ResultInternalError, err)
+	}
+	return NewError(ResultDownloadTimeout, err)
+}
+
+// digest 计算下载完成的升级包的大小和摘要
+func digest(path string) (downloaded, error) {
+	pkg, err := os.Open(path)
+	if err != nil {
+		return downloaded{}, writeError(err)
+	}
+	defer pkg.Close()
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), pkg)
+	if err != nil {
+		return downloaded{}, writeError(err)
+	}
+	return downloaded{
+		path:   path,
+		size:   size,
+		md5:    hex.EncodeToString(md5Hash.Sum(nil)),
+		sha256: hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
+
+// packageName 升级包文件名包含任务ID、版本号和Sign的摘要，同时执行的不同升级任务不会使用同一个文件
+func packageName(task Task) string {
+	sign := sha256.Sum256([]byte(task.Info.Sign))
+	name := "upgrade_" + task.Info.TaskId + "_" + task.Info.Version + "_" + hex.EncodeToString(sign[:])[:12]
+	return unsafeFileChars.ReplaceAllString(name, "_")
+}
+
+// verify 校验升级包大小和摘要，Sign为32位时按MD5校验，64位时按SHA-256校验，为空时只有allowUnsigned为true才通过
+func verify(info model.UpgradeInfo, pkg downloaded, allowUnsigned bool) error {
+	if info.FileSize > 0 && int64(info.FileSize) != pkg.size {
+		return NewError(ResultVerifyFailed, fmt.Errorf("file size mismatch, expect %d, actual %d", info.FileSize, pkg.size))
+	}
+	sign := strings.ToLower(strings.TrimSpace(info.Sign))
+	var actual string
+	switch len(sign) {
+	case 0:
+		if allowUnsigned {
+			return nil
+		}
+		return NewError(ResultVerifyFailed, errors.New("upgrade package is not signed"))
+	case md5.Size * 2:
+		actual = pkg.md5
+	case sha256.Size * 2:
+		actual = pkg.sha256
+	default:
+		return NewError(ResultVerifyFailed, fmt.Errorf("unsupported sign %s", info.Sign))
+	}
+	if actual != sign {
+		return NewError(ResultVerifyFailed, fmt.Errorf("sign mismatch, expect %s, actual %s", sign, actual))
+	}
+	return nil
+}
+
+func (m *Manager) report(log logger.Logger, task Task, report Reporter, percent int, description string) {
+	m.send(log, report, model.UpgradeProgress{
+		ResultCode:  ResultSuccess,
+		Progress:    percent,
+		Version:     m.currentVersion(task),
+		Description: description,
+	})
+}
+
+func (m *Manager) finish(log logger.Logger, task Task, report Reporter, err error) model.UpgradeProgress {
+	progress := model.UpgradeProgress{
+		ResultCode:  ResultSuccess,
+		Progress:    progressDone,
+		Version:     task.Info.Version,
+		Description: "upgrade success",
+	}
+	if err != nil {
+		log.Warn("upgrade failed", logger.Err(err))
+		progress = model.UpgradeProgress{
+			ResultCode:  ResultCode(err, ResultInternalError),
+			Version:     m.currentVersion(task),
+			Description: err.Error(),
+		}
+	} else {
+		log.Info("upgrade success")
+	}
+	m.send(log, report, progress)
+	return progress
+}
+
+func (m *Manager) send(log logger.Logger, report Reporter, progress model.UpgradeProgress) {
+	if report == nil {
+		return
+	}
+	if err := report(progress); err != nil {
+		log.Warn("report upgrade progress failed", logger.Any("progress", progress.Progress), logger.Err(err))
+	}
+}
+
+func (m *Manager) currentVersion(task Task) string {
+	if m.options.CurrentVersion == nil {
+		return ""
+	}
+	return m.options.CurrentVersion(task)
+}
+
+func (m *Manager) logger() logger.Logger {
+	if m.options.Logger == nil {
+		return logger.Default()
+	}
+	return m.options.Logger
+}
+
+// progressWriter 统计下载的字节数，下载进度每增加step上报一次
+type progressWriter struct {
+	total      int64
+	written    int64
+	step       int
+	last       int
+	onProgress func(percent int)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.update(w.written+int64(len(p)), w.total)
+	return len(p), nil
+}
+
+// update 更新已下载的字节数，升级信息中没有文件大小时使用服务端返回的大小，都未知时不上报进度
+func (w *progressWriter) update(written, total int64) {
+	w.written = written
+	if w.total <= 0 {
+		w.total = total
+	}
+	if w.total <= 0 {
+		return
+	}
+	percent := int(w.written * progressDownloadEnd / w.total)
+	if percent > progressDownloadEnd {
+		percent = progressDownloadEnd
+	}
+	if percent >= w.last+w.step {
+		w.last = percent
+		w.onProgress(percent)
+	}
+}
+
+// isWriteError 区分本地写文件失败和网络读取失败
+func isWriteError(err error) bool {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// writeError 写文件失败，空间不足时结果码为5
+func writeError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return NewError(ResultNoSpace, err)
+	}
+	return NewError(ResultInternalError, err)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ota
+
+import (
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	pkg := downloaded{
+		size:   5,
+		md5:    "5d41402abc4b2a76b9719d911017c592",
+		sha256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+	}
+	tests := []struct {
+		name          string
+		info          model.UpgradeInfo
+		allowUnsigned bool
+		ok            bool
+	}{
+		{name: "md5", info: model.UpgradeInfo{FileSize: 5, Sign: pkg.md5}, ok: true},
+		{name: "sha256 upper case", info: model.UpgradeInfo{Sign: " " + strings.ToUpper(pkg.sha256) + " "}, ok: true},
+		{name: "sign mismatch", info: model.UpgradeInfo{Sign: strings.Repeat("0", 32)}},
+		{name: "unsupported sign", info: model.UpgradeInfo{Sign: "abc"}},
+		{name: "size mismatch", info: model.UpgradeInfo{FileSize: 6, Sign: pkg.md5}},
+		{name: "unsigned rejected", info: model.UpgradeInfo{FileSize: 5}},
+		{name: "unsigned allowed", info: model.UpgradeInfo{FileSize: 5}, allowUnsigned: true, ok: true},
+		{name: "allow unsigned still checks sign", info: model.UpgradeInfo{Sign: strings.Repeat("0", 64)}, allowUnsigned: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verify(tt.info, pkg, tt.allowUnsigned)
+			if (err == nil) != tt.ok {
+				t.Fatalf("verify() = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil && ResultCode(err, ResultInternalError) != ResultVerifyFailed {
+				t.Errorf("result code = %d, want %d", ResultCode(err, ResultInternalError), ResultVerifyFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package ota 软固件升级，负责下载升级包、校验升级包、调用Installer安装并向平台上报升级进度
+package ota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+)
+
+// 升级类型，与平台下发的升级事件对应
+const (
+	UpgradeTypeSoftware    byte = 0 // software_upgrade
+	UpgradeTypeFirmware    byte = 1 // firmware_upgrade
+	UpgradeTypeObsSoftware byte = 2 // software_upgrade_v2，升级包存放在OBS
+	UpgradeTypeObsFirmware byte = 3 // firmware_upgrade_v2，升级包存放在OBS
+)
+
+// 升级结果码，见model.UpgradeProgress
+const (
+	ResultSuccess         = 0
+	ResultDeviceBusy      = 1
+	ResultNoSpace         = 5
+	ResultDownloadTimeout = 6
+	ResultVerifyFailed    = 7
+	ResultNotSupported    = 8
+	ResultNoMemory        = 9
+	ResultInstallFailed   = 10
+	ResultInternalError   = 255
+)
+
+// Task 一次升级任务
+type Task struct {
+	UpgradeType byte
+	Info        model.UpgradeInfo
+}
+
+// IsFirmware 是否为固件升级
+func (task Task) IsFirmware() bool {
+	return task.UpgradeType == UpgradeTypeFirmware || task.UpgradeType == UpgradeTypeObsFirmware
+}
+
+// Installer 安装已经下载并校验通过的升级包，差分升级时packagePath为还原后的完整镜像，返回Error可以指定上报给平台的结果码，其他错误按安装失败处理
+type Installer interface {
+	Install(ctx context.Context, task Task, packagePath string) error
+}
+
+// InstallerFunc 函数形式的Installer
+type InstallerFunc func(ctx context.Context, task Task, packagePath string) error
+
+func (f InstallerFunc) Install(ctx context.Context, task Task, packagePath string) error {
+	return f(ctx, task, packagePath)
+}
+
+// Reporter 向平台上报升级进度
+type Reporter func(progress model.UpgradeProgress) error
+
+// Error 升级失败的原因，Code为上报给平台的结果码
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("upgrade failed, result code: %d", e.Code)
+	}
+	return fmt.Sprintf("upgrade failed, result code: %d, err: %s", e.Code, e.Err.Error())
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NewError(code int, err error) error {
+	return &Error{Code: code, Err: err}
+}
+
+// ResultCode 错误对应的结果码，未指定结果码的错误返回defaultCode
+func ResultCode(err error, defaultCode int) int {
+	if err == nil {
+		return ResultSuccess
+	}
+	var upgradeErr *Error
+	if errors.As(err, &upgradeErr) {
+		return upgradeErr.Code
+	}
+	return defaultCode
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package ota
+
+import (
+	"context"
+	"errors"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/tlsconfig"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SubDeviceInstaller 网关将升级包安装到子设备，如通过串口或局域网传输给子设备
+type SubDeviceInstaller interface {
+	Install(ctx context.Context, deviceId string, task Task, packagePath string) error
+}
+
+// SubDeviceInstallerFunc 函数形式的SubDeviceInstaller
+type SubDeviceInstallerFunc func(ctx context.Context, deviceId string, task Task, packagePath string) error
+
+func (f SubDeviceInstallerFunc) Install(ctx context.Context, deviceId string, task Task, packagePath string) error {
+	return f(ctx, deviceId, task, packagePath)
+}
+
+// SubDeviceOptions 子设备升级的配置
+type SubDeviceOptions struct {
+	Dir             string                                  // 升级包下载目录，默认为系统临时目录下的iot-ota/sub-devices
+	Installer       SubDeviceInstaller                      // 安装子设备升级包，必须设置
+	HttpClient      *http.Client                            // 下载升级包使用的http客户端，未设置时MqttDeviceClient按ConnectAuthConfig.TLS创建
+	DownloadTimeout time.Duration                           // 下载超时时间，默认10分钟
+	ProgressStep    int                                     // 下载进度每增加多少上报一次，默认10
+	Concurrency     int                                     // 同时安装的子设备数量，默认4
+	Retention       time.Duration                           // 所有子设备安装完成后升级包的保留时间，期间下发的同一升级任务不再重复下载，默认5分钟
+	CurrentVersion  func(deviceId string, task Task) string // 子设备升级过程中和升级失败时上报的当前版本号
+	Logger          logger.Logger                           // 默认使用logger.Default()
+	AllowUnsigned   bool                                    // 允许安装平台没有下发Sign的升级包，默认上报校验失败
+}
+
+// SubDeviceManager 网关为子设备执行升级任务：同一升级包只下载一次，多个子设备共用，并限制同时安装的子设备数量
+type SubDeviceManager struct {
+	options    SubDeviceOptions
+	downloader *Manager
+	slots      chan struct{}
+
+	lock     sync.Mutex
+	packages map[string]*sharedPackage
+	running  map[string]bool // 正在升级的子设备
+
+	// ctx 共用升级包的下载不随单个子设备取消，只在Close时取消
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// sharedPackage 多个子设备共用的升级包
+type sharedPackage struct {
+	done      chan struct{}
+	pkg       downloaded
+	err       error
+	refs      int
+	listeners map[string]func(percent int)
+	timer     *time.Timer
+}
+
+func NewSubDeviceManager(options SubDeviceOptions) *SubDeviceManager {
+	if len(options.Dir) == 0 {
+		options.Dir = filepath.Join(os.TempDir(), "iot-ota", "sub-devices")
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 4
+	}
+	if options.Retention <= 0 {
+		options.Retention = 5 * time.Minute
+	}
+	if options.Logger == nil {
+		options.Logger = logger.Default()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SubDeviceManager{
+		options: options,
+		downloader: NewManager(Options{
+			Dir:             options.Dir,
+			HttpClient:      options.HttpClient,
+			DownloadTimeout: options.DownloadTimeout,
+			ProgressStep:    options.ProgressStep,
+			Logger:          options.Logger,
+		}),
+		slots:    make(chan struct{}, options.Concurrency),
+		packages: make(map[string]*sharedPackage),
+		running:  make(map[string]bool),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// UseTLS 未设置HttpClient时按settings创建下载升级包使用的http客户端，同Manager.UseTLS
+func (m *SubDeviceManager) UseTLS(settings tlsconfig.Settings) error {
+	return m.downloader.UseTLS(settings)
+}
+
+// Close 取消正在进行的升级包下载，等待中的子设备升级失败
+func (m *SubDeviceManager) Close() {
+	m.cancel()
+}
+
+// Upgrade 为子设备执行升级任务，通过report上报该子设备的升级进度，返回最终上报的结果
+func (m *SubDeviceManager) Upgrade(ctx context.Context, deviceId string, task Task, report Reporter) model.UpgradeProgress {
+	log := m.options.Logger.With(logger.DeviceId(deviceId), logger.String("version", task.Info.Version))
+	if !m.begin(deviceId) {
+		return m.finish(log, deviceId, task, report, NewError(ResultDeviceBusy, errors.New("another upgrade is running")))
+	}
+	defer m.end(deviceId)
+	if m.options.Installer == nil {
+		return m.finish(log, deviceId, task, report, NewError(ResultInternalError, errors.New("installer is not set")))
+	}
+
+	shared, key := m.acquire(deviceId, task, func(percent int) {
+		m.report(log, deviceId, task, report, percent, "downloading")
+	})
+	defer m.release(key, deviceId, shared)
+	select {
+	case <-shared.done:
+	case <-ctx.Done():
+		return m.finish(log, deviceId, task, report, NewError(ResultDownloadTimeout, ctx.Err()))
+	}
+	if shared.err != nil {
+		return m.finish(log, deviceId, task, report, shared.err)
+	}
+	m.report(log, deviceId, task, report, progressVerify, "verified")
+
+	// 等待空闲的安装槽位
+	select {
+	case m.slots <- struct{}{}:
+	case <-ctx.Done():
+		return m.finish(log, deviceId, task, report, NewError(ResultInternalError, ctx.Err()))
+	}
+	defer func() { <-m.slots }()
+	m.report(log, deviceId, task, report, progressInstall, "installing")
+	if err := m.options.Installer.Install(ctx, deviceId, task, shared.pkg.path); err != nil {
+		return m.finish(log, deviceId, task, report, NewError(ResultCode(err, ResultInstallFailed), err))
+	}
+	return m.finish(log, deviceId, task, report, nil)
+}
+
+func (m *SubDeviceManager) begin(deviceId string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.running[deviceId] {
+		return false
+	}
+	m.running[deviceId] = true
+	return true
+}
+
+func (m *SubDeviceManager) end(deviceId string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.running, deviceId)
+}
+
+// acquire 获取升级包，同一升级任务只有第一个子设备触发下载，其他子设备等待下载完成
+func (m *SubDeviceManager) acquire(deviceId string, task Task, onProgress func(percent int)) (*sharedPackage, string) {
+	key := task.Info.TaskId + "/" + task.Info.Version + "/" + task.Info.Sign
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	shared, ok := m.packages[key]
+	if ok {
+		shared.refs++
+		if shared.timer != nil {
+			shared.timer.Stop()
+			shared.timer = nil
+		}
+		select {
+		case <-shared.done:
+		default:
+			shared.listeners[deviceId] = onProgress
+		}
+		return shared, key
+	}
+	shared = &sharedPackage{
+		done:      make(chan struct{}),
+		refs:      1,
+		listeners: map[string]func(percent int){deviceId: onProgress},
+	}
+	m.packages[key] = shared
+	// 下载不随单个子设备的ctx取消
+	go func() {
+		pkg, err := m.downloader.download(m.ctx, task, func(percent int) {
+			m.lock.Lock()
+			listeners := make([]func(percent int), 0, len(shared.listeners))
+			for _, listener := range shared.listeners {
+				listeners = append(listeners, listener)
+			}
+			m.lock.Unlock()
+			for _, listener := range listeners {
+				listener(percent)
+			}
+		})
+		if err == nil {
+			if err = verify(task.Info, pkg, m.options.AllowUnsigned); err != nil {
+				_ = os.Remove(pkg.path)
+			}
+		}
+		m.lock.Lock()
+		shared.pkg, shared.err = pkg, err
+		shared.listeners = nil
+		if err != nil {
+			// 下载失败时不保留，之后的子设备重新下载
+			delete(m.packages, key)
+		}
+		close(shared.done)
+		// 等待的子设备都已经取消
+		if shared.refs == 0 {
+			m.retain(key, shared)
+		}
+		m.lock.Unlock()
+	}()
+	return shared, key
+}
+
+func (m *SubDeviceManager) release(key, deviceId string, shared *sharedPackage) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(shared.listeners, deviceId)
+	shared.refs--
+	if shared.refs > 0 {
+		return
+	}
+	select {
+	case <-shared.done:
+		m.retain(key, shared)
+	default:
+		// 下载还没有完成，下载完成后再清理
+	}
+}
+
+// retain 所有子设备安装完成后，升级包保留Retention时间，调用时需要持有锁
+func (m *SubDeviceManager) retain(key string, shared *sharedPackage) {
+	if shared.err != nil {
+		return
+	}
+	shared.timer = time.AfterFunc(m.options.Retention, func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		if shared.refs > 0 || m.packages[key] != shared {
+			return
+		}
+		delete(m.packages, key)
+		if err := os.Remove(shared.pkg.path); err != nil && !os.IsNotExist(err) {
+			m.options.Logger.Warn("remove upgrade package failed", logger.String("path", shared.pkg.path), logger.Err(err))
+		}
+	})
+}
+
+func (m *SubDeviceManager) report(log logger.Logger, deviceId string, task Task, report Reporter, percent int, description string) {
+	m.downloader.send(log, report, model.UpgradeProgress{
+		ResultCode:  ResultSuccess,
+		Progress:    percent,
+		Version:     m.currentVersion(deviceId, task),
+		Description: description,
+	})
+}
+
+func (m *SubDeviceManager) finish(log logger.Logger, deviceId string, task Task, report Reporter, err error) model.UpgradeProgress {
+	progress := model.UpgradeProgress{
+		ResultCode:  ResultSuccess,
+		Progress:    progressDone,
+		Version:     task.Info.Version,
+		Description: "upgrade success",
+	}
+	if err != nil {
+		log.Warn("sub device upgrade failed", logger.Err(err))
+		progress = model.UpgradeProgress{
+			ResultCode:  ResultCode(err, ResultInternalError),
+			Version:     m.currentVersion(deviceId, task),
+			Description: err.Error(),
+		}
+	} else {
+		log.Info("sub device upgrade success")
+	}
+	m.downloader.send(log, report, progress)
+	return progress
+}
+
+func (m *SubDeviceManager) currentVersion(deviceId string, task Task) string {
+	if m.options.CurrentVersion == nil {
+		return ""
+	}
+	return m.options.CurrentVersion(deviceId, task)
+}
@@ -30,20 +30,46 @@ package rule
 
 import (
 	"github.com/go-co-op/gocron"
-	"github.com/golang/glog"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/callback"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
 	uuid "github.com/satori/go.uuid"
 	"strings"
 	"time"
 )
 
+// TimerRuleInstance 一条定时规则的调度器
 type TimerRuleInstance struct {
 	schedule *gocron.Scheduler
 	jobMap   map[string]*gocron.Job
 }
 
+// newTimerRuleInstance 为包含DAILY_TIMER或SIMPLE_TIMER条件的规则创建调度器，不是定时规则时返回nil
+func newTimerRuleInstance(ruleInfo model.RuleInfo, handler callback.RuleActionHandler) *TimerRuleInstance {
+	conditionList := ruleInfo.Conditions
+	isTimerRule := false
+	for _, condition := range conditionList {
+		if strings.EqualFold("DAILY_TIMER", condition.Type) || strings.EqualFold("SIMPLE_TIMER", condition.Type) {
+			isTimerRule = true
+			break
+		}
+	}
+	if !isTimerRule {
+		return nil
+	}
+	if len(conditionList) > 1 && strings.EqualFold("and", ruleInfo.Logic) {
+		logger.Default().Warn("multy timer rule only support or logic", logger.String("rule_id", ruleInfo.RuleId))
+		return nil
+	}
+	timerRule := &TimerRuleInstance{
+		schedule: gocron.NewScheduler(time.UTC),
+		jobMap:   make(map[string]*gocron.Job),
+	}
+	timerRule.submitRule(ruleInfo, handler)
+	return timerRule
+}
+
 func (timerRule *TimerRuleInstance) submitRule(ruleInfo model.RuleInfo, handler callback.RuleActionHandler) {
 	conditions := ruleInfo.Conditions
 	for _, condition := range conditions {
@@ -59,17 +85,17 @@ func (timerRule *TimerRuleInstance) handlerTimerRule(condition model.Condition,
 	executeTime := condition.Time
 	daysOfWeek := condition.DaysOfWeek
 	if len(executeTime) == 0 || len(daysOfWeek) == 0 {
-		glog.Warning("time or days of week is empty. time: %s, days of week: %s", executeTime, daysOfWeek)
+		logger.Default().Warn("time or days of week is empty", logger.String("time", executeTime), logger.String("days_of_week", daysOfWeek))
 		return
 	}
 	timeList, err := convertString2intList(executeTime)
 	if err != nil || len(timeList) != 2 {
-		glog.Warningf("time format is invalid. time: %s", timeList)
+		logger.Default().Warn("time format is invalid", logger.String("time", executeTime))
 		return
 	}
 	weekList, err := convertString2intList(daysOfWeek)
 	if err != nil || len(timeList) == 0 {
-		glog.Warningf("week format is invalid. time: %s", timeList)
+		logger.Default().Warn("week format is invalid", logger.String("days_of_week", daysOfWeek))
 		return
 	}
 
@@ -78,14 +104,14 @@ func (timerRule *TimerRuleInstance) handlerTimerRule(condition model.Condition,
 	for _, week := range weekList {
 		_, err := timerRule.schedule.Every(1).Weekday(time.Weekday(week)).At(executeTime).Do(func() {
 			if checkTimeRange(ruleInfo.TimeRange) {
-				handler(ruleInfo.Actions)
+				handler(ruleActions(ruleInfo))
 			}
 		})
 		if err != nil {
-			glog.Warningf("create schedule failed. err: %s", err)
+			logger.Default().Warn("create schedule failed", logger.Err(err))
 			continue
 		}
-		glog.Infof("add rule schedule daily timer job success.")
+		logger.Default().Info("add rule schedule daily timer job success", logger.String("rule_id", ruleInfo.RuleId))
 	}
 }
 
@@ -94,7 +120,7 @@ func (timerRule *TimerRuleInstance) handlerSimpleRule(condition model.Condition,
 	count := condition.RepeatCount
 	startTime, err := iot.GetDateTime(condition.StartTime)
 	if err != nil {
-		glog.Warningf("rule start time is invalid. time: %s, err: %s", condition.StartTime, err)
+		logger.Default().Warn("rule start time is invalid", logger.String("time", condition.StartTime), logger.Err(err))
 		return
 	}
 	jobId := uuid.NewV4().String()
@@ -105,7 +131,7 @@ func (timerRule *TimerRuleInstance) handlerSimpleRule(condition model.Condition,
 		}
 		currentJob, exist := timerRule.jobMap[jobId]
 		if !exist {
-			glog.Warningf("job not found. jobId: %s", jobId)
+			logger.Default().Warn("job not found", logger.String("job_id", jobId))
 			return
 		}
 		nextTime := currentJob.LastRun()
@@ -114,24 +140,25 @@ func (timerRule *TimerRuleInstance) handlerSimpleRule(condition model.Condition,
 			jobStartTime = nextTime
 		}
 		if jobStartTime.After(startTime) {
-			glog.Warningf("job was timeout. jobName: %s", jobId)
+			logger.Default().Warn("job was timeout", logger.String("job_id", jobId))
 			return
 		}
-		handler(ruleInfo.Actions)
+		handler(ruleActions(ruleInfo))
 	})
 	if err != nil {
-		glog.Warningf("create schedule failed. err: %s", err)
+		logger.Default().Warn("create schedule failed", logger.Err(err))
 		return
 	}
 	job.Name(jobId)
 	timerRule.jobMap[jobId] = job
-	glog.Infof("add rule schedule simple timer job success.")
+	logger.Default().Info("add rule schedule simple timer job success", logger.String("rule_id", ruleInfo.RuleId))
 }
 
 func (timerRule *TimerRuleInstance) Start() {
 	timerRule.schedule.StartAsync()
 }
 
+// ShutdownTimer 停止调度器，等待正在执行的任务结束
 func (timerRule *TimerRuleInstance) ShutdownTimer() {
 	timerRule.schedule.Stop()
 }
@@ -0,0 +1,288 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rule
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 规则动作类型，对应Action.Type
+const (
+	ActionTypeDeviceCommand = "DEVICE_CMD"      // 执行本设备或网关子设备的命令
+	ActionTypeProperty      = "DEVICE_PROPERTY" // 上报属性
+	ActionTypeMessage       = "DEVICE_MESSAGE"  // 上报消息
+	ActionTypeFunction      = "FUNCTION"        // 调用注册的本地函数
+	ActionTypeHttp          = "HTTP"            // 调用本机的http接口
+)
+
+const defaultActionTimeout = 10 * time.Second
+
+// ErrActionNotSupported 没有找到规则动作类型对应的执行器
+var ErrActionNotSupported = errors.New("rule action type is not supported")
+
+// ActionExecutor 执行一个规则动作，返回的错误记录在动作的执行结果中
+type ActionExecutor func(ctx context.Context, action model.Action) error
+
+// ActionFunction FUNCTION类型的动作调用的本地函数，params为RuleFunction.Params
+type ActionFunction func(ctx context.Context, params interface{}) error
+
+// ActionExecutors 按Action.Type分发规则动作，注册的执行器优先于sdk内置的执行器。
+// 规则执行时也可以注册和删除执行器
+type ActionExecutors struct {
+	Timeout    time.Duration // 单个动作的超时时间，默认10s
+	HttpClient *http.Client  // HTTP动作使用的客户端，为空时使用默认客户端
+	HttpHosts  []string      // HTTP动作除本机地址外允许调用的主机
+
+	lock      sync.RWMutex
+	executors map[string]ActionExecutor
+	functions map[string]ActionFunction
+}
+
+func NewActionExecutors() *ActionExecutors {
+	return &ActionExecutors{
+		Timeout:   defaultActionTimeout,
+		executors: make(map[string]ActionExecutor),
+		functions: make(map[string]ActionFunction),
+	}
+}
+
+// Register 注册actionType的执行器，已经存在时覆盖，executor为空时删除
+func (executors *ActionExecutors) Register(actionType string, executor ActionExecutor) {
+	executors.lock.Lock()
+	defer executors.lock.Unlock()
+	actionType = strings.ToUpper(actionType)
+	if executor == nil {
+		delete(executors.executors, actionType)
+		return
+	}
+	executors.executors[actionType] = executor
+}
+
+// RegisterFunction 注册FUNCTION类型的动作可以调用的函数，function为空时删除
+func (executors *ActionExecutors) RegisterFunction(name string, function ActionFunction) {
+	executors.lock.Lock()
+	defer executors.lock.Unlock()
+	if function == nil {
+		delete(executors.functions, name)
+		return
+	}
+	executors.functions[name] = function
+}
+
+// Execute 依次执行规则动作并返回每个动作的结果。
+// defaults为调用方提供的内置执行器，注册的执行器优先，都没有时该动作执行失败
+func (executors *ActionExecutors) Execute(ctx context.Context, actions []model.Action, defaults map[string]ActionExecutor) []model.RuleActionResult {
+	results := make([]model.RuleActionResult, 0, len(actions))
+	for _, action := range actions {
+		actionType := actionTypeOf(action)
+		start := time.Now()
+		err := executors.execute(ctx, actionType, action, defaults)
+		result := model.RuleActionResult{
+			RuleId:      action.RuleId,
+			ExecutionId: action.ExecutionId,
+			Type:        actionType,
+			DeviceId:    action.DeviceId,
+			Success:     err == nil,
+			Duration:    time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (executors *ActionExecutors) execute(ctx context.Context, actionType string, action model.Action, defaults map[string]ActionExecutor) error {
+	executor := executors.executor(actionType, defaults)
+	if executor == nil {
+		return fmt.Errorf("%w: %s", ErrActionNotSupported, actionType)
+	}
+	timeout := executors.Timeout
+	if timeout <= 0 {
+		timeout = defaultActionTimeout
+	}
+	actionCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return executor(actionCtx, action)
+}
+
+func (executors *ActionExecutors) executor(actionType string, defaults map[string]ActionExecutor) ActionExecutor {
+	executors.lock.RLock()
+	executor, ok := executors.executors[actionType]
+	executors.lock.RUnlock()
+	if ok {
+		return executor
+	}
+	if executor, ok := defaults[actionType]; ok {
+		return executor
+	}
+	switch actionType {
+	case ActionTypeFunction:
+		return executors.executeFunction
+	case ActionTypeHttp:
+		return executors.executeHttp
+	}
+	return nil
+}
+
+// actionTypeOf 早期平台下发的动作没有type，按命令处理
+func actionTypeOf(action model.Action) string {
+	if action.Type == "" {
+		return ActionTypeDeviceCommand
+	}
+	return strings.ToUpper(action.Type)
+}
+
+func (executors *ActionExecutors) executeFunction(ctx context.Context, action model.Action) error {
+	if action.Function == nil || action.Function.Name == "" {
+		return fmt.Errorf("%w: function name is empty", iot.ErrInvalidParams)
+	}
+	executors.lock.RLock()
+	function, ok := executors.functions[action.Function.Name]
+	executors.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: function %s is not registered", ErrActionNotSupported, action.Function.Name)
+	}
+	return function(ctx, action.Function.Params)
+}
+
+func (executors *ActionExecutors) executeHttp(ctx context.Context, action model.Action) error {
+	request := action.Http
+	if request == nil || request.Url == "" {
+		return fmt.Errorf("%w: http url is empty", iot.ErrInvalidParams)
+	}
+	requestUrl, err := url.Parse(request.Url)
+	if err != nil || (requestUrl.Scheme != "http" && requestUrl.Scheme != "https") {
+		return fmt.Errorf("%w: http url %s is invalid", iot.ErrInvalidParams, request.Url)
+	}
+	// 规则由平台下发，只允许调用本机和配置的主机
+	if !executors.httpHostAllowed(requestUrl.Hostname()) {
+		return fmt.Errorf("%w: http host %s is not allowed", iot.ErrInvalidParams, requestUrl.Hostname())
+	}
+	body, err := httpBody(request.Body)
+	if err != nil {
+		return err
+	}
+	method := request.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), requestUrl.String(), body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", iot.ErrInvalidParams, err)
+	}
+	if _, ok := request.Body.(string); !ok && request.Body != nil {
+		httpRequest.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range request.Headers {
+		httpRequest.Header.Set(key, value)
+	}
+	response, err := executors.httpClient().Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("http status %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (executors *ActionExecutors) httpClient() *http.Client {
+	if executors.HttpClient != nil {
+		return executors.HttpClient
+	}
+	return &http.Client{
+		// 重定向到其他主机时同样需要校验
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !executors.httpHostAllowed(req.URL.Hostname()) {
+				return fmt.Errorf("%w: http host %s is not allowed", iot.ErrInvalidParams, req.URL.Hostname())
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+}
+
+func (executors *ActionExecutors) httpHostAllowed(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, allowed := range executors.HttpHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func httpBody(body interface{}) (io.Reader, error) {
+	switch v := body.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.NewReader(v), nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", iot.ErrSerialization, err)
+		}
+		return bytes.NewReader(data), nil
+	}
+}
+
+// ruleActions 复制规则的动作并填写触发动作的规则id
+func ruleActions(ruleInfo model.RuleInfo) []model.Action {
+	actions := make([]model.Action, len(ruleInfo.Actions))
+	for i, action := range ruleInfo.Actions {
+		action.RuleId = ruleInfo.RuleId
+		actions[i] = action
+	}
+	return actions
+}
@@ -30,7 +30,7 @@ package rule
 
 import (
 	"encoding/json"
-	"github.com/golang/glog"
+	"fmt"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
 	"strconv"
@@ -40,186 +40,107 @@ import (
 type ConditionExecute struct {
 }
 
-func (execute *ConditionExecute) isConditionSatisfied(condition model.Condition, services []model.DevicePropertyEntry) bool {
-	if !strings.EqualFold(condition.Type, "DEVICE_DATA") {
-		return false
+// isConditionSatisfied 判断上报的数据是否满足编译后的DEVICE_DATA条件，非DEVICE_DATA条件的expression为nil，返回false
+func (execute *ConditionExecute) isConditionSatisfied(expression *Expression, data map[string]interface{}) (bool, error) {
+	if expression == nil {
+		return false, nil
 	}
-	deviceInfo := condition.DeviceInfo
-	pathArr := strings.Split(deviceInfo.Path, "/")
-	if len(pathArr) == 0 {
-		glog.Warning("rule condition path is invalid. path: %s", deviceInfo.Path)
-		return false
-	}
-	serviceIdPath := pathArr[0]
-	propertyPath := pathArr[len(pathArr)-1]
-	operate := condition.Operator
-	if strings.EqualFold(operate, ">") {
-		return execute.operationMoreThan(condition.Value, serviceIdPath, propertyPath, services)
-	}
-	if strings.EqualFold(operate, ">=") {
-		return execute.operationMoreEqual(condition.Value, serviceIdPath, propertyPath, services)
-	}
-	if strings.EqualFold(operate, "<") {
-		return execute.operationLessThan(condition.Value, serviceIdPath, propertyPath, services)
-	}
-	if strings.EqualFold(operate, "<=") {
-		return execute.operationLessEqual(condition.Value, serviceIdPath, propertyPath, services)
-	}
-	if strings.EqualFold(operate, "=") {
-		return execute.operationEquals(condition.Value, serviceIdPath, propertyPath, services)
-	}
-	if strings.EqualFold(operate, "between") {
-		return execute.operationBetween(condition.Value, serviceIdPath, propertyPath, services)
-	}
-	if strings.EqualFold(operate, "in") {
-		return execute.operationIn(condition.InValue, serviceIdPath, propertyPath, services)
-	}
-	glog.Warningf("operate is not match. operate: %s", operate)
-	return false
+	return expression.Evaluate(data)
 }
 
-func (execute *ConditionExecute) operationMoreThan(value, serviceId, propertyPath string, services []model.DevicePropertyEntry) bool {
-	for _, service := range services {
-		properties := service.Properties
-		ok, target, current := getCompareFloatValue(value, propertyPath, properties)
-		if ok && strings.EqualFold(serviceId, service.ServiceId) && current > target {
-			glog.Infof("match condition for service. service_id: %s, value: %s", serviceId, target)
-			return true
+// CompileCondition 将DEVICE_DATA条件编译为表达式。
+// 设置了Expression时直接编译Expression，否则按DeviceInfo.Path、Operator、Value和InValues生成表达式，
+// Path格式为 服务ID/属性/嵌套属性，Operator支持 > >= < <= = != between in contains matches
+func CompileCondition(condition model.Condition) (*Expression, error) {
+	if len(condition.Expression) != 0 {
+		return CompileExpression(condition.Expression)
+	}
+	path, err := conditionPath(condition.DeviceInfo.Path)
+	if err != nil {
+		return nil, err
+	}
+	operator := strings.ToLower(strings.TrimSpace(condition.Operator))
+	var root node
+	switch operator {
+	case ">", ">=", "<", "<=", "=", "==", "!=", "contains":
+		if len(condition.Value) == 0 {
+			return nil, fmt.Errorf("%w: value of operator %s is empty", ErrInvalidCondition, condition.Operator)
 		}
-	}
-	return false
-}
-
-func (execute *ConditionExecute) operationMoreEqual(value, serviceId, propertyPath string, services []model.DevicePropertyEntry) bool {
-	for _, service := range services {
-		properties := service.Properties
-		ok, target, current := getCompareFloatValue(value, propertyPath, properties)
-		if ok && strings.EqualFold(serviceId, service.ServiceId) && current >= target {
-			glog.Infof("match condition for service. service_id: %s, value: %s", serviceId, target)
-			return true
+		if operator == "=" {
+			operator = "=="
 		}
-	}
-	return false
-}
-
-func (execute *ConditionExecute) operationLessThan(value, serviceId, propertyPath string, services []model.DevicePropertyEntry) bool {
-	for _, service := range services {
-		properties := service.Properties
-		ok, target, current := getCompareFloatValue(value, propertyPath, properties)
-		if ok && strings.EqualFold(serviceId, service.ServiceId) && current < target {
-			glog.Infof("match condition for service. service_id: %s, value: %s", serviceId, target)
-			return true
+		// 旧版本的=比较字符串时忽略大小写
+		root = binaryNode{operator: operator, left: path, right: conditionValue(condition.Value), foldCase: true}
+	case "between":
+		values := strings.Split(condition.Value, ",")
+		if len(values) != 2 {
+			return nil, fmt.Errorf("%w: value of between must be min,max, got %q", ErrInvalidCondition, condition.Value)
 		}
-	}
-	return false
-}
-
-func (execute *ConditionExecute) operationLessEqual(value, serviceId, propertyPath string, services []model.DevicePropertyEntry) bool {
-	for _, service := range services {
-		properties := service.Properties
-		ok, target, current := getCompareFloatValue(value, propertyPath, properties)
-		if ok && strings.EqualFold(serviceId, service.ServiceId) && current <= target {
-			glog.Infof("match condition for service. service_id: %s, value: %s", serviceId, target)
-			return true
+		low, lowErr := strconv.ParseFloat(strings.TrimSpace(values[0]), 64)
+		high, highErr := strconv.ParseFloat(strings.TrimSpace(values[1]), 64)
+		if lowErr != nil || highErr != nil {
+			return nil, fmt.Errorf("%w: value of between must be numbers, got %q", ErrInvalidCondition, condition.Value)
 		}
-	}
-	return false
-}
-
-func (execute *ConditionExecute) operationEquals(value, serviceId, propertyPath string, services []model.DevicePropertyEntry) bool {
-	for _, service := range services {
-		properties := service.Properties
-		if len(value) == 0 {
-			glog.Warningf("rule condition value is invalid. value: %s", value)
-			continue
+		root = logicNode{
+			and:   true,
+			left:  binaryNode{operator: ">=", left: path, right: literalNode{value: low}},
+			right: binaryNode{operator: "<=", left: path, right: literalNode{value: high}},
 		}
-		ok, target, current := getCompareFloatValue(value, propertyPath, properties)
-		if ok && strings.EqualFold(serviceId, service.ServiceId) && current == target {
-			glog.Infof("match condition for service. service_id: %s, value: %s", serviceId, value)
-			return true
-		} else {
-			propertyMap := make(map[string]string)
-			if json.Unmarshal([]byte(iot.Interface2JsonString(properties)), &propertyMap) != nil {
-				continue
-			}
-			current, exist := propertyMap[propertyPath]
-			if !exist {
-				continue
-			}
-			if exist && strings.EqualFold(serviceId, service.ServiceId) && strings.EqualFold(current, value) {
-				glog.Infof("match condition for service. service_id: %s, value: %s", serviceId, value)
-				return true
-			}
+	case "in":
+		if len(condition.InValue) == 0 {
+			return nil, fmt.Errorf("%w: inValues of operator in is empty", ErrInvalidCondition)
 		}
-	}
-	return false
-}
-
-func (execute *ConditionExecute) operationBetween(value, serviceId, propertyPath string, services []model.DevicePropertyEntry) bool {
-	for _, service := range services {
-		properties := service.Properties
-		valueList := strings.Split(value, ",")
-		if len(valueList) != 2 {
-			glog.Warningf("rule condition value is invalid. value: %s", value)
-			continue
+		list := listNode{}
+		for _, value := range condition.InValue {
+			list.items = append(list.items, conditionValue(value))
 		}
-		ok, target, current := getCompareFloatValue(valueList[0], propertyPath, properties)
-		target2, err := strconv.ParseFloat(valueList[1], 64)
+		root = binaryNode{operator: "in", left: path, right: list}
+	case "matches", "regex":
+		re, err := compileRegexp(condition.Value)
 		if err != nil {
-			glog.Warningf("condition value convert to float failed. value: %s", value)
-			continue
-		}
-		if ok && strings.EqualFold(serviceId, service.ServiceId) && current >= target && current <= target2 {
-			glog.Infof("match condition for service. service_id: %s, value: %s", serviceId, target)
-			return true
+			return nil, err
 		}
+		root = binaryNode{operator: "matches", left: path, right: literalNode{value: condition.Value}, re: re}
+	default:
+		return nil, fmt.Errorf("%w: unsupported operator %q", ErrInvalidCondition, condition.Operator)
 	}
-	return false
+	return &Expression{source: condition.DeviceInfo.Path + " " + condition.Operator + " " + condition.Value, root: root}, nil
 }
 
-func (execute *ConditionExecute) operationIn(value []string, serviceId, propertyPath string, services []model.DevicePropertyEntry) bool {
-	for _, service := range services {
-		properties := service.Properties
-		if len(value) == 0 {
-			glog.Warningf("rule condition value is invalid. value: %s", value)
-			continue
+// conditionPath 解析 服务ID/属性/嵌套属性 形式的路径，数组下标使用数字
+func conditionPath(path string) (pathNode, error) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 {
+		return pathNode{}, fmt.Errorf("%w: path %q must be service/property", ErrInvalidCondition, path)
+	}
+	node := pathNode{}
+	for i, segment := range segments {
+		if len(segment) == 0 {
+			return pathNode{}, fmt.Errorf("%w: path %q contains empty segment", ErrInvalidCondition, path)
 		}
-		ok, _, current := getCompareFloatValue(value[0], propertyPath, properties)
-		if ok && strings.EqualFold(serviceId, service.ServiceId) && valueInList(current, value) {
-			glog.Infof("match condition for service. service_id: %s, value: %s", serviceId, value)
-			return true
+		if index, err := strconv.Atoi(segment); err == nil && i > 1 {
+			node.segments = append(node.segments, index)
+			continue
 		}
+		node.segments = append(node.segments, segment)
 	}
-	return false
+	return node, nil
 }
 
-func getCompareFloatValue(value, propertyPath string, properties interface{}) (bool, float64, float64) {
-	propertyMap := make(map[string]float64)
-	if json.Unmarshal([]byte(iot.Interface2JsonString(properties)), &propertyMap) != nil {
-		return false, 0, 0
-	}
-	target, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		glog.Warningf("condition value convert to float failed. value: %s", value)
-		return false, 0, 0
-	}
-	current, exist := propertyMap[propertyPath]
-	if !exist {
-		return false, 0, 0
-	}
-	return true, target, current
+// conditionValue 条件中的值统一使用字符串，比较时数字字符串按数字比较
+func conditionValue(value string) node {
+	return literalNode{value: value}
 }
 
-func valueInList(current float64, valueList []string) bool {
-	for _, value := range valueList {
-		target, err := strconv.ParseFloat(value, 64)
-		if err != nil {
-			glog.Warningf("condition value convert to float failed. value: %s", value)
+// propertyData 将上报的属性转换为 服务ID -> 属性 的映射，用于计算条件表达式
+func propertyData(services []model.DevicePropertyEntry) map[string]interface{} {
+	data := make(map[string]interface{}, len(services))
+	for _, service := range services {
+		var properties interface{}
+		if json.Unmarshal([]byte(iot.Interface2JsonString(service.Properties)), &properties) != nil {
 			continue
 		}
-		if target == current {
-			return true
-		}
+		data[service.ServiceId] = properties
 	}
-	return false
+	return data
 }
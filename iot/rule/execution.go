@@ -0,0 +1,123 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rule
+
+import (
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/callback"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/store"
+	uuid "github.com/satori/go.uuid"
+	"time"
+)
+
+// 规则的触发方式，对应RuleExecution.Trigger
+const (
+	TriggerDeviceData = "DEVICE_DATA" // 上报的属性满足条件
+	TriggerTimer      = "TIMER"       // 定时规则到达执行时间
+)
+
+// SetExecutionHandler 设置规则每次执行后的回调，如将执行记录上报平台
+func (ruleService *RuleManageService) SetExecutionHandler(handler func(execution model.RuleExecution)) {
+	ruleService.lock.Lock()
+	defer ruleService.lock.Unlock()
+	ruleService.executionHandler = handler
+}
+
+// Executions 查询规则的执行记录，没有设置ExecutionStore时返回空
+func (ruleService *RuleManageService) Executions(query store.RuleExecutionQuery) ([]model.RuleExecution, error) {
+	if ruleService.ExecutionStore == nil {
+		return nil, nil
+	}
+	return ruleService.ExecutionStore.Query(query)
+}
+
+// RecordActionResults 记录动作的执行结果，按ExecutionId归入正在执行的规则的执行记录。
+// 自定义的RuleActionHandler执行动作后也可以调用，结果需要携带Action.ExecutionId
+func (ruleService *RuleManageService) RecordActionResults(results []model.RuleActionResult) {
+	ruleService.resultLock.Lock()
+	defer ruleService.resultLock.Unlock()
+	for _, result := range results {
+		if recorded, ok := ruleService.results[result.ExecutionId]; ok {
+			ruleService.results[result.ExecutionId] = append(recorded, result)
+		}
+	}
+}
+
+// execute 执行规则的动作，保存执行记录后调用executionHandler，返回handler的结果
+func (ruleService *RuleManageService) execute(ruleId, trigger string, values map[string]interface{}, actions []model.Action, handler callback.RuleActionHandler) bool {
+	execution := model.RuleExecution{
+		Id:              uuid.NewV4().String(),
+		RuleId:          ruleId,
+		Trigger:         trigger,
+		Time:            time.Now().UTC(),
+		ConditionValues: values,
+		Actions:         actions,
+	}
+	for i := range actions {
+		actions[i].ExecutionId = execution.Id
+	}
+	ruleService.resultLock.Lock()
+	ruleService.results[execution.Id] = nil
+	ruleService.resultLock.Unlock()
+
+	execution.Success = handler(actions)
+
+	ruleService.resultLock.Lock()
+	execution.Results = ruleService.results[execution.Id]
+	delete(ruleService.results, execution.Id)
+	ruleService.resultLock.Unlock()
+
+	if ruleService.ExecutionStore != nil {
+		if err := ruleService.ExecutionStore.Append(execution); err != nil {
+			logger.Default().Warn("save rule execution failed", logger.String("rule_id", ruleId), logger.Err(err))
+		}
+	}
+	ruleService.lock.RLock()
+	executionHandler := ruleService.executionHandler
+	ruleService.lock.RUnlock()
+	if executionHandler != nil {
+		executionHandler(execution)
+	}
+	return execution.Success
+}
+
+// conditionValues 返回编译后的DEVICE_DATA条件引用的属性的当前值，忽略为nil的条件
+func conditionValues(data map[string]interface{}, expressions ...*Expression) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, expression := range expressions {
+		if expression == nil {
+			continue
+		}
+		for path, value := range expression.Values(data) {
+			values[path] = value
+		}
+	}
+	return values
+}
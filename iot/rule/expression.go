@@ -0,0 +1,881 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidCondition 规则条件不合法，或者属性值的类型不支持条件中的运算
+var ErrInvalidCondition = errors.New("invalid rule condition")
+
+// errPropertyMissing 上报的数据中没有条件引用的属性，条件不满足但不是错误
+var errPropertyMissing = errors.New("property missing")
+
+// Expression 编译后的条件表达式，可以被多个协程同时使用。
+// 表达式支持：
+//   - 属性引用：服务ID.属性名，嵌套属性和数组使用 a.b[0].c 或 a["b-c"] 访问
+//   - 常量：数字、"字符串"或'字符串'、true、false、null、[1, 2, 3]
+//   - 算术运算：+ - * / %，字符串可以使用 + 拼接
+//   - 比较运算：== = != > >= < <=，数字字符串与数字比较时按数字比较
+//   - contains：字符串包含子串或数组包含元素；matches：正则匹配；in：在数组中
+//   - 逻辑运算：&& || !，也可以写作 and or not
+type Expression struct {
+	source string
+	root   node
+}
+
+// CompileExpression 编译条件表达式，语法错误时返回ErrInvalidCondition
+func CompileExpression(source string) (*Expression, error) {
+	p := &parser{lexer: lexer{source: source}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.kind != tokenEnd {
+		return nil, p.errorf("unexpected %q", p.token.text)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+func (expression *Expression) String() string {
+	return expression.source
+}
+
+// Evaluate 计算表达式，services为服务ID到属性的映射。
+// 引用的属性没有上报时返回false，类型不匹配等错误返回ErrInvalidCondition
+func (expression *Expression) Evaluate(services map[string]interface{}) (bool, error) {
+	value, err := expression.root.eval(services)
+	if errors.Is(err, errPropertyMissing) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, evalError("expression result %s is not boolean", describe(value))
+	}
+	return result, nil
+}
+
+// Values 返回表达式引用的属性的当前值，key为属性路径，没有上报的属性不返回
+func (expression *Expression) Values(services map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	collectValues(expression.root, services, values)
+	return values
+}
+
+func collectValues(n node, services map[string]interface{}, values map[string]interface{}) {
+	switch n := n.(type) {
+	case pathNode:
+		if value, err := n.eval(services); err == nil {
+			values[n.String()] = value
+		}
+	case listNode:
+		for _, item := range n.items {
+			collectValues(item, services, values)
+		}
+	case notNode:
+		collectValues(n.operand, services, values)
+	case negateNode:
+		collectValues(n.operand, services, values)
+	case logicNode:
+		collectValues(n.left, services, values)
+		collectValues(n.right, services, values)
+	case binaryNode:
+		collectValues(n.left, services, values)
+		collectValues(n.right, services, values)
+	}
+}
+
+type node interface {
+	eval(services map[string]interface{}) (interface{}, error)
+}
+
+// literalNode 常量
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+// listNode 数组常量，元素可以是表达式
+type listNode struct {
+	items []node
+}
+
+func (n listNode) eval(services map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		value, err := item.eval(services)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// pathNode 属性引用，第一段为服务ID
+type pathNode struct {
+	segments []interface{} // string为对象的key，int为数组下标
+}
+
+func (n pathNode) eval(services map[string]interface{}) (interface{}, error) {
+	var current interface{} = services
+	for i, segment := range n.segments {
+		switch key := segment.(type) {
+		case string:
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, errPropertyMissing
+			}
+			if current, ok = object[key]; ok {
+				continue
+			}
+			// 服务ID不区分大小写
+			if i == 0 {
+				for serviceId, properties := range services {
+					if strings.EqualFold(serviceId, key) {
+						current, ok = properties, true
+						break
+					}
+				}
+			}
+			if !ok {
+				return nil, errPropertyMissing
+			}
+		case int:
+			// 对象的key也可能是数字
+			if object, ok := current.(map[string]interface{}); ok {
+				if current, ok = object[strconv.Itoa(key)]; !ok {
+					return nil, errPropertyMissing
+				}
+				continue
+			}
+			array, ok := current.([]interface{})
+			if !ok || key < 0 || key >= len(array) {
+				return nil, errPropertyMissing
+			}
+			current = array[key]
+		}
+	}
+	return current, nil
+}
+
+func (n pathNode) String() string {
+	var builder strings.Builder
+	for i, segment := range n.segments {
+		switch key := segment.(type) {
+		case string:
+			if i > 0 {
+				builder.WriteByte('.')
+			}
+			builder.WriteString(key)
+		case int:
+			builder.WriteString("[" + strconv.Itoa(key) + "]")
+		}
+	}
+	return builder.String()
+}
+
+// notNode 逻辑非
+type notNode struct {
+	operand node
+}
+
+func (n notNode) eval(services map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(services)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return nil, evalError("operator ! not support %s", describe(value))
+	}
+	return !result, nil
+}
+
+// negateNode 取负
+type negateNode struct {
+	operand node
+}
+
+func (n negateNode) eval(services map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(services)
+	if err != nil {
+		return nil, err
+	}
+	number, ok := toNumber(value)
+	if !ok {
+		return nil, evalError("operator - not support %s", describe(value))
+	}
+	return -number, nil
+}
+
+// logicNode 逻辑与或，引用的属性未上报时该操作数视为false
+type logicNode struct {
+	and         bool
+	left, right node
+}
+
+func (n logicNode) eval(services map[string]interface{}) (interface{}, error) {
+	left, err := evalBool(n.left, services)
+	if err != nil {
+		return nil, err
+	}
+	if n.and != left {
+		return left, nil
+	}
+	return evalBool(n.right, services)
+}
+
+func evalBool(n node, services map[string]interface{}) (bool, error) {
+	value, err := n.eval(services)
+	if errors.Is(err, errPropertyMissing) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, evalError("logic operator not support %s", describe(value))
+	}
+	return result, nil
+}
+
+// binaryNode 算术和比较运算
+type binaryNode struct {
+	operator    string
+	left, right node
+	foldCase    bool           // ==和!=比较字符串时忽略大小写，与旧版本Operator/Value条件的行为一致
+	re          *regexp.Regexp // matches的正则表达式为字面量时编译时生成，随表达式一起释放
+}
+
+func (n binaryNode) eval(services map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(services)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(services)
+	if err != nil {
+		return nil, err
+	}
+	switch n.operator {
+	case "+", "-", "*", "/", "%":
+		return arithmetic(n.operator, left, right)
+	case "==":
+		return equal(left, right, n.foldCase), nil
+	case "!=":
+		return !equal(left, right, n.foldCase), nil
+	case ">", ">=", "<", "<=":
+		result, err := compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch n.operator {
+		case ">":
+			return result > 0, nil
+		case ">=":
+			return result >= 0, nil
+		case "<":
+			return result < 0, nil
+		default:
+			return result <= 0, nil
+		}
+	case "contains":
+		return contains(left, right)
+	case "in":
+		return contains(right, left)
+	case "matches":
+		return matches(left, right, n.re)
+	}
+	return nil, evalError("unknown operator %s", n.operator)
+}
+
+func arithmetic(operator string, left, right interface{}) (interface{}, error) {
+	if operator == "+" {
+		leftString, leftOk := left.(string)
+		rightString, rightOk := right.(string)
+		if leftOk && rightOk {
+			return leftString + rightString, nil
+		}
+	}
+	a, leftOk := toNumber(left)
+	b, rightOk := toNumber(right)
+	if !leftOk || !rightOk {
+		return nil, evalError("operator %s not support %s and %s", operator, describe(left), describe(right))
+	}
+	switch operator {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	}
+	if b == 0 {
+		return nil, evalError("division by zero")
+	}
+	if operator == "/" {
+		return a / b, nil
+	}
+	return math.Mod(a, b), nil
+}
+
+// equal 数字与数字字符串按数字比较，布尔值与"true"/"false"按布尔值比较，其他类型不同时不相等。
+// 表达式中的字符串区分大小写，foldCase为true时忽略大小写
+func equal(left, right interface{}, foldCase bool) bool {
+	if a, ok := toNumber(left); ok {
+		b, ok := toNumber(right)
+		return ok && a == b
+	}
+	if _, ok := toNumber(right); ok {
+		return false
+	}
+	switch a := left.(type) {
+	case nil:
+		return right == nil
+	case bool:
+		b, ok := toBool(right)
+		return ok && a == b
+	case string:
+		if b, ok := right.(bool); ok {
+			a, ok := toBool(a)
+			return ok && a == b
+		}
+		b, ok := right.(string)
+		if ok && foldCase {
+			return strings.EqualFold(a, b)
+		}
+		return ok && a == b
+	}
+	return false
+}
+
+func compare(left, right interface{}) (int, error) {
+	a, leftOk := toNumber(left)
+	b, rightOk := toNumber(right)
+	if leftOk && rightOk {
+		switch {
+		case a < b:
+			return -1, nil
+		case a > b:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	leftString, leftOk := left.(string)
+	rightString, rightOk := right.(string)
+	if leftOk && rightOk {
+		return strings.Compare(leftString, rightString), nil
+	}
+	return 0, evalError("can not compare %s with %s", describe(left), describe(right))
+}
+
+func contains(container, element interface{}) (interface{}, error) {
+	switch c := container.(type) {
+	case string:
+		e, ok := element.(string)
+		if !ok {
+			return nil, evalError("string can not contain %s", describe(element))
+		}
+		return strings.Contains(c, e), nil
+	case []interface{}:
+		for _, item := range c {
+			if equal(item, element, false) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return nil, evalError("%s can not contain elements", describe(container))
+}
+
+// matches re为空时按运行时计算出的pattern编译正则表达式，不缓存
+func matches(value, pattern interface{}, re *regexp.Regexp) (interface{}, error) {
+	text, ok := value.(string)
+	if !ok {
+		return nil, evalError("operator matches not support %s", describe(value))
+	}
+	if re == nil {
+		expr, ok := pattern.(string)
+		if !ok {
+			return nil, evalError("regex pattern must be string, got %s", describe(pattern))
+		}
+		var err error
+		if re, err = compileRegexp(expr); err != nil {
+			return nil, err
+		}
+	}
+	return re.MatchString(text), nil
+}
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid regex %q: %s", ErrInvalidCondition, pattern, err.Error())
+	}
+	return re, nil
+}
+
+// toNumber 数字以及可以解析为数字的字符串
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func toBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		result, err := strconv.ParseBool(v)
+		return result, err == nil
+	}
+	return false, false
+}
+
+func describe(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "number " + strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return "string " + strconv.Quote(v)
+	case bool:
+		return "boolean " + strconv.FormatBool(v)
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func evalError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCondition, fmt.Sprintf(format, args...))
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	source string
+	pos    int
+}
+
+// 多字符运算符需要排在前面
+var operators = []string{"&&", "||", "==", "!=", ">=", "<=", ">", "<", "=", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ","}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.source) && unicode.IsSpace(rune(l.source[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.source) {
+		return token{kind: tokenEnd, pos: start}, nil
+	}
+	c := l.source[l.pos]
+	switch {
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.source) && (isDigit(l.source[l.pos]) || l.source[l.pos] == '.' ||
+			l.source[l.pos] == 'e' || l.source[l.pos] == 'E' ||
+			((l.source[l.pos] == '+' || l.source[l.pos] == '-') && (l.source[l.pos-1] == 'e' || l.source[l.pos-1] == 'E'))) {
+			l.pos++
+		}
+		return token{kind: tokenNumber, text: l.source[start:l.pos], pos: start}, nil
+	case c == '"' || c == '\'':
+		return l.readString(c)
+	case isIdentStart(c):
+		for l.pos < len(l.source) && (isIdentStart(l.source[l.pos]) || isDigit(l.source[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenIdent, text: l.source[start:l.pos], pos: start}, nil
+	}
+	for _, operator := range operators {
+		if strings.HasPrefix(l.source[l.pos:], operator) {
+			l.pos += len(operator)
+			return token{kind: tokenOperator, text: operator, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("%w: unexpected character %q at %d", ErrInvalidCondition, c, start)
+}
+
+func (l *lexer) readString(quote byte) (token, error) {
+	start := l.pos
+	var builder strings.Builder
+	l.pos++
+	for l.pos < len(l.source) {
+		c := l.source[l.pos]
+		l.pos++
+		if c == quote {
+			return token{kind: tokenString, text: builder.String(), pos: start}, nil
+		}
+		if c == '\\' && l.pos < len(l.source) {
+			c = l.source[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			}
+		}
+		builder.WriteByte(c)
+	}
+	return token{}, fmt.Errorf("%w: unterminated string at %d", ErrInvalidCondition, start)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+type parser struct {
+	lexer lexer
+	token token
+}
+
+func (p *parser) next() error {
+	t, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = t
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at %d in %q", ErrInvalidCondition, fmt.Sprintf(format, args...), p.token.pos, p.lexer.source)
+}
+
+// accept 当前token为指定的运算符或关键字时前进并返回true
+func (p *parser) accept(texts ...string) (string, bool, error) {
+	for _, text := range texts {
+		if p.token.kind == tokenOperator && p.token.text == text ||
+			p.token.kind == tokenIdent && strings.EqualFold(p.token.text, text) && isKeyword(text) {
+			return text, true, p.next()
+		}
+	}
+	return "", false, nil
+}
+
+func (p *parser) expect(text string) error {
+	_, ok, err := p.accept(text)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return p.errorf("expect %q", text)
+	}
+	return nil
+}
+
+func isKeyword(text string) bool {
+	switch strings.ToLower(text) {
+	case "and", "or", "not", "contains", "matches", "in", "true", "false", "null":
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil {
+		var ok bool
+		if _, ok, err = p.accept("||", "or"); err != nil || !ok {
+			break
+		}
+		var right node
+		if right, err = p.parseAnd(); err == nil {
+			left = logicNode{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	for err == nil {
+		var ok bool
+		if _, ok, err = p.accept("&&", "and"); err != nil || !ok {
+			break
+		}
+		var right node
+		if right, err = p.parseNot(); err == nil {
+			left = logicNode{and: true, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseNot() (node, error) {
+	_, ok, err := p.accept("!", "not")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	operator, ok, err := p.accept("==", "=", "!=", ">=", "<=", ">", "<", "contains", "matches", "in")
+	if err != nil || !ok {
+		return left, err
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if operator == "=" {
+		operator = "=="
+	}
+	comparison := binaryNode{operator: operator, left: left, right: right}
+	if literal, ok := right.(literalNode); ok && operator == "matches" {
+		pattern, ok := literal.value.(string)
+		if !ok {
+			return nil, p.errorf("regex pattern must be string")
+		}
+		if comparison.re, err = compileRegexp(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return comparison, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	for err == nil {
+		var operator string
+		var ok bool
+		if operator, ok, err = p.accept("+", "-"); err != nil || !ok {
+			break
+		}
+		var right node
+		if right, err = p.parseMultiplicative(); err == nil {
+			left = binaryNode{operator: operator, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil {
+		var operator string
+		var ok bool
+		if operator, ok, err = p.accept("*", "/", "%"); err != nil || !ok {
+			break
+		}
+		var right node
+		if right, err = p.parseUnary(); err == nil {
+			left = binaryNode{operator: operator, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseUnary() (node, error) {
+	_, ok, err := p.accept("-")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negateNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.token
+	switch t.kind {
+	case tokenNumber:
+		number, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", t.text)
+		}
+		return literalNode{value: number}, p.next()
+	case tokenString:
+		return literalNode{value: t.text}, p.next()
+	case tokenIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return literalNode{value: true}, p.next()
+		case "false":
+			return literalNode{value: false}, p.next()
+		case "null":
+			return literalNode{value: nil}, p.next()
+		}
+		if isKeyword(t.text) {
+			return nil, p.errorf("unexpected %q", t.text)
+		}
+		return p.parsePath()
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		case "[":
+			return p.parseList()
+		}
+	case tokenEnd:
+		return nil, p.errorf("unexpected end")
+	}
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+// parsePath 解析 service.property.child[0]["key"] 形式的属性引用
+func (p *parser) parsePath() (node, error) {
+	path := pathNode{segments: []interface{}{p.token.text}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok, err := p.accept(".", "[")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if operator == "." {
+			if p.token.kind != tokenIdent {
+				return nil, p.errorf("expect property name")
+			}
+			path.segments = append(path.segments, p.token.text)
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch p.token.kind {
+		case tokenNumber:
+			index, err := strconv.Atoi(p.token.text)
+			if err != nil {
+				return nil, p.errorf("invalid index %q", p.token.text)
+			}
+			path.segments = append(path.segments, index)
+		case tokenString:
+			path.segments = append(path.segments, p.token.text)
+		default:
+			return nil, p.errorf("expect index or key")
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+	if len(path.segments) < 2 {
+		return nil, p.errorf("property %q must be prefixed with service id", path.String())
+	}
+	return path, nil
+}
+
+func (p *parser) parseList() (node, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	list := listNode{}
+	if _, ok, err := p.accept("]"); err != nil || ok {
+		return list, err
+	}
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		list.items = append(list.items, item)
+		_, ok, err := p.accept(",")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return list, p.expect("]")
+		}
+	}
+}
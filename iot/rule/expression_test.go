@@ -0,0 +1,234 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rule
+
+import (
+	"errors"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"testing"
+)
+
+func testServices() map[string]interface{} {
+	return map[string]interface{}{
+		"sensor": map[string]interface{}{
+			"temp":   float64(30),
+			"text":   "42",
+			"status": "Running",
+			"on":     true,
+			"tags":   []interface{}{"a", "b", float64(3)},
+			"nested": map[string]interface{}{
+				"values": []interface{}{map[string]interface{}{"v": float64(7)}},
+				"a-b":    "dash",
+			},
+		},
+	}
+}
+
+func TestExpressionEvaluate(t *testing.T) {
+	tests := []struct {
+		source  string
+		want    bool
+		wantErr bool
+	}{
+		{source: "sensor.temp > 20", want: true},
+		{source: "sensor.temp >= 30 && sensor.temp <= 30", want: true},
+		{source: "sensor.temp < 30 || sensor.temp > 30", want: false},
+		{source: "sensor.temp * 1.8 + 32 == 86", want: true},
+		{source: "(sensor.temp - 10) / 4 = 5", want: true},
+		{source: "sensor.temp % 7 == 2", want: true},
+		{source: "-sensor.temp < 0", want: true},
+		{source: "sensor.text == 42", want: true},
+		{source: "sensor.text > 41.5", want: true},
+		{source: "sensor.status == 'Running'", want: true},
+		{source: "sensor.status == \"running\"", want: false},
+		{source: "sensor.status != 'Stopped'", want: true},
+		{source: "sensor.status + '!' == 'Running!'", want: true},
+		{source: "sensor.on == true", want: true},
+		{source: "sensor.on == 'true'", want: true},
+		{source: "not sensor.on", want: false},
+		{source: "!(sensor.temp > 100) and sensor.on", want: true},
+		{source: "sensor.tags contains 'b'", want: true},
+		{source: "sensor.tags contains 3", want: true},
+		{source: "sensor.status contains 'unn'", want: true},
+		{source: "sensor.temp in [10, 20, 30]", want: true},
+		{source: "sensor.status in ['Idle', 'Stopped']", want: false},
+		{source: "sensor.status matches '^Run'", want: true},
+		{source: "sensor.status matches sensor.text", want: false},
+		{source: "sensor.nested.values[0].v == 7", want: true},
+		{source: "sensor.nested[\"a-b\"] == 'dash'", want: true},
+		{source: "SENSOR.temp == 30", want: true},
+		{source: "sensor.missing == 1", want: false},
+		{source: "sensor.tags[5] == 'a'", want: false},
+		{source: "other.temp > 1 || sensor.temp > 1", want: true},
+		{source: "other.temp > 1 && sensor.temp > 1", want: false},
+		{source: "sensor.temp == null", want: false},
+		{source: "sensor.temp / 0 > 1", wantErr: true},
+		{source: "sensor.status > 1", wantErr: true},
+		{source: "sensor.temp + 1", wantErr: true},
+		{source: "sensor.temp contains 1", wantErr: true},
+		{source: "sensor.temp matches 'a'", wantErr: true},
+		{source: "sensor.status matches sensor.temp", wantErr: true},
+		{source: "sensor.on && sensor.temp", wantErr: true},
+	}
+	services := testServices()
+	for _, test := range tests {
+		expression, err := CompileExpression(test.source)
+		if err != nil {
+			t.Errorf("CompileExpression(%q) failed: %v", test.source, err)
+			continue
+		}
+		got, err := expression.Evaluate(services)
+		if test.wantErr {
+			if !errors.Is(err, ErrInvalidCondition) {
+				t.Errorf("Evaluate(%q) error = %v, want ErrInvalidCondition", test.source, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("Evaluate(%q) = %v, %v, want %v", test.source, got, err, test.want)
+		}
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"temp > 1",
+		"sensor.temp >",
+		"sensor.temp > 1 )",
+		"(sensor.temp > 1",
+		"sensor.temp > 'abc",
+		"sensor.temp # 1",
+		"sensor.temp in [1, 2",
+		"sensor.[0] > 1",
+		"sensor.temp[x] > 1",
+		"sensor.temp matches '('",
+		"sensor.temp matches 1",
+		"sensor.temp > and",
+	}
+	for _, source := range tests {
+		if _, err := CompileExpression(source); !errors.Is(err, ErrInvalidCondition) {
+			t.Errorf("CompileExpression(%q) error = %v, want ErrInvalidCondition", source, err)
+		}
+	}
+}
+
+func TestExpressionValues(t *testing.T) {
+	expression, err := CompileExpression("sensor.temp > 1 && sensor.status in ['a', sensor.text] || sensor.missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := expression.Values(testServices())
+	want := map[string]interface{}{"sensor.temp": float64(30), "sensor.status": "Running", "sensor.text": "42"}
+	if len(values) != len(want) {
+		t.Fatalf("Values() = %v, want %v", values, want)
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("Values()[%q] = %v, want %v", key, values[key], value)
+		}
+	}
+}
+
+func TestCompileCondition(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition model.Condition
+		want      bool
+		wantErr   bool
+	}{
+		{name: "greater", condition: legacyCondition(">", "20"), want: true},
+		{name: "equal number", condition: legacyCondition("=", "30.0"), want: true},
+		// 旧版本的=比较字符串时忽略大小写
+		{name: "equal fold case", condition: model.Condition{Operator: "=", DeviceInfo: model.RuleDeviceInfo{Path: "sensor/status"}, Value: "running"}, want: true},
+		{name: "not equal fold case", condition: model.Condition{Operator: "!=", DeviceInfo: model.RuleDeviceInfo{Path: "sensor/status"}, Value: "RUNNING"}, want: false},
+		{name: "between", condition: legacyCondition("between", "10,30"), want: true},
+		{name: "between outside", condition: legacyCondition("BETWEEN", "31, 40"), want: false},
+		{name: "in", condition: model.Condition{Operator: "in", DeviceInfo: model.RuleDeviceInfo{Path: "sensor/temp"}, InValue: []string{"10", "30"}}, want: true},
+		{name: "nested path", condition: model.Condition{Operator: "=", DeviceInfo: model.RuleDeviceInfo{Path: "sensor/nested/values/0/v"}, Value: "7"}, want: true},
+		{name: "expression", condition: model.Condition{Operator: "<", Value: "0", Expression: "sensor.status == 'running'"}, want: false},
+		{name: "empty value", condition: legacyCondition(">", ""), wantErr: true},
+		{name: "invalid between", condition: legacyCondition("between", "1"), wantErr: true},
+		{name: "unsupported operator", condition: legacyCondition("like", "1"), wantErr: true},
+		{name: "invalid path", condition: model.Condition{Operator: ">", DeviceInfo: model.RuleDeviceInfo{Path: "temp"}, Value: "1"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expression, err := CompileCondition(test.condition)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidCondition) {
+					t.Fatalf("CompileCondition error = %v, want ErrInvalidCondition", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := expression.Evaluate(testServices())
+			if err != nil || got != test.want {
+				t.Fatalf("Evaluate = %v, %v, want %v", got, err, test.want)
+			}
+		})
+	}
+}
+
+func legacyCondition(operator, value string) model.Condition {
+	return model.Condition{Operator: operator, DeviceInfo: model.RuleDeviceInfo{Path: "sensor/temp"}, Value: value}
+}
+
+func TestCompiledRegexp(t *testing.T) {
+	services := map[string]interface{}{"sensor": map[string]interface{}{"status": "literal-12", "pattern": "^dynamic-a"}}
+	literal, err := CompileExpression("sensor.status matches '^literal-[0-9]+$'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	condition, err := CompileCondition(model.Condition{Operator: "matches", DeviceInfo: model.RuleDeviceInfo{Path: "sensor/status"}, Value: "^literal-[0-9]+$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 字面量的正则表达式在编译时生成并保存在表达式中
+	for _, expression := range []*Expression{literal, condition} {
+		if expression.root.(binaryNode).re == nil {
+			t.Errorf("%s: literal pattern is not compiled", expression)
+		}
+		if got, err := expression.Evaluate(services); err != nil || !got {
+			t.Errorf("%s: Evaluate = %v, %v, want true", expression, got, err)
+		}
+	}
+	dynamic, err := CompileExpression("sensor.status matches sensor.pattern")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dynamic.root.(binaryNode).re != nil {
+		t.Error("dynamic pattern should be compiled when evaluating")
+	}
+	if got, err := dynamic.Evaluate(services); err != nil || got {
+		t.Fatalf("Evaluate = %v, %v, want false", got, err)
+	}
+}
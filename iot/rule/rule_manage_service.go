@@ -30,20 +30,207 @@ package rule
 
 import (
 	"encoding/json"
-	"github.com/go-co-op/gocron"
-	"github.com/golang/glog"
+	"fmt"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/callback"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/store"
+	"sort"
 	"strings"
-	"time"
+	"sync"
 )
 
+// RuleManageService 端侧规则管理，可以被消息处理协程和上报属性的协程同时调用。
+// 规则在Start时从Store恢复，断链重连不影响已有的规则，Stop时停止所有定时规则
 type RuleManageService struct {
-	RuleIdList       map[string]bool
-	RuleInfoMap      map[string]model.RuleInfo
-	TimerRuleMap     map[string]TimerRuleInstance
 	ConditionExecute ConditionExecute
+	Store            store.RuleStore          // 规则的持久化存储，为空时规则只保存在内存中
+	ExecutionStore   store.RuleExecutionStore // 规则的执行记录，默认在内存中保存最近的记录，为空时不记录
+	DefaultTrigger   model.RuleTrigger        // 规则没有设置Trigger时的触发方式
+
+	lock             sync.RWMutex
+	ruleIds          map[string]bool
+	rules            map[string]*compiledRule
+	timerRules       map[string]*TimerRuleInstance
+	handler          callback.RuleActionHandler // 定时规则触发时调用，Start时设置
+	executionHandler func(execution model.RuleExecution)
+	loaded           bool
+	started          bool
+	persistLock      sync.Mutex // 保证最后一次保存的是最新的规则
+	resultLock       sync.Mutex
+	results          map[string][]model.RuleActionResult // 正在执行的规则中已记录的动作结果，key为执行记录的id
+	triggerLock      sync.Mutex
+	triggers         map[string]*triggerState // 属性触发的规则的条件状态，key为规则id
+}
+
+// NewRuleManageService 创建规则管理服务，store为空时不持久化规则
+func NewRuleManageService(ruleStore store.RuleStore) *RuleManageService {
+	return &RuleManageService{
+		Store:          ruleStore,
+		ExecutionStore: store.NewMemoryRuleExecutionStore(store.DefaultMaxRuleExecutions),
+		ruleIds:        make(map[string]bool),
+		rules:          make(map[string]*compiledRule),
+		timerRules:     make(map[string]*TimerRuleInstance),
+		results:        make(map[string][]model.RuleActionResult),
+		triggers:       make(map[string]*triggerState),
+	}
+}
+
+// Start 第一次调用时从Store恢复规则，并启动所有定时规则，已经启动时直接返回。
+// 建链之前调用，设备离线时规则也可以执行
+func (ruleService *RuleManageService) Start(handler callback.RuleActionHandler) error {
+	ruleService.lock.Lock()
+	if ruleService.started {
+		ruleService.lock.Unlock()
+		return nil
+	}
+	var err error
+	if !ruleService.loaded {
+		err = ruleService.load()
+	}
+	ruleService.started = true
+	ruleService.handler = handler
+	rules := ruleService.rulesLocked()
+	ruleService.lock.Unlock()
+
+	for _, ruleInfo := range rules {
+		ruleService.startTimerRule(ruleInfo)
+	}
+	return err
+}
+
+// Stop 停止所有定时规则并等待正在执行的定时任务结束，规则本身保留，可以重复调用
+func (ruleService *RuleManageService) Stop() {
+	ruleService.lock.Lock()
+	ruleService.started = false
+	timerRules := ruleService.timerRules
+	ruleService.timerRules = make(map[string]*TimerRuleInstance)
+	ruleService.lock.Unlock()
+
+	for _, timerRule := range timerRules {
+		timerRule.ShutdownTimer()
+	}
+	ruleService.stopTriggers()
+}
+
+// load 从Store恢复规则，调用方持有锁
+func (ruleService *RuleManageService) load() error {
+	if ruleService.Store == nil {
+		ruleService.loaded = true
+		return nil
+	}
+	snapshot, err := ruleService.Store.Load()
+	if err != nil {
+		return err
+	}
+	ruleService.loaded = true
+	for _, ruleId := range snapshot.RuleIds {
+		ruleService.ruleIds[ruleId] = true
+	}
+	for _, ruleInfo := range snapshot.Rules {
+		rule, err := compileRule(ruleInfo)
+		if err != nil {
+			logger.Default().Warn("stored rule is invalid, ignore it", logger.String("rule_id", ruleInfo.RuleId), logger.Err(err))
+			continue
+		}
+		ruleService.ruleIds[ruleInfo.RuleId] = true
+		ruleService.rules[ruleInfo.RuleId] = rule
+	}
+	logger.Default().Info("load rules from store success", logger.Int("count", len(ruleService.rules)))
+	return nil
+}
+
+// RuleIds 平台下发的规则ID，替代之前的RuleIdList字段
+func (ruleService *RuleManageService) RuleIds() []string {
+	ruleService.lock.RLock()
+	defer ruleService.lock.RUnlock()
+	return ruleService.ruleIdsLocked()
+}
+
+// Rules 当前生效的规则
+func (ruleService *RuleManageService) Rules() []model.RuleInfo {
+	ruleService.lock.RLock()
+	defer ruleService.lock.RUnlock()
+	return ruleService.rulesLocked()
+}
+
+// Rule 按规则ID查询生效的规则，替代之前的RuleInfoMap字段
+func (ruleService *RuleManageService) Rule(ruleId string) (model.RuleInfo, bool) {
+	ruleService.lock.RLock()
+	defer ruleService.lock.RUnlock()
+	rule, ok := ruleService.rules[ruleId]
+	if !ok {
+		return model.RuleInfo{}, false
+	}
+	return rule.info, true
+}
+
+// TimerRules 定时器正在运行的规则ID，替代之前的TimerRuleMap字段，Stop之后为空
+func (ruleService *RuleManageService) TimerRules() []string {
+	ruleService.lock.RLock()
+	defer ruleService.lock.RUnlock()
+	ruleIds := make([]string, 0, len(ruleService.timerRules))
+	for ruleId := range ruleService.timerRules {
+		ruleIds = append(ruleIds, ruleId)
+	}
+	sort.Strings(ruleIds)
+	return ruleIds
+}
+
+func (ruleService *RuleManageService) ruleIdsLocked() []string {
+	ruleIds := make([]string, 0, len(ruleService.ruleIds))
+	for ruleId := range ruleService.ruleIds {
+		ruleIds = append(ruleIds, ruleId)
+	}
+	sort.Strings(ruleIds)
+	return ruleIds
+}
+
+func (ruleService *RuleManageService) rulesLocked() []model.RuleInfo {
+	rules := make([]model.RuleInfo, 0, len(ruleService.rules))
+	for _, rule := range ruleService.compiledRulesLocked() {
+		rules = append(rules, rule.info)
+	}
+	return rules
+}
+
+func (ruleService *RuleManageService) compiledRulesLocked() []*compiledRule {
+	rules := make([]*compiledRule, 0, len(ruleService.rules))
+	for _, rule := range ruleService.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].info.RuleId < rules[j].info.RuleId
+	})
+	return rules
+}
+
+// SyncRules 重新向平台请求所有已知规则，平台只返回版本有变化的规则，建链成功后调用
+func (ruleService *RuleManageService) SyncRules(ruleRequest callback.ReportRuleDelete) {
+	ruleIds := ruleService.RuleIds()
+	if len(ruleIds) == 0 {
+		return
+	}
+	ruleService.reportRuleEvent(ruleIds, make([]string, 0), ruleRequest)
+}
+
+// persist 保存当前的规则，失败时只记录日志，内存中的规则继续生效
+func (ruleService *RuleManageService) persist() {
+	if ruleService.Store == nil {
+		return
+	}
+	ruleService.persistLock.Lock()
+	defer ruleService.persistLock.Unlock()
+	ruleService.lock.RLock()
+	snapshot := store.RuleSnapshot{
+		RuleIds: ruleService.ruleIdsLocked(),
+		Rules:   ruleService.rulesLocked(),
+	}
+	ruleService.lock.RUnlock()
+	if err := ruleService.Store.Save(snapshot); err != nil {
+		logger.Default().Warn("save rules failed", logger.Err(err))
+	}
 }
 
 func (ruleService *RuleManageService) ModifyRule(service model.DevicePropertyDownRequestEntry, ruleDelete callback.ReportRuleDelete) {
@@ -53,47 +240,46 @@ func (ruleService *RuleManageService) ModifyRule(service model.DevicePropertyDow
 	var ruleProperties map[string]model.RuleVersion
 	err := json.Unmarshal([]byte(iot.Interface2JsonString(service.Properties)), &ruleProperties)
 	if err != nil {
-		glog.Warning("modify rule failed. properties is : %s", service.Properties)
+		logger.Default().Warn("modify rule failed", logger.Any("properties", service.Properties), logger.Err(err))
 		return
 	}
 	var ruleIdDel []string
+	var stopped []*TimerRuleInstance
+	ruleService.lock.Lock()
 	for key, value := range ruleProperties {
-		version := value.Version
-		if version != -1 {
-			if !ruleService.RuleIdList[key] {
-				ruleService.RuleIdList[key] = true
-			}
-		} else {
-			if ruleService.RuleIdList[key] {
-				delete(ruleService.RuleIdList, key)
-			}
-			_, ok := ruleService.RuleInfoMap[key]
-			if ok {
-				delete(ruleService.RuleInfoMap, key)
-			}
-			timerRule, ok := ruleService.TimerRuleMap[key]
-			if ok {
-				timerRule.ShutdownTimer()
-			}
-			ruleIdDel = append(ruleIdDel, key)
+		if value.Version != -1 {
+			ruleService.ruleIds[key] = true
+			continue
+		}
+		delete(ruleService.ruleIds, key)
+		delete(ruleService.rules, key)
+		if timerRule, ok := ruleService.timerRules[key]; ok {
+			stopped = append(stopped, timerRule)
+			delete(ruleService.timerRules, key)
 		}
+		ruleIdDel = append(ruleIdDel, key)
 	}
-	ruleService.reportRuleEvent(ruleIdDel, ruleDelete)
+	ruleIds := ruleService.ruleIdsLocked()
+	ruleService.lock.Unlock()
+
+	for _, timerRule := range stopped {
+		timerRule.ShutdownTimer()
+	}
+	ruleService.resetTriggers(ruleIdDel)
+	ruleService.persist()
+	ruleService.reportRuleEvent(ruleIds, ruleIdDel, ruleDelete)
 }
 
-func (ruleService *RuleManageService) reportRuleEvent(ruleIdDel []string, ruleDelete callback.ReportRuleDelete) {
+func (ruleService *RuleManageService) reportRuleEvent(ruleIds, ruleIdDel []string, ruleDelete callback.ReportRuleDelete) {
 	deviceRuleEvent := model.DeviceRuleEvent{}
 	deviceRuleEvent.ServiceId = "$device_rule"
 	deviceRuleEvent.EventType = "device_rule_config_request"
 	deviceRuleEvent.EventTime = iot.GetEventTimeStamp()
 
 	params := model.DeviceRuleRequestEventParams{
-		RuleIds: make([]string, 0),
+		RuleIds: ruleIds,
 		DelIds:  ruleIdDel,
 	}
-	for key := range ruleService.RuleIdList {
-		params.RuleIds = append(params.RuleIds, key)
-	}
 	deviceRuleEvent.Paras = params
 	var eventList []model.DeviceRuleEvent
 	eventList = append(eventList, deviceRuleEvent)
@@ -101,94 +287,185 @@ func (ruleService *RuleManageService) reportRuleEvent(ruleIdDel []string, ruleDe
 		Services: eventList,
 	}
 	b := ruleDelete(deviceEvents)
-	glog.Infof("modify rule result: %v", b)
+	logger.Default().Info("modify rule finished", logger.Any("result", b))
 }
 
+// QueryRuleResponse 更新平台下发的规则，handler只在Start之前使用，Start之后定时规则使用Start时设置的handler
 func (ruleService *RuleManageService) QueryRuleResponse(ruleInfos []model.RuleInfo, handler callback.RuleActionHandler) {
 	if len(ruleInfos) <= 0 {
-		glog.Warningf("rule info length is below 0.")
+		logger.Default().Warn("rule info is empty")
 		return
 	}
+	var updated []model.RuleInfo
+	var stopped []*TimerRuleInstance
+	var changed []string
+	ruleService.lock.Lock()
+	if ruleService.handler == nil {
+		ruleService.handler = handler
+	}
 	for _, ruleInfo := range ruleInfos {
-		_, ruleIdExist := ruleService.RuleIdList[ruleInfo.RuleId]
-		oldRuleInfo, ruleInfoExist := ruleService.RuleInfoMap[ruleInfo.RuleId]
+		oldRule, ruleExist := ruleService.rules[ruleInfo.RuleId]
 		if ruleInfo.Status == "inactive" {
-			if ruleIdExist {
-				delete(ruleService.RuleIdList, ruleInfo.RuleId)
-			}
-			if ruleInfoExist {
-				delete(ruleService.RuleInfoMap, ruleInfo.RuleId)
+			delete(ruleService.ruleIds, ruleInfo.RuleId)
+			delete(ruleService.rules, ruleInfo.RuleId)
+			if timerRule, ok := ruleService.timerRules[ruleInfo.RuleId]; ok {
+				stopped = append(stopped, timerRule)
+				delete(ruleService.timerRules, ruleInfo.RuleId)
 			}
+			changed = append(changed, ruleInfo.RuleId)
 			continue
 		}
 		ruleVersion := ruleInfo.RuleVersionInShadow
-		if ruleInfoExist && oldRuleInfo.RuleVersionInShadow >= ruleVersion {
-			glog.Infof("rule version is not change. no need to refresh.")
+		if ruleExist && oldRule.info.RuleVersionInShadow >= ruleVersion {
+			logger.Default().Info("rule version is not change, no need to refresh", logger.String("rule_id", ruleInfo.RuleId))
 			continue
 		}
-		ruleService.RuleInfoMap[ruleInfo.RuleId] = ruleInfo
-		ruleService.submitTimerRule(ruleInfo, handler)
+		rule, err := compileRule(ruleInfo)
+		if err != nil {
+			logger.Default().Warn("rule is invalid, ignore it", logger.String("rule_id", ruleInfo.RuleId), logger.Err(err))
+			continue
+		}
+		ruleService.ruleIds[ruleInfo.RuleId] = true
+		ruleService.rules[ruleInfo.RuleId] = rule
+		if timerRule, ok := ruleService.timerRules[ruleInfo.RuleId]; ok {
+			stopped = append(stopped, timerRule)
+			delete(ruleService.timerRules, ruleInfo.RuleId)
+		}
+		updated = append(updated, ruleInfo)
+		changed = append(changed, ruleInfo.RuleId)
 	}
+	started := ruleService.started
+	ruleService.lock.Unlock()
+
+	for _, timerRule := range stopped {
+		timerRule.ShutdownTimer()
+	}
+	ruleService.resetTriggers(changed)
+	if started {
+		for _, ruleInfo := range updated {
+			ruleService.startTimerRule(ruleInfo)
+		}
+	}
+	ruleService.persist()
 }
 
+// HandleRule 用上报的属性计算规则的条件，按规则的触发方式决定是否执行动作，每个规则每次上报最多执行一次。
+// 条件引用的属性都没有上报时不改变规则的状态
 func (ruleService *RuleManageService) HandleRule(services []model.DevicePropertyEntry, handler callback.RuleActionHandler) {
-	for _, ruleInfo := range ruleService.RuleInfoMap {
+	data := propertyData(services)
+	ruleService.lock.RLock()
+	rules := ruleService.compiledRulesLocked()
+	ruleService.lock.RUnlock()
+	for _, rule := range rules {
+		ruleInfo := rule.info
+		values := conditionValues(data, rule.conditions...)
+		if len(values) == 0 {
+			continue
+		}
 		if !checkTimeRange(ruleInfo.TimeRange) {
-			glog.Warningf("rule not match the time.")
+			logger.Default().Warn("rule not match the time", logger.String("rule_id", ruleInfo.RuleId))
 			continue
 		}
-		conditions := ruleInfo.Conditions
-		logic := ruleInfo.Logic
-		if strings.EqualFold("or", logic) {
-			for _, condition := range conditions {
-				satisfied := ruleService.ConditionExecute.isConditionSatisfied(condition, services)
-				if satisfied {
-					handler(ruleInfo.Actions)
-				}
-			}
-		} else if strings.EqualFold("and", logic) {
-			isSatisfied := true
-			for _, condition := range conditions {
-				satisfied := ruleService.ConditionExecute.isConditionSatisfied(condition, services)
-				if !satisfied {
-					isSatisfied = false
-				}
-			}
-			if isSatisfied {
-				handler(ruleInfo.Actions)
-			}
-		} else {
-			glog.Warningf("rule logic is not match. logic: %s", logic)
+		satisfied, ok := ruleService.ruleSatisfied(rule, data)
+		if !ok {
+			logger.Default().Warn("rule logic is not match", logger.String("logic", ruleInfo.Logic))
+			continue
+		}
+		if ruleService.evaluateTrigger(ruleInfo, satisfied, values, handler) {
+			ruleService.execute(ruleInfo.RuleId, TriggerDeviceData, values, ruleActions(ruleInfo), handler)
 		}
 	}
 }
 
-func (ruleService *RuleManageService) submitTimerRule(ruleInfo model.RuleInfo, handler callback.RuleActionHandler) {
-	conditionList := ruleInfo.Conditions
-	isTimerRule := false
-	for _, condition := range conditionList {
-		if strings.EqualFold("DAILY_TIMER", condition.Type) || strings.EqualFold("SIMPLE_TIMER", condition.Type) {
-			isTimerRule = true
-			break
+// ruleSatisfied or满足任一条件、and满足全部条件时返回true，logic不支持时ok为false
+func (ruleService *RuleManageService) ruleSatisfied(rule *compiledRule, data map[string]interface{}) (satisfied bool, ok bool) {
+	switch strings.ToLower(rule.info.Logic) {
+	case "or":
+		for _, expression := range rule.conditions {
+			if ruleService.conditionSatisfied(rule.info.RuleId, expression, data) {
+				return true, true
+			}
 		}
+		return false, true
+	case "and":
+		for _, expression := range rule.conditions {
+			if !ruleService.conditionSatisfied(rule.info.RuleId, expression, data) {
+				return false, true
+			}
+		}
+		return true, true
 	}
-	if !isTimerRule {
+	return false, false
+}
+
+// startTimerRule 为定时规则创建调度器，创建期间规则被更新、删除或服务被停止时丢弃新建的调度器
+func (ruleService *RuleManageService) startTimerRule(ruleInfo model.RuleInfo) {
+	ruleService.lock.RLock()
+	handler := ruleService.handler
+	ruleService.lock.RUnlock()
+	if handler == nil {
 		return
 	}
-	if isTimerRule && len(conditionList) > 1 && strings.EqualFold("and", ruleInfo.Logic) {
-		glog.Warningf("multy timer rule only support or logic. ruleId: %s", ruleInfo.RuleId)
+	timerRule := newTimerRuleInstance(ruleInfo, func(actions []model.Action) bool {
+		return ruleService.execute(ruleInfo.RuleId, TriggerTimer, nil, actions, handler)
+	})
+	if timerRule == nil {
 		return
 	}
-	timerRule, exist := ruleService.TimerRuleMap[ruleInfo.RuleId]
-	if exist {
+	timerRule.Start()
+
+	ruleService.lock.Lock()
+	current, exist := ruleService.rules[ruleInfo.RuleId]
+	_, running := ruleService.timerRules[ruleInfo.RuleId]
+	if !ruleService.started || !exist || running || current.info.RuleVersionInShadow != ruleInfo.RuleVersionInShadow {
+		ruleService.lock.Unlock()
 		timerRule.ShutdownTimer()
-		delete(ruleService.TimerRuleMap, ruleInfo.RuleId)
+		return
+	}
+	ruleService.timerRules[ruleInfo.RuleId] = timerRule
+	ruleService.lock.Unlock()
+}
+
+// conditionSatisfied 计算条件，属性值类型不支持条件中的运算时记录告警并认为不满足
+func (ruleService *RuleManageService) conditionSatisfied(ruleId string, expression *Expression, data map[string]interface{}) bool {
+	satisfied, err := ruleService.ConditionExecute.isConditionSatisfied(expression, data)
+	if err != nil {
+		logger.Default().Warn("evaluate rule condition failed", logger.String("rule_id", ruleId), logger.Err(err))
+		return false
 	}
-	timerRule = TimerRuleInstance{
-		schedule: gocron.NewScheduler(time.UTC),
-		jobMap:   make(map[string]*gocron.Job),
+	if satisfied {
+		logger.Default().Info("match rule condition", logger.String("rule_id", ruleId))
 	}
-	timerRule.submitRule(ruleInfo, handler)
-	timerRule.Start()
-	ruleService.TimerRuleMap[ruleInfo.RuleId] = timerRule
+	return satisfied
+}
+
+// compiledRule 规则和编译后的DEVICE_DATA条件，规则加载或更新时编译，上报属性时直接计算
+type compiledRule struct {
+	info       model.RuleInfo
+	conditions []*Expression // 与info.Conditions一一对应，非DEVICE_DATA条件为nil
+}
+
+// compileRule 校验规则的触发方式并编译DEVICE_DATA条件，返回第一个错误
+func compileRule(ruleInfo model.RuleInfo) (*compiledRule, error) {
+	if err := validateTrigger(ruleInfo.Trigger); err != nil {
+		return nil, err
+	}
+	rule := &compiledRule{info: ruleInfo, conditions: make([]*Expression, len(ruleInfo.Conditions))}
+	for i, condition := range ruleInfo.Conditions {
+		if !strings.EqualFold(condition.Type, "DEVICE_DATA") {
+			continue
+		}
+		expression, err := CompileCondition(condition)
+		if err != nil {
+			return nil, fmt.Errorf("condition %d: %w", i, err)
+		}
+		rule.conditions[i] = expression
+	}
+	return rule, nil
+}
+
+// ValidateRule 校验规则的触发方式和DEVICE_DATA条件，返回第一个错误
+func ValidateRule(ruleInfo model.RuleInfo) error {
+	_, err := compileRule(ruleInfo)
+	return err
 }
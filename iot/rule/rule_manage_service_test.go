@@ -0,0 +1,80 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rule
+
+import (
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"testing"
+)
+
+func temperatureRule(version int, operator, value string) model.RuleInfo {
+	return model.RuleInfo{
+		RuleId: "rule_1",
+		Logic:  "and",
+		Status: "active",
+		Conditions: []model.Condition{
+			{Type: "DEVICE_DATA", Operator: operator, DeviceInfo: model.RuleDeviceInfo{Path: "sensor/temp"}, Value: value},
+		},
+		Actions:             []model.Action{{Type: "DEVICE_CMD", Status: "enable"}},
+		RuleVersionInShadow: version,
+	}
+}
+
+func TestHandleRuleUsesCompiledConditions(t *testing.T) {
+	service := NewRuleManageService(nil)
+	var fired int
+	handler := func(actions []model.Action) bool {
+		fired++
+		return true
+	}
+	report := []model.DevicePropertyEntry{{ServiceId: "sensor", Properties: map[string]interface{}{"temp": 35}}}
+
+	tests := []struct {
+		name   string
+		update []model.RuleInfo
+		fired  int
+	}{
+		{name: "add rule", update: []model.RuleInfo{temperatureRule(1, ">", "30")}, fired: 1},
+		{name: "same version ignored", update: []model.RuleInfo{temperatureRule(1, ">", "40")}, fired: 1},
+		{name: "update condition", update: []model.RuleInfo{temperatureRule(2, ">", "40")}, fired: 0},
+		{name: "invalid update keeps old rule", update: []model.RuleInfo{temperatureRule(3, "between", "40")}, fired: 0},
+		{name: "update operator", update: []model.RuleInfo{temperatureRule(4, "between", "30,40")}, fired: 1},
+		{name: "inactive rule removed", update: []model.RuleInfo{{RuleId: "rule_1", Status: "inactive"}}, fired: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service.QueryRuleResponse(tt.update, handler)
+			fired = 0
+			service.HandleRule(report, handler)
+			if fired != tt.fired {
+				t.Errorf("fired %d times, want %d", fired, tt.fired)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/callback"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"strings"
+	"time"
+)
+
+// 属性触发的规则的触发模式，对应RuleTrigger.Mode
+const (
+	TriggerModeLevel = "LEVEL" // 每次上报的属性满足条件时都执行
+	TriggerModeEdge  = "EDGE"  // 条件从不满足变为满足时执行一次
+)
+
+// ErrInvalidTrigger 规则的触发方式不合法
+var ErrInvalidTrigger = errors.New("invalid rule trigger")
+
+// triggerState 规则条件的状态，条件变为不满足或规则被更新时重置
+type triggerState struct {
+	satisfied bool
+	since     time.Time              // 条件开始持续满足的时间
+	fired     bool                   // 本次持续满足期间是否已经执行
+	lastFired time.Time              // 上次执行的时间，用于冷却时间的判断
+	values    map[string]interface{} // 最近一次满足条件时引用的属性值
+	timer     *time.Timer            // 等待条件持续满足到Duration
+}
+
+func (state *triggerState) stopTimer() {
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+}
+
+// shouldFire 条件满足时判断是否执行动作，执行时记录执行时间
+func (state *triggerState) shouldFire(trigger model.RuleTrigger, now time.Time) bool {
+	if trigger.Duration > 0 && now.Sub(state.since) < time.Duration(trigger.Duration)*time.Second {
+		return false
+	}
+	if strings.EqualFold(trigger.Mode, TriggerModeEdge) && state.fired {
+		return false
+	}
+	if trigger.Cooldown > 0 && !state.lastFired.IsZero() && now.Sub(state.lastFired) < time.Duration(trigger.Cooldown)*time.Second {
+		return false
+	}
+	state.fired = true
+	state.lastFired = now
+	return true
+}
+
+// ruleTrigger 规则设置了Trigger时使用规则的配置，否则使用DefaultTrigger
+func (ruleService *RuleManageService) ruleTrigger(ruleInfo model.RuleInfo) model.RuleTrigger {
+	if ruleInfo.Trigger != nil {
+		return *ruleInfo.Trigger
+	}
+	return ruleService.DefaultTrigger
+}
+
+// evaluateTrigger 根据本次条件的计算结果更新规则的状态，返回是否执行动作。
+// 设置了Duration时，条件开始满足后启动定时器，持续满足到时间后即使没有新的上报也会执行
+func (ruleService *RuleManageService) evaluateTrigger(ruleInfo model.RuleInfo, satisfied bool, values map[string]interface{}, handler callback.RuleActionHandler) bool {
+	trigger := ruleService.ruleTrigger(ruleInfo)
+	now := time.Now()
+	ruleService.triggerLock.Lock()
+	defer ruleService.triggerLock.Unlock()
+	state, ok := ruleService.triggers[ruleInfo.RuleId]
+	if !ok {
+		state = &triggerState{}
+		ruleService.triggers[ruleInfo.RuleId] = state
+	}
+	if !satisfied {
+		state.satisfied = false
+		state.fired = false
+		state.stopTimer()
+		return false
+	}
+	state.values = values
+	if !state.satisfied {
+		state.satisfied = true
+		state.since = now
+		state.fired = false
+		if trigger.Duration > 0 {
+			since := now
+			state.timer = time.AfterFunc(time.Duration(trigger.Duration)*time.Second, func() {
+				ruleService.durationElapsed(ruleInfo, state, since, handler)
+			})
+		}
+	}
+	return state.shouldFire(trigger, now)
+}
+
+// durationElapsed 条件持续满足到Duration时执行动作，期间条件不再满足或规则被更新时忽略
+func (ruleService *RuleManageService) durationElapsed(ruleInfo model.RuleInfo, state *triggerState, since time.Time, handler callback.RuleActionHandler) {
+	ruleService.triggerLock.Lock()
+	current, ok := ruleService.triggers[ruleInfo.RuleId]
+	if !ok || current != state || !state.satisfied || !state.since.Equal(since) {
+		ruleService.triggerLock.Unlock()
+		return
+	}
+	state.timer = nil
+	fire := state.shouldFire(ruleService.ruleTrigger(ruleInfo), time.Now())
+	values := state.values
+	ruleService.triggerLock.Unlock()
+	if fire {
+		ruleService.execute(ruleInfo.RuleId, TriggerDeviceData, values, ruleActions(ruleInfo), handler)
+	}
+}
+
+// stopTriggers 停止等待持续时间的定时器并清空全部规则的状态
+func (ruleService *RuleManageService) stopTriggers() {
+	ruleService.triggerLock.Lock()
+	defer ruleService.triggerLock.Unlock()
+	for _, state := range ruleService.triggers {
+		state.stopTimer()
+	}
+	ruleService.triggers = make(map[string]*triggerState)
+}
+
+// resetTriggers 规则被更新或删除后重置规则的状态
+func (ruleService *RuleManageService) resetTriggers(ruleIds []string) {
+	ruleService.triggerLock.Lock()
+	defer ruleService.triggerLock.Unlock()
+	for _, ruleId := range ruleIds {
+		if state, ok := ruleService.triggers[ruleId]; ok {
+			state.stopTimer()
+			delete(ruleService.triggers, ruleId)
+		}
+	}
+}
+
+func validateTrigger(trigger *model.RuleTrigger) error {
+	if trigger == nil {
+		return nil
+	}
+	if trigger.Mode != "" && !strings.EqualFold(trigger.Mode, TriggerModeLevel) && !strings.EqualFold(trigger.Mode, TriggerModeEdge) {
+		return fmt.Errorf("%w: unsupported mode %q", ErrInvalidTrigger, trigger.Mode)
+	}
+	if trigger.Cooldown < 0 || trigger.Duration < 0 {
+		return fmt.Errorf("%w: cooldown and duration must not be negative", ErrInvalidTrigger)
+	}
+	return nil
+}
@@ -29,7 +29,7 @@
 package rule
 
 import (
-	"github.com/golang/glog"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
 	"strconv"
 	"strings"
@@ -71,23 +71,23 @@ func checkTimeRange(timeRange model.TimeRange) bool {
 	endTimeList := strings.Split(endTime, ":")
 	beginHour, err := strconv.Atoi(beginTimeList[0])
 	if err != nil {
-		glog.Warningf("start time format is invalid. startTime: %s", startTime)
+		logger.Default().Warn("start time format is invalid", logger.String("start_time", startTime))
 		return false
 	}
 	beginMinute, err := strconv.Atoi(beginTimeList[1])
 	if err != nil {
-		glog.Warningf("start time format is invalid. startTime: %s", startTime)
+		logger.Default().Warn("start time format is invalid", logger.String("start_time", startTime))
 		return false
 	}
 
 	endHour, err := strconv.Atoi(endTimeList[0])
 	if err != nil {
-		glog.Warningf("end time format is invalid. startTime: %s", startTime)
+		logger.Default().Warn("end time format is invalid", logger.String("end_time", endTime))
 		return false
 	}
 	endMinute, err := strconv.Atoi(endTimeList[1])
 	if err != nil {
-		glog.Warningf("end time format is invalid. startTime: %s", startTime)
+		logger.Default().Warn("end time format is invalid", logger.String("end_time", endTime))
 		return false
 	}
 
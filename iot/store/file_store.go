@@ -0,0 +1,367 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package store
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	recordPut  byte = 1
+	recordTrim byte = 2
+
+	recordHeaderSize = 8
+	maxRecordSize    = 64 * 1024 * 1024
+	// 已删除的消息超过该数量且多于剩余消息时压缩日志文件
+	compactMinTrimmed = 1024
+)
+
+// FileStoreOptions 文件缓存参数
+type FileStoreOptions struct {
+	Policy EvictionPolicy
+	NoSync bool // 写入消息后不调用fsync，性能更好但掉电时可能丢失最近写入的消息
+}
+
+// walRecord 预写日志中的一条记录，put表示新增消息，trim表示删除序号小于等于Seq的消息
+type walRecord struct {
+	Op      byte   `json:"op"`
+	Seq     uint64 `json:"seq"`
+	Time    int64  `json:"time,omitempty"`
+	Topic   string `json:"topic,omitempty"`
+	Qos     byte   `json:"qos,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type fileStore struct {
+	path    string
+	options FileStoreOptions
+	file    *os.File
+	entries list.List
+	bytes   int64
+	seq     uint64
+	trimmed int
+	lock    sync.Mutex
+}
+
+// NewFileStore 创建基于预写日志文件的消息缓存，进程重启后会从文件中恢复未发送的消息
+func NewFileStore(path string, options FileStoreOptions) (MessageStore, error) {
+	if len(path) == 0 {
+		return nil, errors.New("file store path is empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	fs := &fileStore{
+		path:    path,
+		options: options,
+	}
+	if err := fs.load(); err != nil {
+		return nil, err
+	}
+	logger.Default().Info("load buffer messages from file success", logger.String("path", path), logger.Int("count", fs.entries.Len()))
+	return fs, nil
+}
+
+func (fs *fileStore) Push(message model.BufferMessage) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if fs.file == nil {
+		return os.ErrClosed
+	}
+	now := time.Now()
+	record := walRecord{
+		Op:      recordPut,
+		Seq:     fs.seq + 1,
+		Time:    now.UnixNano() / int64(time.Millisecond),
+		Topic:   message.Topic,
+		Qos:     message.Qos,
+		Message: message.Message,
+	}
+	if err := fs.writeRecord(record, !fs.options.NoSync); err != nil {
+		return err
+	}
+	fs.apply(record)
+	return fs.evict(now)
+}
+
+func (fs *fileStore) Peek() (Entry, bool, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if err := fs.evict(time.Now()); err != nil {
+		return Entry{}, false, err
+	}
+	front := fs.entries.Front()
+	if front == nil {
+		return Entry{}, false, nil
+	}
+	return front.Value.(Entry), true, nil
+}
+
+func (fs *fileStore) Ack(seq uint64) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	front := fs.entries.Front()
+	if front == nil || front.Value.(Entry).Seq > seq {
+		return nil
+	}
+	return fs.trim(seq)
+}
+
+func (fs *fileStore) Len() int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.entries.Len()
+}
+
+func (fs *fileStore) Close() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	return err
+}
+
+func (fs *fileStore) load() error {
+	file, err := os.OpenFile(fs.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		record, size, err := readRecord(reader)
+		if err != nil {
+			if err != io.EOF {
+				logger.Default().Warn("buffer file is broken, drop the tail", logger.String("path", fs.path), logger.Any("offset", offset), logger.Err(err))
+			}
+			break
+		}
+		fs.apply(record)
+		offset += size
+	}
+	// 掉电可能导致最后一条记录写入不完整，截断到最后一条完整记录
+	if err := file.Truncate(offset); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return err
+	}
+	fs.file = file
+	if err := fs.evict(time.Now()); err != nil {
+		return err
+	}
+	if fs.trimmed > 0 {
+		return fs.compact()
+	}
+	return nil
+}
+
+func (fs *fileStore) apply(record walRecord) {
+	switch record.Op {
+	case recordPut:
+		message := model.BufferMessage{
+			Topic:   record.Topic,
+			Qos:     record.Qos,
+			Message: record.Message,
+		}
+		fs.entries.PushBack(Entry{
+			Seq:     record.Seq,
+			Time:    time.Unix(0, record.Time*int64(time.Millisecond)),
+			Message: message,
+		})
+		fs.bytes += messageSize(message)
+		if record.Seq > fs.seq {
+			fs.seq = record.Seq
+		}
+	case recordTrim:
+		for front := fs.entries.Front(); front != nil && front.Value.(Entry).Seq <= record.Seq; front = fs.entries.Front() {
+			fs.bytes -= messageSize(front.Value.(Entry).Message)
+			fs.entries.Remove(front)
+			fs.trimmed++
+		}
+	}
+}
+
+// evict 按淘汰策略删除最早的消息
+func (fs *fileStore) evict(now time.Time) error {
+	var evictSeq uint64
+	count := fs.entries.Len()
+	bytes := fs.bytes
+	for element := fs.entries.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(Entry)
+		if !fs.options.Policy.overflow(count, bytes) && !fs.options.Policy.expired(entry, now) {
+			break
+		}
+		evictSeq = entry.Seq
+		count--
+		bytes -= messageSize(entry.Message)
+	}
+	if evictSeq == 0 {
+		return nil
+	}
+	logger.Default().Warn("buffer is full or message expired, drop messages", logger.Int("count", fs.entries.Len()-count))
+	return fs.trim(evictSeq)
+}
+
+func (fs *fileStore) trim(seq uint64) error {
+	record := walRecord{
+		Op:  recordTrim,
+		Seq: seq,
+	}
+	// 删除记录丢失只会导致消息重复发送，不需要每次都刷盘
+	if err := fs.writeRecord(record, false); err != nil {
+		return err
+	}
+	fs.apply(record)
+	if fs.trimmed >= compactMinTrimmed && fs.trimmed > fs.entries.Len() {
+		return fs.compact()
+	}
+	return nil
+}
+
+// compact 只保留未删除的消息重写日志文件
+func (fs *fileStore) compact() error {
+	tmpPath := fs.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmpFile)
+	for element := fs.entries.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(Entry)
+		data, err := encodeRecord(walRecord{
+			Op:      recordPut,
+			Seq:     entry.Seq,
+			Time:    entry.Time.UnixNano() / int64(time.Millisecond),
+			Topic:   entry.Message.Topic,
+			Qos:     entry.Message.Qos,
+			Message: entry.Message.Message,
+		})
+		if err == nil {
+			_, err = writer.Write(data)
+		}
+		if err != nil {
+			_ = tmpFile.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := os.Rename(tmpPath, fs.path); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	_ = fs.file.Close()
+	fs.file = tmpFile
+	fs.trimmed = 0
+	return nil
+}
+
+func (fs *fileStore) writeRecord(record walRecord, sync bool) error {
+	if fs.file == nil {
+		return os.ErrClosed
+	}
+	data, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.file.Write(data); err != nil {
+		return err
+	}
+	if sync {
+		return fs.file.Sync()
+	}
+	return nil
+}
+
+// 记录格式：4字节长度 + 4字节crc32校验 + json内容
+func encodeRecord(record walRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(payload))
+	copy(data[recordHeaderSize:], payload)
+	return data, nil
+}
+
+func readRecord(reader io.Reader) (walRecord, int64, error) {
+	record := walRecord{}
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return record, 0, errors.New("record header is incomplete")
+		}
+		return record, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return record, 0, errors.New("record length is invalid")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return record, 0, errors.New("record payload is incomplete")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return record, 0, errors.New("record checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, 0, err
+	}
+	return record, int64(recordHeaderSize + len(payload)), nil
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package store
+
+import (
+	"fmt"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func message(i int) model.BufferMessage {
+	return model.BufferMessage{Topic: "$oc/devices/d1/sys/messages/up", Qos: 1, Message: fmt.Sprintf("message-%d", i)}
+}
+
+// pushMessages 写入count条消息，返回每条记录写入后的文件大小
+func pushMessages(t *testing.T, fs MessageStore, path string, count int) []int64 {
+	t.Helper()
+	var offsets []int64
+	for i := 1; i <= count; i++ {
+		if err := fs.Push(message(i)); err != nil {
+			t.Fatalf("push message %d: %v", i, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, info.Size())
+	}
+	return offsets
+}
+
+func drain(t *testing.T, fs MessageStore) []string {
+	t.Helper()
+	var messages []string
+	for {
+		entry, ok, err := fs.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return messages
+		}
+		messages = append(messages, entry.Message.Message)
+		if err := fs.Ack(entry.Seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileStoreRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.wal")
+	fs, err := NewFileStore(path, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushMessages(t, fs, path, 3)
+	entry, _, _ := fs.Peek()
+	if err := fs.Ack(entry.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Push(message(4)); err != os.ErrClosed {
+		t.Fatalf("push after close: %v, want %v", err, os.ErrClosed)
+	}
+
+	fs, err = NewFileStore(path, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if fs.Len() != 2 {
+		t.Fatalf("recovered %d messages, want 2", fs.Len())
+	}
+	// 恢复后序号继续递增
+	if err := fs.Push(message(4)); err != nil {
+		t.Fatal(err)
+	}
+	entry, _, _ = fs.Peek()
+	if entry.Seq != 2 {
+		t.Fatalf("first seq %d, want 2", entry.Seq)
+	}
+	got := drain(t, fs)
+	want := []string{"message-2", "message-3", "message-4"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("messages %v, want %v", got, want)
+	}
+}
+
+func TestFileStoreTornRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(data []byte, last int64) []byte // last为最后一条记录的起始位置
+	}{
+		{"partial header", func(data []byte, last int64) []byte {
+			return data[:last+recordHeaderSize/2]
+		}},
+		{"partial payload", func(data []byte, last int64) []byte {
+			return data[:len(data)-3]
+		}},
+		{"checksum mismatch", func(data []byte, last int64) []byte {
+			data[len(data)-2] ^= 0xff
+			return data
+		}},
+		{"invalid length", func(data []byte, last int64) []byte {
+			data[last] = 0xff
+			return data
+		}},
+		{"garbage tail", func(data []byte, last int64) []byte {
+			return append(data, 0, 0, 0)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "buffer.wal")
+			fs, err := NewFileStore(path, FileStoreOptions{NoSync: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			offsets := pushMessages(t, fs, path, 3)
+			fs.Close()
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = test.corrupt(data, offsets[1])
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			fs, err = NewFileStore(path, FileStoreOptions{NoSync: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantLen := 2
+			wantSize := offsets[1]
+			if test.name == "garbage tail" {
+				wantLen, wantSize = 3, offsets[2]
+			}
+			if fs.Len() != wantLen {
+				t.Fatalf("recovered %d messages, want %d", fs.Len(), wantLen)
+			}
+			// 不完整的记录被截断，之后写入的记录可以被正常读取
+			if info, _ := os.Stat(path); info.Size() != wantSize {
+				t.Fatalf("file size %d, want %d", info.Size(), wantSize)
+			}
+			if err := fs.Push(message(9)); err != nil {
+				t.Fatal(err)
+			}
+			fs.Close()
+			fs, err = NewFileStore(path, FileStoreOptions{NoSync: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fs.Close()
+			got := drain(t, fs)
+			if len(got) != wantLen+1 || got[len(got)-1] != "message-9" {
+				t.Fatalf("messages %v after recovery", got)
+			}
+		})
+	}
+}
+
+func TestFileStoreEviction(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EvictionPolicy
+		want   []string
+	}{
+		{"max messages", EvictionPolicy{MaxMessages: 2}, []string{"message-3", "message-4"}},
+		{"max bytes", EvictionPolicy{MaxBytes: messageSize(message(1)) * 3}, []string{"message-2", "message-3", "message-4"}},
+		{"no limit", EvictionPolicy{}, []string{"message-1", "message-2", "message-3", "message-4"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "buffer.wal")
+			fs, err := NewFileStore(path, FileStoreOptions{Policy: test.policy, NoSync: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pushMessages(t, fs, path, 4)
+			fs.Close()
+			// 淘汰的消息在重启后不会恢复
+			fs, err = NewFileStore(path, FileStoreOptions{Policy: test.policy, NoSync: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fs.Close()
+			if got := drain(t, fs); fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Fatalf("messages %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package store
+
+import (
+	"container/list"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	policy EvictionPolicy
+	queue  list.List
+	bytes  int64
+	seq    uint64
+	lock   sync.Mutex
+}
+
+// NewMemoryStore 创建内存缓存，进程重启后缓存的消息会丢失
+func NewMemoryStore(policy EvictionPolicy) MessageStore {
+	return &memoryStore{
+		policy: policy,
+	}
+}
+
+func (ms *memoryStore) Push(message model.BufferMessage) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.seq++
+	ms.queue.PushBack(Entry{
+		Seq:     ms.seq,
+		Time:    time.Now(),
+		Message: message,
+	})
+	ms.bytes += messageSize(message)
+	ms.evict(time.Now())
+	return nil
+}
+
+func (ms *memoryStore) Peek() (Entry, bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.evict(time.Now())
+	front := ms.queue.Front()
+	if front == nil {
+		return Entry{}, false, nil
+	}
+	return front.Value.(Entry), true, nil
+}
+
+func (ms *memoryStore) Ack(seq uint64) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	for front := ms.queue.Front(); front != nil && front.Value.(Entry).Seq <= seq; front = ms.queue.Front() {
+		ms.remove(front)
+	}
+	return nil
+}
+
+func (ms *memoryStore) Len() int {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.queue.Len()
+}
+
+func (ms *memoryStore) Close() error {
+	return nil
+}
+
+func (ms *memoryStore) evict(now time.Time) {
+	for front := ms.queue.Front(); front != nil; front = ms.queue.Front() {
+		if !ms.policy.overflow(ms.queue.Len(), ms.bytes) && !ms.policy.expired(front.Value.(Entry), now) {
+			return
+		}
+		ms.remove(front)
+	}
+}
+
+func (ms *memoryStore) remove(element *list.Element) {
+	ms.bytes -= messageSize(element.Value.(Entry).Message)
+	ms.queue.Remove(element)
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRuleExecutions 默认保留的端侧规则执行记录条数
+const DefaultMaxRuleExecutions = 1000
+
+// RuleExecutionQuery 执行记录的查询条件，零值表示不限制
+type RuleExecutionQuery struct {
+	RuleId string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // 最多返回的条数，超过时返回最新的记录
+}
+
+// RuleExecutionStore 端侧规则执行记录的存储，超过容量时丢弃最早的记录
+type RuleExecutionStore interface {
+	// Append 追加一条执行记录
+	Append(execution model.RuleExecution) error
+
+	// Query 按时间从早到晚返回满足条件的执行记录
+	Query(query RuleExecutionQuery) ([]model.RuleExecution, error)
+
+	// Close 释放存储使用的文件等资源
+	Close() error
+}
+
+type memoryRuleExecutionStore struct {
+	lock       sync.RWMutex
+	capacity   int
+	executions []model.RuleExecution
+	next       int // 环形缓冲区中下一条记录的位置
+}
+
+// NewMemoryRuleExecutionStore 创建内存中的执行记录存储，capacity小于等于0时使用DefaultMaxRuleExecutions
+func NewMemoryRuleExecutionStore(capacity int) RuleExecutionStore {
+	return newMemoryRuleExecutionStore(capacity)
+}
+
+func newMemoryRuleExecutionStore(capacity int) *memoryRuleExecutionStore {
+	if capacity <= 0 {
+		capacity = DefaultMaxRuleExecutions
+	}
+	return &memoryRuleExecutionStore{capacity: capacity}
+}
+
+func (store *memoryRuleExecutionStore) Append(execution model.RuleExecution) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.append(execution)
+	return nil
+}
+
+func (store *memoryRuleExecutionStore) append(execution model.RuleExecution) {
+	if len(store.executions) < store.capacity {
+		store.executions = append(store.executions, execution)
+		return
+	}
+	store.executions[store.next] = execution
+	store.next = (store.next + 1) % store.capacity
+}
+
+func (store *memoryRuleExecutionStore) Query(query RuleExecutionQuery) ([]model.RuleExecution, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	var result []model.RuleExecution
+	for _, execution := range store.ordered() {
+		if query.match(execution) {
+			result = append(result, execution)
+		}
+	}
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[len(result)-query.Limit:]
+	}
+	return result, nil
+}
+
+// ordered 按追加顺序返回全部记录，调用方持有锁
+func (store *memoryRuleExecutionStore) ordered() []model.RuleExecution {
+	executions := make([]model.RuleExecution, 0, len(store.executions))
+	executions = append(executions, store.executions[store.next:]...)
+	return append(executions, store.executions[:store.next]...)
+}
+
+func (store *memoryRuleExecutionStore) Close() error {
+	return nil
+}
+
+func (query RuleExecutionQuery) match(execution model.RuleExecution) bool {
+	if len(query.RuleId) != 0 && query.RuleId != execution.RuleId {
+		return false
+	}
+	if !query.Since.IsZero() && execution.Time.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && execution.Time.After(query.Until) {
+		return false
+	}
+	return true
+}
+
+type fileRuleExecutionStore struct {
+	*memoryRuleExecutionStore
+	path  string
+	file  *os.File
+	lines int // 文件中的记录条数，超过两倍容量时重写文件
+}
+
+// NewFileRuleExecutionStore 创建以json lines文件保存执行记录的存储，进程重启后保留最近的capacity条记录。
+// 追加时不做fsync，掉电时可能丢失最后几条记录
+func NewFileRuleExecutionStore(path string, capacity int) (RuleExecutionStore, error) {
+	if len(path) == 0 {
+		return nil, errors.New("rule execution store path is empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	store := &fileRuleExecutionStore{
+		memoryRuleExecutionStore: newMemoryRuleExecutionStore(capacity),
+		path:                     path,
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if err := store.rewrite(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// load 读取文件中的记录，跳过掉电时写了一半的记录
+func (store *fileRuleExecutionStore) load() error {
+	file, err := os.Open(store.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var execution model.RuleExecution
+		if json.Unmarshal(scanner.Bytes(), &execution) != nil {
+			continue
+		}
+		store.append(execution)
+	}
+	return scanner.Err()
+}
+
+func (store *fileRuleExecutionStore) Append(execution model.RuleExecution) error {
+	data, err := json.Marshal(execution)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.file == nil {
+		return errors.New("rule execution store is closed")
+	}
+	store.append(execution)
+	if store.lines >= 2*store.capacity {
+		return store.rewrite()
+	}
+	if _, err := store.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	store.lines++
+	return nil
+}
+
+// rewrite 只保留内存中的记录重写文件，先写临时文件再重命名，调用方持有锁
+func (store *fileRuleExecutionStore) rewrite() error {
+	tmpPath := store.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmpFile)
+	executions := store.ordered()
+	for _, execution := range executions {
+		data, err := json.Marshal(execution)
+		if err != nil {
+			continue
+		}
+		_, _ = writer.Write(append(data, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, store.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(store.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if store.file != nil {
+		_ = store.file.Close()
+	}
+	store.file = file
+	store.lines = len(executions)
+	return nil
+}
+
+func (store *fileRuleExecutionStore) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.file == nil {
+		return nil
+	}
+	err := store.file.Close()
+	store.file = nil
+	return err
+}
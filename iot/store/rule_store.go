@@ -0,0 +1,110 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RuleSnapshot 持久化的端侧规则
+type RuleSnapshot struct {
+	RuleIds []string         `json:"ruleIds"` // 平台下发的规则ID，包括尚未获取到规则内容的规则
+	Rules   []model.RuleInfo `json:"rules"`   // 已获取到内容的规则，携带RuleVersionInShadow用于和平台对账
+}
+
+// RuleStore 端侧规则的持久化存储，设备重启后在连接平台之前从中恢复规则
+type RuleStore interface {
+	// Load 读取保存的规则，没有保存过时返回空的RuleSnapshot
+	Load() (RuleSnapshot, error)
+
+	// Save 覆盖保存全部规则
+	Save(snapshot RuleSnapshot) error
+}
+
+type fileRuleStore struct {
+	lock sync.Mutex
+	path string
+}
+
+// NewFileRuleStore 创建以json文件保存规则的存储，写入时先写临时文件再重命名，避免掉电时文件损坏
+func NewFileRuleStore(path string) (RuleStore, error) {
+	if len(path) == 0 {
+		return nil, errors.New("rule store path is empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return &fileRuleStore{path: path}, nil
+}
+
+func (store *fileRuleStore) Load() (RuleSnapshot, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	snapshot := RuleSnapshot{}
+	data, err := os.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal(data, &snapshot)
+	return snapshot, err
+}
+
+func (store *fileRuleStore) Save(snapshot RuleSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	tmpPath := store.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, store.path)
+}
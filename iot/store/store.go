@@ -0,0 +1,84 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package store
+
+import (
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"time"
+)
+
+// Entry 缓存中的一条消息，Seq按写入顺序递增
+type Entry struct {
+	Seq     uint64
+	Time    time.Time
+	Message model.BufferMessage
+}
+
+// MessageStore 设备断链期间的消息缓存，消息按写入顺序取出。
+// 发布流程为Peek -> 发布 -> Ack，发布成功后才删除消息，保证消息至少发送一次
+type MessageStore interface {
+	// Push 缓存一条消息，超过容量时按淘汰策略删除最早的消息
+	Push(message model.BufferMessage) error
+
+	// Peek 返回最早的一条消息，不会删除该消息
+	Peek() (Entry, bool, error)
+
+	// Ack 删除序号小于等于seq的所有消息
+	Ack(seq uint64) error
+
+	// Len 当前缓存的消息数量
+	Len() int
+
+	Close() error
+}
+
+// EvictionPolicy 缓存淘汰策略，超过任一限制时删除最早的消息，值为0表示不限制
+type EvictionPolicy struct {
+	MaxMessages int           // 最多缓存的消息条数
+	MaxBytes    int64         // 最多缓存的消息字节数
+	MaxAge      time.Duration // 消息最长缓存时间，超过后丢弃
+}
+
+func (policy EvictionPolicy) overflow(count int, bytes int64) bool {
+	if policy.MaxMessages > 0 && count > policy.MaxMessages {
+		return true
+	}
+	if policy.MaxBytes > 0 && bytes > policy.MaxBytes {
+		return true
+	}
+	return false
+}
+
+func (policy EvictionPolicy) expired(entry Entry, now time.Time) bool {
+	return policy.MaxAge > 0 && now.Sub(entry.Time) > policy.MaxAge
+}
+
+func messageSize(message model.BufferMessage) int64 {
+	return int64(len(message.Topic) + len(message.Message) + 1)
+}
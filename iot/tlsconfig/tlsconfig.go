@@ -0,0 +1,215 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package tlsconfig MQTT和HTTP客户端共用的TLS校验和代理配置
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Settings TLS校验和代理配置。零值时MQTT与旧版本一致只校验证书链，
+// 文件上传下载、OTA下载按系统根证书校验证书链和主机名
+type Settings struct {
+	CaFiles          []string // 额外信任的CA证书文件(PEM)，MQTT与ServerCaPath一起使用，HTTP与系统根证书一起使用
+	VerifyHostname   bool     // MQTT建链时校验平台证书中的主机名，默认只校验证书链。HTTP始终校验主机名
+	PinnedPublicKeys []string // 服务端证书公钥(SPKI)的SHA-256，base64或hex编码，可带"sha256/"前缀，证书链中任一证书匹配即通过
+	Proxy            string   // 代理地址，支持http://、socks5://，为空时HTTP使用HTTP_PROXY等环境变量
+	// InsecureSkipHttpVerify 不校验文件上传下载、OTA下载服务端的证书链和主机名，仅用于测试环境，
+	// 设置了PinnedPublicKeys时仍然校验公钥
+	InsecureSkipHttpVerify bool
+}
+
+// MqttConfig 生成与平台建链使用的tls.Config，serverCaPath为平台CA证书路径
+func (s Settings) MqttConfig(serverCaPath string, certificates []tls.Certificate, cipherSuites []uint16) (*tls.Config, error) {
+	var caFiles []string
+	if len(serverCaPath) != 0 {
+		caFiles = append(caFiles, serverCaPath)
+	}
+	caFiles = append(caFiles, s.CaFiles...)
+	if len(caFiles) == 0 {
+		return nil, errors.New("server ca is not set")
+	}
+	roots, err := loadCertPool(x509.NewCertPool(), caFiles)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.build(roots, true, s.VerifyHostname)
+	if err != nil {
+		return nil, err
+	}
+	config.Certificates = certificates
+	config.CipherSuites = cipherSuites
+	return config, nil
+}
+
+// HttpConfig 生成文件上传下载、OTA下载使用的tls.Config，按系统根证书和CaFiles校验证书链和主机名
+func (s Settings) HttpConfig() (*tls.Config, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	if roots, err = loadCertPool(roots, s.CaFiles); err != nil {
+		return nil, err
+	}
+	verify := !s.InsecureSkipHttpVerify
+	return s.build(roots, verify, verify)
+}
+
+// HttpTransport 生成使用HttpConfig和代理配置的http.Transport
+func (s Settings) HttpTransport() (*http.Transport, error) {
+	config, err := s.HttpConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy := http.ProxyFromEnvironment
+	if len(s.Proxy) != 0 {
+		proxyUrl, err := url.Parse(s.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %w", s.Proxy, err)
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+	return &http.Transport{
+		Proxy:               proxy,
+		TLSClientConfig:     config,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+	}, nil
+}
+
+// HttpClient 生成使用HttpTransport的http.Client
+func (s Settings) HttpClient() (*http.Client, error) {
+	transport, err := s.HttpTransport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// build 同时校验证书链和主机名时使用标准库的校验。标准库的主机名校验无法单独关闭，
+// 只校验证书链或不校验时跳过标准校验，在VerifyConnection中校验证书链和公钥
+func (s Settings) build(roots *x509.CertPool, verifyChain, verifyHostname bool) (*tls.Config, error) {
+	pins, err := parsePins(s.PinnedPublicKeys)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		RootCAs:            roots,
+		InsecureSkipVerify: !verifyChain || !verifyHostname,
+		MinVersion:         tls.VersionTLS12,
+		MaxVersion:         tls.VersionTLS13,
+	}
+	// 使用标准校验时证书链已经校验过，VerifyConnection中只校验公钥
+	verifyChain = verifyChain && !verifyHostname
+	if !verifyChain && len(pins) == 0 {
+		return config, nil
+	}
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("server certificate is empty")
+		}
+		if verifyChain {
+			if err := verifyCertificates(state, roots); err != nil {
+				return err
+			}
+		}
+		return verifyPins(state.PeerCertificates, pins)
+	}
+	return config, nil
+}
+
+func verifyCertificates(state tls.ConnectionState, roots *x509.CertPool) error {
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := state.PeerCertificates[0].Verify(opts); err != nil {
+		return fmt.Errorf("verify server certificate failed: %w", err)
+	}
+	return nil
+}
+
+func verifyPins(certificates []*x509.Certificate, pins [][]byte) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, cert := range certificates {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(sum[:], pin) {
+				return nil
+			}
+		}
+	}
+	return errors.New("server certificate does not match pinned public keys")
+}
+
+func parsePins(values []string) ([][]byte, error) {
+	var pins [][]byte
+	for _, value := range values {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "sha256/")
+		pin, err := hex.DecodeString(value)
+		if err != nil || len(pin) != sha256.Size {
+			pin, err = base64.StdEncoding.DecodeString(value)
+		}
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid pinned public key %q", value)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+func loadCertPool(pool *x509.CertPool, files []string) (*x509.CertPool, error) {
+	for _, file := range files {
+		ca, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", file)
+		}
+	}
+	return pool, nil
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package tlsconfig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHttpClientVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, certificate, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	pin := hex.EncodeToString(sum[:])
+	// 测试证书只包含127.0.0.1和example.com，通过localhost访问时主机名不匹配
+	localhost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name     string
+		settings Settings
+		url      string
+		ok       bool
+	}{
+		{name: "untrusted by default", url: server.URL},
+		{name: "trusted ca", settings: Settings{CaFiles: []string{caFile}}, url: server.URL, ok: true},
+		{name: "hostname mismatch", settings: Settings{CaFiles: []string{caFile}}, url: localhost},
+		{name: "trusted ca with pin", settings: Settings{CaFiles: []string{caFile}, PinnedPublicKeys: []string{pin}}, url: server.URL, ok: true},
+		{name: "trusted ca with wrong pin", settings: Settings{CaFiles: []string{caFile}, PinnedPublicKeys: []string{strings.Repeat("0", 64)}}, url: server.URL},
+		{name: "skip verify", settings: Settings{InsecureSkipHttpVerify: true}, url: localhost, ok: true},
+		{name: "skip verify keeps pin", settings: Settings{InsecureSkipHttpVerify: true, PinnedPublicKeys: []string{strings.Repeat("0", 64)}}, url: server.URL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := tt.settings.HttpClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := client.Get(tt.url)
+			if err == nil {
+				_ = response.Body.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Fatalf("Get() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package fake 提供进程内的模拟平台，设备通过Platform.Factory创建的Transport与其交互，
+// 不需要连接真实的IoTDA即可验证命令、属性、事件等流程
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/transport"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrDeviceNotConnected = errors.New("device is not connected to fake platform")
+
+// PublishedMessage 设备发布到平台的消息
+type PublishedMessage struct {
+	DeviceId string
+	Message  transport.Message
+}
+
+// Platform 进程内的模拟平台，记录设备发布的消息并可以向设备下发消息
+type Platform struct {
+	lock        sync.Mutex
+	connections map[string]*Transport
+	published   []PublishedMessage
+	consumed    []bool // 与published对应，已被WaitPublished返回的消息
+	notify      chan struct{}
+	requestId   int64
+	connectHook func(options transport.Options) error
+	publishHook func(deviceId string, message transport.Message) error
+}
+
+func NewPlatform() *Platform {
+	return &Platform{
+		connections: make(map[string]*Transport),
+		notify:      make(chan struct{}),
+	}
+}
+
+// Factory 返回连接到该平台的Transport工厂，设置到ConnectAuthConfig.TransportFactory即可
+func (p *Platform) Factory() transport.Factory {
+	return func(options transport.Options) transport.Transport {
+		return &Transport{
+			platform:      p,
+			options:       options,
+			subscriptions: make(map[string]transport.MessageHandler),
+		}
+	}
+}
+
+// SetConnectHook 设置建链回调，返回错误时建链失败，可用于模拟鉴权失败
+func (p *Platform) SetConnectHook(hook func(options transport.Options) error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.connectHook = hook
+}
+
+// SetPublishHook 设置设备发布消息时的回调，返回错误时发布失败，也可以在回调中向设备回复消息
+func (p *Platform) SetPublishHook(hook func(deviceId string, message transport.Message) error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.publishHook = hook
+}
+
+// IsConnected 设备是否已连接到平台
+func (p *Platform) IsConnected(deviceId string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, ok := p.connections[deviceId]
+	return ok
+}
+
+// Published 返回设备已发布的全部消息
+func (p *Platform) Published() []PublishedMessage {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	published := make([]PublishedMessage, len(p.published))
+	copy(published, p.published)
+	return published
+}
+
+// WaitPublished 按发布顺序返回第一条与topic过滤器匹配、且没有被WaitPublished返回过的消息，没有时等待设备发布。
+// 返回的消息会被标记为已消费，多次调用可以依次等待同一topic上的多条消息
+func (p *Platform) WaitPublished(ctx context.Context, topicFilter string) (PublishedMessage, error) {
+	for {
+		p.lock.Lock()
+		for i, published := range p.published {
+			if !p.consumed[i] && transport.MatchTopic(topicFilter, published.Message.Topic) {
+				p.consumed[i] = true
+				p.lock.Unlock()
+				return published, nil
+			}
+		}
+		notify := p.notify
+		p.lock.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return PublishedMessage{}, ctx.Err()
+		}
+	}
+}
+
+// Reset 清空已记录的消息
+func (p *Platform) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.published = nil
+	p.consumed = nil
+}
+
+// Deliver 向设备下发一条消息，消息会交给设备匹配的订阅或者默认处理函数
+func (p *Platform) Deliver(deviceId string, message transport.Message) error {
+	p.lock.Lock()
+	conn, ok := p.connections[deviceId]
+	p.lock.Unlock()
+	if !ok {
+		return ErrDeviceNotConnected
+	}
+	conn.deliver(message)
+	return nil
+}
+
+// SendMessage 向设备下发消息
+func (p *Platform) SendMessage(deviceId, payload string) error {
+	return p.Deliver(deviceId, transport.Message{
+		Topic:   "$oc/devices/" + deviceId + "/sys/messages/down",
+		Payload: []byte(payload),
+	})
+}
+
+// SendCommand 向设备下发命令，返回请求ID，设备的响应发布在$oc/devices/{device_id}/sys/commands/response/request_id={request_id}
+func (p *Platform) SendCommand(deviceId string, command model.Command) (string, error) {
+	return p.sendRequest(deviceId, "commands", command)
+}
+
+// SetProperties 设置设备属性，返回请求ID
+func (p *Platform) SetProperties(deviceId string, request model.DevicePropertyDownRequest) (string, error) {
+	return p.sendRequest(deviceId, "properties/set", request)
+}
+
+// QueryProperties 查询设备属性，返回请求ID
+func (p *Platform) QueryProperties(deviceId string, request model.DevicePropertyQueryRequest) (string, error) {
+	return p.sendRequest(deviceId, "properties/get", request)
+}
+
+// SendEvent 向设备下发事件，如OTA升级、子设备变更、文件上传地址等
+func (p *Platform) SendEvent(deviceId string, data model.Data) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return p.Deliver(deviceId, transport.Message{
+		Topic:   "$oc/devices/" + deviceId + "/sys/events/down",
+		Payload: payload,
+	})
+}
+
+// DropConnection 断开设备连接并触发设备的断链回调，用于模拟网络异常
+func (p *Platform) DropConnection(deviceId string, reason error) {
+	p.lock.Lock()
+	conn, ok := p.connections[deviceId]
+	if ok {
+		delete(p.connections, deviceId)
+	}
+	p.lock.Unlock()
+	if ok {
+		conn.lost(reason)
+	}
+}
+
+func (p *Platform) sendRequest(deviceId, requestType string, request interface{}) (string, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	p.lock.Lock()
+	p.requestId++
+	requestId := strconv.FormatInt(p.requestId, 10)
+	p.lock.Unlock()
+	return requestId, p.Deliver(deviceId, transport.Message{
+		Topic:   "$oc/devices/" + deviceId + "/sys/" + requestType + "/request_id=" + requestId,
+		Payload: payload,
+	})
+}
+
+func (p *Platform) connect(conn *Transport) error {
+	p.lock.Lock()
+	hook := p.connectHook
+	p.lock.Unlock()
+	if hook != nil {
+		if err := hook(conn.options); err != nil {
+			return err
+		}
+	}
+	p.lock.Lock()
+	old, ok := p.connections[conn.options.Username]
+	p.connections[conn.options.Username] = conn
+	p.lock.Unlock()
+	// 与MQTT一致，相同的设备重复建链时旧连接会被踢下线
+	if ok && old != conn {
+		old.lost(errors.New("session taken over"))
+	}
+	return nil
+}
+
+func (p *Platform) disconnect(conn *Transport) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.connections[conn.options.Username] == conn {
+		delete(p.connections, conn.options.Username)
+	}
+}
+
+func (p *Platform) publish(conn *Transport, message transport.Message) error {
+	p.lock.Lock()
+	hook := p.publishHook
+	p.lock.Unlock()
+	if hook != nil {
+		if err := hook(conn.options.Username, message); err != nil {
+			return err
+		}
+	}
+	p.lock.Lock()
+	p.published = append(p.published, PublishedMessage{
+		DeviceId: conn.options.Username,
+		Message:  message,
+	})
+	p.consumed = append(p.consumed, false)
+	close(p.notify)
+	p.notify = make(chan struct{})
+	p.lock.Unlock()
+	return nil
+}
+
+// Transport 连接到模拟平台的Transport
+type Transport struct {
+	platform      *Platform
+	options       transport.Options
+	lock          sync.RWMutex
+	connected     bool
+	subscriptions map[string]transport.MessageHandler
+}
+
+func (t *Transport) Connect(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := t.platform.connect(t); err != nil {
+		return err
+	}
+	t.lock.Lock()
+	t.connected = true
+	t.lock.Unlock()
+	if t.options.OnConnect != nil {
+		go t.options.OnConnect()
+	}
+	return nil
+}
+
+func (t *Transport) IsConnected() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.connected
+}
+
+func (t *Transport) Publish(ctx context.Context, message transport.Message) error {
+	if !t.IsConnected() {
+		return ErrDeviceNotConnected
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return t.platform.publish(t, message)
+}
+
+func (t *Transport) Subscribe(ctx context.Context, topic string, qos byte, handler transport.MessageHandler) error {
+	if !t.IsConnected() {
+		return ErrDeviceNotConnected
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.subscriptions[topic] = handler
+	return nil
+}
+
+func (t *Transport) Unsubscribe(ctx context.Context, topic string) error {
+	if !t.IsConnected() {
+		return ErrDeviceNotConnected
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.subscriptions, topic)
+	return nil
+}
+
+func (t *Transport) Disconnect(quiesce time.Duration) {
+	t.lock.Lock()
+	t.connected = false
+	t.lock.Unlock()
+	t.platform.disconnect(t)
+}
+
+// Subscriptions 返回设备当前订阅的topic
+func (t *Transport) Subscriptions() []string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	topics := make([]string, 0, len(t.subscriptions))
+	for topic := range t.subscriptions {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+func (t *Transport) deliver(message transport.Message) {
+	var handlers []transport.MessageHandler
+	t.lock.RLock()
+	for topic, handler := range t.subscriptions {
+		if transport.MatchTopic(topic, message.Topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	t.lock.RUnlock()
+	if len(handlers) == 0 && t.options.DefaultHandler != nil {
+		t.options.DefaultHandler(message)
+		return
+	}
+	for _, handler := range handlers {
+		handler(message)
+	}
+}
+
+func (t *Transport) lost(reason error) {
+	t.lock.Lock()
+	if !t.connected {
+		t.lock.Unlock()
+		return
+	}
+	t.connected = false
+	t.lock.Unlock()
+	if t.options.OnConnectionLost != nil {
+		go t.options.OnConnectionLost(reason)
+	}
+}
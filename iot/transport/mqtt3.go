@@ -0,0 +1,185 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package transport
+
+import (
+	"context"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Mqtt3Transport 基于paho.mqtt.golang的MQTT3.1.1实现
+type Mqtt3Transport struct {
+	options Options
+	client  mqtt.Client
+}
+
+func NewMqtt3Transport(options Options) Transport {
+	clientOptions := mqtt.NewClientOptions()
+	clientOptions.AddBroker(options.Broker)
+	clientOptions.SetClientID(options.ClientId)
+	clientOptions.SetUsername(options.Username)
+	clientOptions.SetPassword(options.Password)
+	clientOptions.SetConnectTimeout(options.ConnectTimeout)
+	clientOptions.SetKeepAlive(options.KeepAlive)
+	// 关闭sdk内部重连，使用自定义重连刷新时间戳
+	clientOptions.SetAutoReconnect(false)
+	clientOptions.SetConnectRetry(false)
+	clientOptions.SetMaxResumePubInFlight(options.InflightMessages)
+	if options.TLSConfig != nil {
+		clientOptions.SetTLSConfig(options.TLSConfig)
+	}
+	if options.DialContext != nil {
+		clientOptions.SetCustomOpenConnectionFn(func(uri *url.URL, clientOptions mqtt.ClientOptions) (net.Conn, error) {
+			ctx := context.Background()
+			if options.ConnectTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, options.ConnectTimeout)
+				defer cancel()
+			}
+			return Dial(ctx, uri.String(), options.TLSConfig, options.DialContext)
+		})
+	}
+	if options.OnConnect != nil {
+		clientOptions.SetOnConnectHandler(func(client mqtt.Client) {
+			options.OnConnect()
+		})
+	}
+	if options.OnConnectionLost != nil {
+		clientOptions.SetConnectionLostHandler(func(client mqtt.Client, reason error) {
+			options.OnConnectionLost(reason)
+		})
+	}
+	if options.DefaultHandler != nil {
+		clientOptions.SetDefaultPublishHandler(func(client mqtt.Client, message mqtt.Message) {
+			options.DefaultHandler(fromMqtt3Message(message))
+		})
+	}
+	return &Mqtt3Transport{
+		options: options,
+		client:  mqtt.NewClient(clientOptions),
+	}
+}
+
+// PahoClient 返回底层的paho客户端，用于兼容以mqtt.Client为参数的回调
+func (t *Mqtt3Transport) PahoClient() mqtt.Client {
+	return t.client
+}
+
+func (t *Mqtt3Transport) Connect(ctx context.Context) error {
+	token := t.client.Connect()
+	if err := waitToken(ctx, token, t.options.ConnectTimeout); err != nil {
+		// ctx取消或超时时paho仍在建链，断开连接避免之后建链成功产生无人管理的连接
+		t.client.Disconnect(0)
+		if code, ok := refusedCode(token); ok {
+			return &ReasonCodeError{Packet: "CONNACK", ReasonCode: code, Reason: err.Error()}
+		}
+		return err
+	}
+	return nil
+}
+
+func (t *Mqtt3Transport) IsConnected() bool {
+	return t.client.IsConnected()
+}
+
+func (t *Mqtt3Transport) Publish(ctx context.Context, message Message) error {
+	token := t.client.Publish(message.Topic, message.Qos, message.Retained, message.Payload)
+	return waitToken(ctx, token, 0)
+}
+
+func (t *Mqtt3Transport) Subscribe(ctx context.Context, topic string, qos byte, handler MessageHandler) error {
+	token := t.client.Subscribe(topic, qos, func(client mqtt.Client, message mqtt.Message) {
+		handler(fromMqtt3Message(message))
+	})
+	if err := waitToken(ctx, token, 0); err != nil {
+		return err
+	}
+	// 订阅失败时paho不会返回错误，需要检查SUBACK中的返回码
+	if subscribeToken, ok := token.(*mqtt.SubscribeToken); ok {
+		if code, ok := subscribeToken.Result()[topic]; ok && code >= 0x80 {
+			return &ReasonCodeError{Packet: "SUBACK", ReasonCode: code}
+		}
+	}
+	return nil
+}
+
+func (t *Mqtt3Transport) Unsubscribe(ctx context.Context, topic string) error {
+	return waitToken(ctx, t.client.Unsubscribe(topic), 0)
+}
+
+func (t *Mqtt3Transport) Disconnect(quiesce time.Duration) {
+	t.client.Disconnect(uint(quiesce / time.Millisecond))
+}
+
+func fromMqtt3Message(message mqtt.Message) Message {
+	return Message{
+		Topic:    message.Topic(),
+		Qos:      message.Qos(),
+		Retained: message.Retained(),
+		Payload:  message.Payload(),
+	}
+}
+
+// waitToken 等待token完成，timeout大于0时同时受timeout约束，超时返回context.DeadlineExceeded
+// refusedCode 返回broker在CONNACK中拒绝建链的返回码。建链未完成时返回码还在被paho写入，
+// 建链、TLS握手和网络失败时paho使用的ErrNetworkError等不是broker返回的，均不返回
+func refusedCode(token mqtt.Token) (byte, bool) {
+	select {
+	case <-token.Done():
+	default:
+		return 0, false
+	}
+	connectToken, ok := token.(*mqtt.ConnectToken)
+	if !ok {
+		return 0, false
+	}
+	code := connectToken.ReturnCode()
+	if code < packets.ErrRefusedBadProtocolVersion || code > packets.ErrRefusedNotAuthorised {
+		return 0, false
+	}
+	return code, true
+}
+
+func waitToken(ctx context.Context, token mqtt.Token, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
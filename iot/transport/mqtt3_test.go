@@ -0,0 +1,134 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"net"
+	"testing"
+	"time"
+)
+
+// startBroker 启动按respond处理建链的broker，respond为nil时读取CONNECT后直接断开连接。
+// 未指定协议版本时paho会使用MQTT3.1再次建链，每个连接都需要处理
+func startBroker(t *testing.T, respond func(conn net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := packets.ReadPacket(conn); err != nil {
+					return
+				}
+				if respond != nil {
+					respond(conn)
+				}
+			}()
+		}
+	}()
+	return "tcp://" + listener.Addr().String()
+}
+
+func connack(code byte) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		packet := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+		packet.ReturnCode = code
+		_ = packet.Write(conn)
+		// 等待客户端断开
+		_, _ = packets.ReadPacket(conn)
+	}
+}
+
+func TestMqtt3ConnectError(t *testing.T) {
+	tests := []struct {
+		name     string
+		respond  func(conn net.Conn)
+		timeout  time.Duration
+		refused  bool
+		code     byte
+		deadline bool
+	}{
+		{name: "accepted", respond: connack(packets.Accepted)},
+		{name: "not authorised", respond: connack(packets.ErrRefusedNotAuthorised), refused: true, code: packets.ErrRefusedNotAuthorised},
+		{name: "bad protocol version", respond: connack(packets.ErrRefusedBadProtocolVersion), refused: true, code: packets.ErrRefusedBadProtocolVersion},
+		{name: "connection closed", respond: nil},
+		{name: "no connack", respond: func(conn net.Conn) {
+			_, _ = packets.ReadPacket(conn)
+		}, timeout: 200 * time.Millisecond, deadline: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := NewMqtt3Transport(Options{
+				Broker:         startBroker(t, tt.respond),
+				ClientId:       "test",
+				ConnectTimeout: 5 * time.Second,
+			})
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			err := conn.Connect(ctx)
+			defer conn.Disconnect(0)
+			if tt.name == "accepted" {
+				if err != nil {
+					t.Fatalf("Connect() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Connect() = nil, want error")
+			}
+			var reasonCodeErr *ReasonCodeError
+			if refused := errors.As(err, &reasonCodeErr); refused != tt.refused {
+				t.Fatalf("Connect() = %v, refused = %v, want %v", err, refused, tt.refused)
+			}
+			if tt.refused && reasonCodeErr.ReasonCode != tt.code {
+				t.Errorf("reason code = %d, want %d", reasonCodeErr.ReasonCode, tt.code)
+			}
+			if tt.deadline && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Connect() = %v, want %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errNotConnected = errors.New("mqtt5 client is not connected")
+
+// Mqtt5Transport 基于paho.golang的MQTT5实现
+type Mqtt5Transport struct {
+	options   Options
+	router    *router
+	client    *paho.Client
+	connected int32
+	lostOnce  sync.Once
+}
+
+func NewMqtt5Transport(options Options) Transport {
+	return &Mqtt5Transport{
+		options: options,
+		router:  newRouter(options.DefaultHandler),
+	}
+}
+
+func (t *Mqtt5Transport) Connect(ctx context.Context) error {
+	if t.options.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.options.ConnectTimeout)
+		defer cancel()
+	}
+	conn, err := Dial(ctx, t.options.Broker, t.options.TLSConfig, t.options.DialContext)
+	if err != nil {
+		return err
+	}
+	client := paho.NewClient(paho.ClientConfig{
+		ClientID: t.options.ClientId,
+		Conn:     packets.NewThreadSafeConn(conn),
+		Router:   t.router,
+		OnClientError: func(err error) {
+			t.connectionLost(err)
+		},
+		OnServerDisconnect: func(disconnect *paho.Disconnect) {
+			t.connectionLost(disconnectError(disconnect))
+		},
+	})
+	sessionExpiry := uint32(t.options.SessionExpiry / time.Second)
+	connect := &paho.Connect{
+		ClientID:     t.options.ClientId,
+		Username:     t.options.Username,
+		UsernameFlag: len(t.options.Username) != 0,
+		Password:     []byte(t.options.Password),
+		PasswordFlag: len(t.options.Password) != 0,
+		KeepAlive:    uint16(t.options.KeepAlive / time.Second),
+		CleanStart:   sessionExpiry == 0,
+		Properties: &paho.ConnectProperties{
+			SessionExpiryInterval: &sessionExpiry,
+		},
+	}
+	connack, err := client.Connect(ctx, connect)
+	if err != nil {
+		_ = conn.Close()
+		if connack != nil && connack.ReasonCode >= 0x80 {
+			reason := err.Error()
+			if connack.Properties != nil && len(connack.Properties.ReasonString) != 0 {
+				reason = connack.Properties.ReasonString
+			}
+			return &ReasonCodeError{Packet: "CONNACK", ReasonCode: connack.ReasonCode, Reason: reason}
+		}
+		return err
+	}
+	t.client = client
+	atomic.StoreInt32(&t.connected, 1)
+	if t.options.OnConnect != nil {
+		go t.options.OnConnect()
+	}
+	return nil
+}
+
+func (t *Mqtt5Transport) IsConnected() bool {
+	return atomic.LoadInt32(&t.connected) == 1
+}
+
+func (t *Mqtt5Transport) Publish(ctx context.Context, message Message) error {
+	if !t.IsConnected() {
+		return errNotConnected
+	}
+	response, err := t.client.Publish(ctx, &paho.Publish{
+		Topic:      message.Topic,
+		QoS:        message.Qos,
+		Retain:     message.Retained,
+		Payload:    message.Payload,
+		Properties: toPublishProperties(message.Properties),
+	})
+	if response != nil && response.ReasonCode >= 0x80 {
+		reason := ""
+		if response.Properties != nil {
+			reason = response.Properties.ReasonString
+		}
+		return &ReasonCodeError{Packet: "PUBACK", ReasonCode: response.ReasonCode, Reason: reason}
+	}
+	return err
+}
+
+func (t *Mqtt5Transport) Subscribe(ctx context.Context, topic string, qos byte, handler MessageHandler) error {
+	if !t.IsConnected() {
+		return errNotConnected
+	}
+	t.router.register(topic, handler)
+	suback, err := t.client.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{
+			topic: {QoS: qos},
+		},
+	})
+	if err == nil {
+		return nil
+	}
+	t.router.unregister(topic)
+	if suback != nil && len(suback.Reasons) == 1 && suback.Reasons[0] >= 0x80 {
+		return &ReasonCodeError{Packet: "SUBACK", ReasonCode: suback.Reasons[0], Reason: err.Error()}
+	}
+	return err
+}
+
+func (t *Mqtt5Transport) Unsubscribe(ctx context.Context, topic string) error {
+	if !t.IsConnected() {
+		return errNotConnected
+	}
+	t.router.unregister(topic)
+	_, err := t.client.Unsubscribe(ctx, &paho.Unsubscribe{
+		Topics: []string{topic},
+	})
+	return err
+}
+
+func (t *Mqtt5Transport) Disconnect(quiesce time.Duration) {
+	// 主动断链不触发断链回调
+	if !atomic.CompareAndSwapInt32(&t.connected, 1, 0) {
+		return
+	}
+	t.lostOnce.Do(func() {})
+	_ = t.client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+}
+
+func (t *Mqtt5Transport) connectionLost(reason error) {
+	atomic.StoreInt32(&t.connected, 0)
+	t.lostOnce.Do(func() {
+		if t.options.OnConnectionLost != nil {
+			t.options.OnConnectionLost(reason)
+		}
+	})
+}
+
+func disconnectError(disconnect *paho.Disconnect) error {
+	reason := ""
+	if disconnect.Properties != nil {
+		reason = disconnect.Properties.ReasonString
+	}
+	return &ReasonCodeError{Packet: "DISCONNECT", ReasonCode: disconnect.ReasonCode, Reason: reason}
+}
+
+// Dial 建立到broker的网络连接，支持tcp、mqtt、ssl、tls、mqtts协议，dialContext不为空时使用dialContext建立TCP连接
+func Dial(ctx context.Context, broker string, tlsConfig *tls.Config, dialContext func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	brokerUrl, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+	if dialContext == nil {
+		dialer := &net.Dialer{}
+		dialContext = dialer.DialContext
+	}
+	switch brokerUrl.Scheme {
+	case "tcp", "mqtt":
+		return dialContext(ctx, "tcp", hostWithPort(brokerUrl, "1883"))
+	case "ssl", "tls", "mqtts", "tcps":
+		conn, err := dialContext(ctx, "tcp", hostWithPort(brokerUrl, "8883"))
+		if err != nil {
+			return nil, err
+		}
+		config := &tls.Config{}
+		if tlsConfig != nil {
+			config = tlsConfig.Clone()
+		}
+		if len(config.ServerName) == 0 {
+			config.ServerName = brokerUrl.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme %s", brokerUrl.Scheme)
+	}
+}
+
+func hostWithPort(brokerUrl *url.URL, defaultPort string) string {
+	if len(brokerUrl.Port()) != 0 {
+		return brokerUrl.Host
+	}
+	return net.JoinHostPort(brokerUrl.Hostname(), defaultPort)
+}
+
+func toPublishProperties(properties *Properties) *paho.PublishProperties {
+	if properties == nil {
+		return nil
+	}
+	publishProperties := &paho.PublishProperties{
+		ResponseTopic:   properties.ResponseTopic,
+		CorrelationData: properties.CorrelationData,
+		ContentType:     properties.ContentType,
+	}
+	if properties.MessageExpiry > 0 {
+		expiry := uint32(properties.MessageExpiry / time.Second)
+		publishProperties.MessageExpiry = &expiry
+	}
+	for key, value := range properties.UserProperties {
+		publishProperties.User.Add(key, value)
+	}
+	return publishProperties
+}
+
+func fromPublishProperties(properties *paho.PublishProperties) *Properties {
+	if properties == nil {
+		return nil
+	}
+	result := &Properties{
+		ResponseTopic:   properties.ResponseTopic,
+		CorrelationData: properties.CorrelationData,
+		ContentType:     properties.ContentType,
+	}
+	if properties.MessageExpiry != nil {
+		result.MessageExpiry = time.Duration(*properties.MessageExpiry) * time.Second
+	}
+	if len(properties.User) != 0 {
+		result.UserProperties = make(map[string]string, len(properties.User))
+		for _, property := range properties.User {
+			result.UserProperties[property.Key] = property.Value
+		}
+	}
+	return result
+}
+
+// router 按订阅topic分发消息，没有匹配的订阅时交给默认处理函数
+type router struct {
+	lock           sync.RWMutex
+	handlers       map[string]MessageHandler
+	defaultHandler MessageHandler
+}
+
+func newRouter(defaultHandler MessageHandler) *router {
+	return &router{
+		handlers:       make(map[string]MessageHandler),
+		defaultHandler: defaultHandler,
+	}
+}
+
+func (r *router) register(topic string, handler MessageHandler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.handlers[topic] = handler
+}
+
+func (r *router) unregister(topic string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.handlers, topic)
+}
+
+func (r *router) RegisterHandler(topic string, handler paho.MessageHandler) {
+	r.register(topic, func(message Message) {
+		handler(&paho.Publish{
+			QoS:     message.Qos,
+			Retain:  message.Retained,
+			Topic:   message.Topic,
+			Payload: message.Payload,
+		})
+	})
+}
+
+func (r *router) UnregisterHandler(topic string) {
+	r.unregister(topic)
+}
+
+func (r *router) Route(publish *packets.Publish) {
+	p := paho.PublishFromPacketPublish(publish)
+	message := Message{
+		Topic:      p.Topic,
+		Qos:        p.QoS,
+		Retained:   p.Retain,
+		Payload:    p.Payload,
+		Properties: fromPublishProperties(p.Properties),
+	}
+	var matched []MessageHandler
+	r.lock.RLock()
+	for topic, handler := range r.handlers {
+		if MatchTopic(topic, message.Topic) {
+			matched = append(matched, handler)
+		}
+	}
+	r.lock.RUnlock()
+	if len(matched) == 0 && r.defaultHandler != nil {
+		r.defaultHandler(message)
+		return
+	}
+	for _, handler := range matched {
+		handler(message)
+	}
+}
+
+func (r *router) SetDebugLogger(logger paho.Logger) {
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package transport
+
+import "testing"
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/b/c", "a/b/d", false},
+		{"a/b", "a/b/c", false},
+		{"a/b/c", "a/b", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/+", "a/b/c", false},
+		{"+/+", "a/b", true},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"#", "a/b/c", true},
+		{"a/b/#", "a/c", false},
+		{"+", "", true},
+		{"a//c", "a//c", true},
+		{"a/+/c", "a//c", true},
+		{"#", "$oc/devices/1", false},
+		{"+/devices/1", "$oc/devices/1", false},
+		{"$oc/devices/+/sys/commands/#", "$oc/devices/d1/sys/commands/request_id=1", true},
+		{"$oc/devices/+/sys/commands/#", "$oc/devices/d1/sys/messages/down", false},
+	}
+	for _, test := range tests {
+		if match := MatchTopic(test.filter, test.topic); match != test.match {
+			t.Errorf("MatchTopic(%q, %q) = %v, want %v", test.filter, test.topic, match, test.match)
+		}
+	}
+}
+
+func TestValidTopicFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		valid  bool
+	}{
+		{"a/b/c", true},
+		{"a/+/c", true},
+		{"a/#", true},
+		{"#", true},
+		{"+", true},
+		{"", false},
+		{"a/#/c", false},
+		{"a/b#", false},
+		{"a/b+/c", false},
+	}
+	for _, test := range tests {
+		if valid := ValidTopicFilter(test.filter); valid != test.valid {
+			t.Errorf("ValidTopicFilter(%q) = %v, want %v", test.filter, valid, test.valid)
+		}
+	}
+}
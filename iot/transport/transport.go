@@ -0,0 +1,120 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package transport 屏蔽不同MQTT协议版本客户端的差异，设备客户端只依赖Transport接口
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	ProtocolMqtt311 = "3.1.1"
+	ProtocolMqtt5   = "5"
+)
+
+// Properties MQTT5消息属性，MQTT3.1.1下发布时会被忽略，接收到的消息该字段为nil
+type Properties struct {
+	ResponseTopic   string
+	CorrelationData []byte
+	ContentType     string
+	MessageExpiry   time.Duration // 消息过期时间，0表示不过期
+	UserProperties  map[string]string
+}
+
+// Message 收发的MQTT消息
+type Message struct {
+	Topic      string
+	Qos        byte
+	Retained   bool
+	Payload    []byte
+	Properties *Properties
+}
+
+// MessageHandler 消息处理函数
+type MessageHandler func(message Message)
+
+// Options 建链参数，每次建链都会使用新的Options创建Transport，以便刷新密码中的时间戳
+type Options struct {
+	Broker           string // tcp://host:port、ssl://host:port、mqtts://host:port等
+	ClientId         string
+	Username         string
+	Password         string
+	TLSConfig        *tls.Config
+	DialContext      func(ctx context.Context, network, addr string) (net.Conn, error) // 自定义建立网络连接，如通过代理连接平台
+	KeepAlive        time.Duration
+	ConnectTimeout   time.Duration
+	InflightMessages int
+	SessionExpiry    time.Duration // MQTT5会话过期时间，0表示断链即清理会话
+	OnConnect        func()
+	OnConnectionLost func(reason error)
+	DefaultHandler   MessageHandler // 没有匹配订阅的消息，如平台默认下发的系统topic
+}
+
+// Transport 一次MQTT连接
+type Transport interface {
+	Connect(ctx context.Context) error
+	IsConnected() bool
+	Publish(ctx context.Context, message Message) error
+	Subscribe(ctx context.Context, topic string, qos byte, handler MessageHandler) error
+	Unsubscribe(ctx context.Context, topic string) error
+	Disconnect(quiesce time.Duration)
+}
+
+// Factory 根据建链参数创建Transport
+type Factory func(options Options) Transport
+
+// NewFactory 根据协议版本返回对应的Factory，未指定时使用MQTT3.1.1
+func NewFactory(protocol string) (Factory, error) {
+	switch protocol {
+	case "", ProtocolMqtt311:
+		return NewMqtt3Transport, nil
+	case ProtocolMqtt5:
+		return NewMqtt5Transport, nil
+	default:
+		return nil, fmt.Errorf("unsupported mqtt protocol version %s", protocol)
+	}
+}
+
+// ReasonCodeError broker返回了失败的原因码，如CONNACK、PUBACK、SUBACK中的原因码
+type ReasonCodeError struct {
+	Packet     string
+	ReasonCode byte
+	Reason     string
+}
+
+func (e *ReasonCodeError) Error() string {
+	if len(e.Reason) == 0 {
+		return fmt.Sprintf("%s failed with reason code 0x%02x", e.Packet, e.ReasonCode)
+	}
+	return fmt.Sprintf("%s failed with reason code 0x%02x: %s", e.Packet, e.ReasonCode, e.Reason)
+}
@@ -38,9 +38,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/golang/glog"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/constants"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
 	"io"
 	"io/fs"
@@ -80,24 +80,31 @@ func HmacSha256(data string, secret string) (string, error) {
 	h := hmac.New(sha256.New, []byte(secret))
 	_, err := h.Write([]byte(data))
 	if err != nil {
-		glog.Warningf("hmac password failed. err: %s", err)
+		logger.Default().Warn("hmac password failed", logger.Err(err))
 		return "", err
 	}
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
+// Sha256FromFile 流式计算文件的sha256，文件大小取自Stat，不会把整个文件读入内存
 func Sha256FromFile(filePath string) (int, string, error) {
-	file, err := ioutil.ReadFile(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
-		glog.Warningf("read file failed. path : %s", filePath)
+		logger.Default().Warn("read file failed", logger.String("path", filePath), logger.Err(err))
 		return 0, "", errors.New("read file failed")
 	}
-	hash := sha256.New()
-	_, err = hash.Write(file)
+	defer file.Close()
+	info, err := file.Stat()
 	if err != nil {
-		return 0, "", nil
+		logger.Default().Warn("stat file failed", logger.String("path", filePath), logger.Err(err))
+		return 0, "", errors.New("read file failed")
+	}
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		logger.Default().Warn("read file failed", logger.String("path", filePath), logger.Err(err))
+		return 0, "", errors.New("read file failed")
 	}
-	return len(file), hex.EncodeToString(hash.Sum(nil)), nil
+	return int(info.Size()), hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 func Interface2JsonString(v interface{}) string {
@@ -111,6 +118,15 @@ func Interface2JsonString(v interface{}) string {
 	return string(byteData)
 }
 
+// MarshalJsonString 与Interface2JsonString相同，但会返回序列化错误
+func MarshalJsonString(v interface{}) (string, error) {
+	byteData, err := json.Marshal(v)
+	if err != nil {
+		return "", NewDeviceError("marshal", "", ErrSerialization, err)
+	}
+	return string(byteData), nil
+}
+
 func GetTopicRequestId(topic string) string {
 	return strings.Split(topic, "=")[1]
 }
@@ -150,13 +166,13 @@ func OsName() string {
 func SdkInfo() map[string]string {
 	f, err := os.Open("sdk_info")
 	if err != nil {
-		glog.Warning("read sdk info failed")
+		logger.Default().Debug("read sdk info failed", logger.Err(err))
 		return map[string]string{}
 	}
 	defer func(f *os.File) {
 		err := f.Close()
 		if err != nil {
-			glog.Warningf("close stream failed. err: %s", err.Error())
+			logger.Default().Warn("close stream failed", logger.Err(err))
 		}
 	}(f)
 
@@ -166,7 +182,7 @@ func SdkInfo() map[string]string {
 	for {
 		b, _, err := buf.ReadLine()
 		if err != nil && err == io.EOF {
-			glog.Warningf("read sdk info failed or end")
+			logger.Default().Debug("read sdk info failed or end")
 			break
 		}
 		line := string(b)
@@ -182,13 +198,13 @@ func SdkInfo() map[string]string {
 func GetServer() *model.ServerInfo {
 	f, err := os.Open("server_info.txt")
 	if err != nil {
-		glog.Warning("read sdk info failed")
+		logger.Default().Debug("read sdk info failed", logger.Err(err))
 		return nil
 	}
 	defer func(f *os.File) {
 		err := f.Close()
 		if err != nil {
-			glog.Warningf("close stream failed. err: %s", err.Error())
+			logger.Default().Warn("close stream failed", logger.Err(err))
 		}
 	}(f)
 
@@ -197,14 +213,14 @@ func GetServer() *model.ServerInfo {
 	for {
 		b, _, err := buf.ReadLine()
 		if err != nil && err == io.EOF {
-			glog.Warningf("read sdk info failed or end")
+			logger.Default().Debug("read sdk info failed or end")
 			break
 		}
 		if len(b) != 0 {
 			var serverInfo model.ServerInfo
 			err := json.Unmarshal(b, &serverInfo)
 			if err != nil {
-				glog.Warningf("unmarshal server info failed. err: %s", err)
+				logger.Default().Warn("unmarshal server info failed", logger.Err(err))
 			}
 			return &serverInfo
 		}
@@ -215,18 +231,20 @@ func GetServer() *model.ServerInfo {
 func SavePwd(server model.ServerInfo) error {
 	marshal, err := json.Marshal(server)
 	if err != nil {
-		glog.Warningf("marshal server info failed. err: %s", err)
+		logger.Default().Warn("marshal server info failed", logger.Err(err))
 		return err
 	}
 	return ioutil.WriteFile("server_info.txt", marshal, fs.ModePerm)
 }
 
 // VerifyConnection 使用自定义方式校验证书，忽略主机名校验
+//
+// Deprecated: 使用tlsconfig.Settings，支持主机名校验和公钥固定
 func VerifyConnection(serverCaPool *x509.CertPool) func(stats tls.ConnectionState) error {
 	return func(stats tls.ConnectionState) error {
 		certificates := stats.PeerCertificates
 		if len(certificates) == 0 {
-			glog.Warningf("ssl cert is empty.")
+			logger.Default().Warn("ssl cert is empty")
 			return errors.New("cert is not suit")
 		}
 		opts := x509.VerifyOptions{
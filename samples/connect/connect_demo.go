@@ -29,14 +29,19 @@
 package main
 
 import (
+	"context"
+	"flag"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/golang/glog"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/constants"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/device"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/logger"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/transport"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/samples/test_util"
 	"os"
 	"os/signal"
+	"time"
 )
 
 // 使用秘钥接入
@@ -79,6 +84,35 @@ func connectWithX509Cert() {
 	mqttDevice.SendMessage(test_util.GenerateMessage())
 }
 
+// 使用MQTT5协议接入
+func connectWithMqtt5() {
+	authConfig := &config.ConnectAuthConfig{
+		Id:              "your device id",
+		Servers:         "mqtts://{MQTT_ACCESS_ADDRESS}:8883",
+		Secret:          "your Secret",
+		ServerCaPath:    "iotda server ca path",
+		ProtocolVersion: transport.ProtocolMqtt5,
+		SessionExpiry:   10 * time.Minute,
+	}
+	mqttDevice := device.NewMqttDevice(authConfig)
+	if mqttDevice == nil {
+		glog.Warningf("create mqtt device failed.")
+		return
+	}
+	initResult := mqttDevice.Connect()
+	glog.Info("connect result is : ", initResult)
+	// 上报携带用户属性和过期时间的消息
+	properties := &transport.Properties{
+		MessageExpiry:  time.Minute,
+		UserProperties: map[string]string{"source": "sdk"},
+	}
+	topic := "$oc/devices/" + authConfig.Id + "/sys/messages/up"
+	err := mqttDevice.Client.PublishMessageWithProperties(context.Background(), topic, 1, `{"content":"hello"}`, properties)
+	if err != nil {
+		glog.Warningf("publish message failed. err: %s", err.Error())
+	}
+}
+
 // 关闭自动重连，通过回调函数实现自定义重连。
 func connectWithRetry() {
 	var autoReconnect = true
@@ -105,6 +139,15 @@ func connectWithRetry() {
 	mqttDevice.Client.ConnectHandler = func(client mqtt.Client) {
 		glog.Infof("connect to server success.")
 	}
+	// 监听连接状态变化，可用于上报设备健康状态
+	events, unsubscribe := mqttDevice.SubscribeStateEvents(10)
+	defer unsubscribe()
+	go func() {
+		for event := range events {
+			glog.Infof("connection state %s -> %s, attempt: %d, next retry: %s, reason: %v",
+				event.From, event.To, event.Attempt, event.NextRetry, event.Reason)
+		}
+	}()
 	initResult := mqttDevice.Connect()
 	glog.Info("connect result is : ", initResult)
 	// 上报消息
@@ -118,5 +161,8 @@ func connectWithRetry() {
 }
 
 func main() {
+	// SDK默认不输出日志，此处使用glog输出SDK日志，也可以通过logger.NewSlogLogger接入slog
+	flag.Parse()
+	logger.SetDefault(logger.NewGlogLogger())
 	connectWithRetry()
 }
@@ -0,0 +1,80 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"context"
+	"github.com/golang/glog"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/device"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/transport/fake"
+	"time"
+)
+
+// 使用进程内的模拟平台验证设备命令处理流程，不需要连接真实的平台
+func main() {
+	platform := fake.NewPlatform()
+	authConfig := &config.ConnectAuthConfig{
+		Id:               "test device id",
+		Servers:          "tcp://fake-platform:1883",
+		Secret:           "test Secret",
+		TransportFactory: platform.Factory(),
+	}
+	mqttDevice := device.NewMqttDevice(authConfig)
+	if mqttDevice == nil {
+		glog.Warningf("create mqtt device failed.")
+		return
+	}
+	mqttDevice.Client.CommandHandler = func(command model.Command) (bool, interface{}) {
+		glog.Infof("receive command %s", command.CommandName)
+		return true, nil
+	}
+	if !mqttDevice.Connect() {
+		return
+	}
+
+	// 平台下发命令，并等待设备的命令响应
+	requestId, err := platform.SendCommand(authConfig.Id, model.Command{
+		ServiceId:   "switch",
+		CommandName: "turn_on",
+	})
+	if err != nil {
+		glog.Warningf("send command failed. err: %s", err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := platform.WaitPublished(ctx, "$oc/devices/"+authConfig.Id+"/sys/commands/response/request_id="+requestId)
+	if err != nil {
+		glog.Warningf("wait command response failed. err: %s", err.Error())
+		return
+	}
+	glog.Infof("command response: %s", string(response.Message.Payload))
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/golang/glog"
 	config2 "github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	device2 "github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/device"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/file"
 	"os"
 	"time"
 )
@@ -49,6 +50,27 @@ func main() {
 		glog.Warningf("create mqtt device failed.")
 		return
 	}
+	// 下载时每次请求1MB，失败重试5次，限速512KB/s，单个文件10分钟内未传输完成时取消
+	device.FileTransfer = file.TransferOptions{
+		ChunkSize:  1024 * 1024,
+		MaxRetries: 5,
+		RateLimit:  512 * 1024,
+		Timeout:    10 * time.Minute,
+		Progress: func(transferred, total int64) {
+			glog.V(1).Infof("transferred %d/%d", transferred, total)
+		},
+	}
+	// 接收平台主动下发的文件，校验通过后保存到received目录
+	device.Client.FileReceiver = file.NewReceiver(file.ReceiverOptions{
+		Dir: "received",
+		Handler: func(fileName, filePath string, err error) {
+			if err != nil {
+				glog.Warningf("receive file %s failed. err: %s", fileName, err.Error())
+				return
+			}
+			glog.Infof("receive file %s, saved to %s", fileName, filePath)
+		},
+	})
 	connect := device.Connect()
 	glog.Infof("connect result : %v", connect)
 	currentPath, err := os.Getwd()
@@ -63,4 +85,11 @@ func main() {
 	time.Sleep(10 * time.Second)
 	downloadFilePath := currentPath + "\\download\\test_download.txt"
 	device.DownloadFile(fileName, downloadFilePath)
+
+	// 将日志目录及轮转后的日志打包上传，原始大小超过10MB时只保留最新的文件
+	device.UploadArchive("app_logs.tar.gz", []string{"/var/log/app/*.log"}, file.ArchiveOptions{
+		Format:         file.ArchiveTarGz,
+		MaxBytes:       10 * 1024 * 1024,
+		IncludeRotated: true,
+	})
 }
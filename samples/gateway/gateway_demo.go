@@ -29,10 +29,12 @@
 package main
 
 import (
+	"context"
 	"github.com/golang/glog"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/gateway"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/ota"
 	"time"
 )
 
@@ -114,6 +116,28 @@ func syncSubDevices() {
 	time.Sleep(5 * time.Minute)
 }
 
+// 网关为子设备升级，同一升级包只下载一次
+func subDeviceUpgrade() {
+	gatewayDevice := createGateway()
+	if gatewayDevice == nil {
+		glog.Infof("create gateway device failed.")
+		return
+	}
+	// 平台查询子设备版本
+	gatewayDevice.Client.SubDeviceVersionReporter = func(deviceId string) (string, string) {
+		return "v1.0", "v1.0"
+	}
+	gatewayDevice.Client.SubDeviceOtaManager = ota.NewSubDeviceManager(ota.SubDeviceOptions{
+		Concurrency: 2,
+		Installer: ota.SubDeviceInstallerFunc(func(ctx context.Context, deviceId string, task ota.Task, packagePath string) error {
+			glog.Infof("install package %s to sub device %s, version: %s", packagePath, deviceId, task.Info.Version)
+			// transferToSubDevice()  通过串口或局域网将升级包传输给子设备
+			return nil
+		}),
+	})
+	time.Sleep(5 * time.Minute)
+}
+
 // 网关更新子设备状态
 func updateSubDeviceStats() {
 	gatewayDevice := createGateway()
@@ -133,14 +133,11 @@ func messageDeliveryPolicy() {
 		glog.Warningf("create mqtt device failed.")
 		return
 	}
-	// 可以在此处实现与平台建链后的一些自定义逻辑，比如建链后订阅一些自定义的topic
-	device.Client.ConnectHandler = func(client mqtt.Client) {
-		// 使用自定义topic接收平台下发的消息
-		device.Client.SubscribeCustomizeTopic("testdevicetopic", func(message string) bool {
-			glog.Infof("first callback called %s", message)
-			return true
-		})
-	}
+	// 使用自定义topic接收平台下发的消息，建链前订阅即可，断链重连后sdk会自动重新订阅
+	device.Client.SubscribeCustomizeTopic("testdevicetopic", func(message string) bool {
+		glog.Infof("first callback called %s", message)
+		return true
+	})
 
 	device.Connect()
 	// 向平台发送消息
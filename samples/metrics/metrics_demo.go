@@ -0,0 +1,70 @@
+// Copyright (c) 2023-2024 Huawei Cloud Computing Technology Co., Ltd. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of
+//    conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list
+//    of conditions and the following disclaimer in the documentation and/or other materials
+//    provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used
+//    to endorse or promote products derived from this software without specific prior written
+//    permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+// "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO,
+// THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR
+// PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR
+// CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL,
+// EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS;
+// OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+// WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR
+// OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF
+// ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"github.com/golang/glog"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/device"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/metrics/prometheus"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/samples/test_util"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+func main() {
+	// 注册SDK的指标，通过http://localhost:9100/metrics查看发布耗时、缓存消息数量、重连次数等指标
+	collector := prometheus.NewCollector()
+	prom.MustRegister(collector)
+	go func() {
+		http.Handle("/metrics", promhttp.Handler())
+		if err := http.ListenAndServe(":9100", nil); err != nil {
+			glog.Warningf("serve metrics failed. err: %s", err.Error())
+		}
+	}()
+
+	authConfig := &config.ConnectAuthConfig{
+		Id:               "your device id",
+		Servers:          "mqtts://{MQTT_ACCESS_ADDRESS}:8883",
+		Secret:           "your Secret",
+		ServerCaPath:     "iotda server ca path",
+		MaxBufferMessage: 100,
+		Metrics:          collector,
+	}
+	mqttDevice := device.NewMqttDevice(authConfig)
+	if mqttDevice == nil {
+		glog.Warningf("create mqtt device failed.")
+		return
+	}
+	initResult := mqttDevice.Connect()
+	glog.Info("connect result is : ", initResult)
+	mqttDevice.SendMessage(test_util.GenerateMessage())
+	select {}
+}
@@ -29,13 +29,15 @@
 package main
 
 import (
+	"context"
 	"github.com/golang/glog"
 	config2 "github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	device2 "github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/device"
-	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/file"
-	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/ota"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/tlsconfig"
 	"os"
 	"os/signal"
+	"time"
 )
 
 // ota升级
@@ -46,6 +48,10 @@ func otaUpgrade() {
 		Servers:      "mqtts://{MQTT_ACCESS_ADDRESS}:8883",
 		Secret:       "your Secret",
 		ServerCaPath: "iotda server ca path",
+		TLS: tlsconfig.Settings{
+			CaFiles:        []string{"obs server ca path"},
+			VerifyHostname: true,
+		},
 	}
 	device := device2.NewMqttDevice(authConfig)
 	if device == nil {
@@ -57,35 +63,37 @@ func otaUpgrade() {
 		return "v1.0", "v1.0"
 	}
 
-	// upgradeType 0： 软件升级  1： 固件升级  2: OBS软件升级  3:obs固件升级
-	device.Client.DeviceUpgradeHandler = func(upgradeType byte, info model.UpgradeInfo) model.UpgradeProgress {
-		glog.Infof("begin to handle upgrade process")
-		upgradeProcess := model.UpgradeProgress{}
-		currentPath, err := os.Getwd()
-		if err != nil {
-			glog.Warningf("get executable path failed. err: %s", err.Error())
-			upgradeProcess.ResultCode = 255
-			upgradeProcess.Description = "get executable path failed."
-			return upgradeProcess
-		}
-		currentPath = currentPath + "\\download\\ota.txt"
-		downloadFlag := file.CreateHttpClient().OTADownloadFile(upgradeType, currentPath, info.Url, info.AccessToken)
-		if !downloadFlag {
-			glog.Errorf("down load file { %s } failed", currentPath)
-			upgradeProcess.ResultCode = 10
-			upgradeProcess.Description = "down load ota package failed."
-			return upgradeProcess
-		}
-		glog.Infof("download file success.")
-		// checkPackage()  校验下载的升级包
-		// installPackage()  安装升级包
-		upgradeProcess.ResultCode = 0
-		upgradeProcess.Version = info.Version
-		upgradeProcess.Progress = 100
-		return upgradeProcess
-	}
+	// 由OtaManager下载升级包、校验Sign并上报进度，只需要实现安装逻辑，下载时按authConfig.TLS校验服务端证书
+	device.Client.OtaManager = ota.NewManager(ota.Options{
+		Dir: "download",
+		CurrentVersion: func(task ota.Task) string {
+			return "v1.0"
+		},
+		Installer: ota.InstallerFunc(func(ctx context.Context, task ota.Task, packagePath string) error {
+			glog.Infof("install package %s, firmware: %v, version: %s", packagePath, task.IsFirmware(), task.Info.Version)
+			// installPackage()  安装升级包到备用分区并切换启动分区后重启，返回ota.NewError可以指定上报的结果码
+			return nil
+		}),
+		// 支持差分包，返回当前运行的镜像文件
+		BaseImage: func(task ota.Task) (string, error) {
+			return "/opt/app/current.img", nil
+		},
+		// 重启后10分钟内没有调用Confirm则回滚并上报升级失败
+		ConfirmWindow: 10 * time.Minute,
+		Rollback: func(ctx context.Context, task ota.Task) error {
+			glog.Warningf("rollback version %s", task.Info.Version)
+			// switchBootSlot()  切换回原来的分区
+			return nil
+		},
+	})
 	connect := device.Connect()
 	glog.Infof("connect result : %v", connect)
+	// 新版本启动后自检通过，确认升级成功
+	if _, ok := device.Client.OtaManager.Pending(); ok {
+		if err := device.Client.OtaManager.Confirm(); err != nil {
+			glog.Warningf("confirm upgrade failed. err: %s", err.Error())
+		}
+	}
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 	for {
@@ -29,11 +29,15 @@
 package main
 
 import (
+	"context"
 	"github.com/golang/glog"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot"
 	config2 "github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/constants"
 	device2 "github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/device"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/model"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/rule"
+	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/store"
 	"github.com/huaweicloud/huaweicloud-iot-device-sdk-go/samples/test_model"
 	"os"
 	"os/signal"
@@ -49,6 +53,13 @@ func ruleManage() {
 		ServerCaPath: "iotda server ca path",
 	}
 	authConfig.RuleEnable = true
+	// 规则保存到本地文件，设备重启后即使无法连接平台也会执行已下发的规则
+	authConfig.RuleFilePath = "rules/device_rules.json"
+	// 规则的执行记录保存到本地文件，并作为$device_rule事件上报平台
+	authConfig.RuleExecFilePath = "rules/rule_executions.jsonl"
+	authConfig.RuleExecReport = constants.RuleExecutionReportEvent
+	// 平台下发的规则没有设置trigger时，条件持续满足10秒后执行一次，再次执行需要条件先变为不满足
+	authConfig.RuleTrigger = model.RuleTrigger{Mode: rule.TriggerModeEdge, Duration: 10}
 	device := device2.NewMqttDevice(authConfig)
 	if device == nil {
 		glog.Warningf("create mqtt device failed.")
@@ -63,6 +74,22 @@ func ruleManage() {
 			"cost_time": 12,
 		}
 	}
+	// FUNCTION类型的动作调用注册的本地函数
+	device.Client.RuleActionExecutors.RegisterFunction("openValve", func(ctx context.Context, params interface{}) error {
+		glog.Infof("open valve, params is %v", params)
+		return nil
+	})
+	// 覆盖内置的执行器或者支持新的动作类型
+	device.Client.RuleActionExecutors.Register("ALARM", func(ctx context.Context, action model.Action) error {
+		glog.Infof("rule %s raise alarm", action.RuleId)
+		return nil
+	})
+	// 每个动作的执行结果
+	device.Client.SetRuleActionResultHandler(func(results []model.RuleActionResult) {
+		for _, result := range results {
+			glog.Infof("rule %s action %s success: %v, error: %s", result.RuleId, result.Type, result.Success, result.Error)
+		}
+	})
 
 	connect := device.Connect()
 	glog.Infof("connect result : %v", connect)
@@ -85,6 +112,16 @@ func ruleManage() {
 		Services: content,
 	}
 	device.ReportProperties(services)
+
+	// 查询最近一小时的规则执行记录
+	executions, err := device.Client.RuleManageService.Executions(store.RuleExecutionQuery{Since: time.Now().Add(-time.Hour)})
+	if err != nil {
+		glog.Warningf("query rule executions failed: %v", err)
+	}
+	for _, execution := range executions {
+		glog.Infof("rule %s triggered by %s at %v, values: %v, success: %v", execution.RuleId, execution.Trigger, execution.Time,
+			execution.ConditionValues, execution.Success)
+	}
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 	for {
@@ -29,6 +29,7 @@
 package main
 
 import (
+	"context"
 	"github.com/golang/glog"
 	config2 "github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/config"
 	device2 "github.com/huaweicloud/huaweicloud-iot-device-sdk-go/iot/device"
@@ -50,7 +51,7 @@ func main() {
 	}
 	device.Client.SyncTimeResponseHandler = func(deviceSendTime, serverRecvTime, serverSendTime int64) {
 		deviceRecvTime := time.Now().UnixNano() / int64(time.Millisecond)
-		now := (serverRecvTime + serverSendTime + deviceRecvTime + deviceSendTime) / 2
+		now := (serverRecvTime + serverSendTime + deviceRecvTime - deviceSendTime) / 2
 		glog.Infof("now is %d", now)
 	}
 	connect := device.Connect()
@@ -58,4 +59,14 @@ func main() {
 	sync := device.RequestTimeSync()
 	glog.Infof("sync time result: %v", sync)
 	time.Sleep(10 * time.Second)
+
+	// 同步等待平台响应，直接获取平台时间与本地时间的差值
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	offset, err := device.RequestTimeSyncSync(ctx)
+	if err != nil {
+		glog.Warningf("sync time failed. err: %s", err.Error())
+		return
+	}
+	glog.Infof("platform time is %s", time.Now().Add(offset))
 }